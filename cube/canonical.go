@@ -0,0 +1,218 @@
+package cube
+
+import (
+	"github.com/davidminor/uint128"
+	"golang.org/x/exp/slices"
+	"math/bits"
+	"strings"
+)
+
+// The id of a cube is read in each of the 24 orientations given by idTranslations, as 48
+// base 6 digits with each colour named after the face whose centre it is. The cube's id is
+// the lowest of these, so cubes that are rotations of each other share an id. With reflections
+// the orientations of the cube's mirror image are read too. The digits are compared as they're
+// read, so most orientations are given up on after the first few stickers
+
+// idDigits are the digits of an id, most significant first. Comparing digits in order
+// compares the ids
+type idDigits [48]uint8
+
+// idStickers are the stickers read for each digit in each orientation, and idCentres are the
+// centres whose colours name the faces
+var idStickers, idCentres = func() (stickers [24][48]uint8, centres [24][6]uint8) {
+	for k, translation := range idTranslations {
+		d := 0
+		for i, sticker := range translation {
+			if slices.Contains(faceCenters, i) {
+				continue
+			}
+			stickers[k][d] = uint8(sticker)
+			d++
+		}
+		for face, centre := range faceCenters {
+			centres[k][face] = uint8(translation[centre])
+		}
+	}
+	return stickers, centres
+}()
+
+// idRotations are the rotations EncodeCube returns for each orientation
+var idRotations = func() (rotations [24]string) {
+	for k, transform := range idTranslationTransforms {
+		rotations[k] = strings.ToLower(transform)
+	}
+	return rotations
+}()
+
+// pow6to24 is the value of the 24th digit from the end of an id
+const pow6to24 = 4738381338321616896
+
+// idColours are the colours of the layout, anything that isn't a colour is read as colour 6
+func (cube *Cube) idColours() (colours [54]uint8) {
+	for i, colour := range cube.Layout {
+		if colour < 0 || colour > 5 {
+			colour = 6
+		}
+		colours[i] = uint8(colour)
+	}
+	return colours
+}
+
+// faceNames maps each colour to the face whose centre it is in orientation k, colours that
+// aren't on a centre are named 0
+func faceNames(colours *[54]uint8, k int) (names [7]uint8) {
+	for face, centre := range idCentres[k] {
+		names[colours[centre]] = uint8(face)
+	}
+	return names
+}
+
+// orientationDigits reads the id of the cube in orientation k
+func orientationDigits(colours *[54]uint8, k int) (digits idDigits) {
+	names := faceNames(colours, k)
+	for d, sticker := range idStickers[k] {
+		digits[d] = names[colours[sticker]]
+	}
+	return digits
+}
+
+func (digits *idDigits) id() uint128.Uint128 {
+	var high, low uint64
+	for _, digit := range digits[:24] {
+		high = high*6 + uint64(digit)
+	}
+	for _, digit := range digits[24:] {
+		low = low*6 + uint64(digit)
+	}
+	h, l := bits.Mul64(high, pow6to24)
+	l, carry := bits.Add64(l, low, 0)
+	return uint128.Uint128{H: h + carry, L: l}
+}
+
+// highestDigits are higher than any id, so every orientation is lower
+var highestDigits = func() (digits idDigits) {
+	for d := range digits {
+		digits[d] = 0xff
+	}
+	return digits
+}()
+
+// lowestOrientation lowers best to the lowest id of the orientations of colours, giving the
+// orientation it was read in, or -1 if none were lower
+func lowestOrientation(colours *[54]uint8, best *idDigits) int {
+	bestK := -1
+orientations:
+	for k := range idStickers {
+		names := faceNames(colours, k)
+		stickers := &idStickers[k]
+		d := 0
+		for ; d < len(stickers); d++ {
+			digit := names[colours[stickers[d]]]
+			if digit > best[d] {
+				continue orientations
+			}
+			if digit < best[d] {
+				break
+			}
+		}
+		if d == len(stickers) {
+			// the same id as an earlier orientation, which is kept
+			continue
+		}
+		for ; d < len(stickers); d++ {
+			best[d] = names[colours[stickers[d]]]
+		}
+		bestK = k
+	}
+	return bestK
+}
+
+// EncodeCube gives the id shared by the cube and its rotations, and the rotation that turns the
+// cube into the orientation the id is read in
+func (cube *Cube) EncodeCube() (uint128.Uint128, string) {
+	colours := cube.idColours()
+	return encodeColours(&colours)
+}
+
+func encodeColours(colours *[54]uint8) (uint128.Uint128, string) {
+	best := highestDigits
+	k := lowestOrientation(colours, &best)
+	return best.id(), idRotations[k]
+}
+
+// EncodeCubeWithReflections gives the id shared by the cube, its rotations and their mirror
+// images. The rotation starts with Reflection if the id is read from the mirror image
+func (cube *Cube) EncodeCubeWithReflections() (uint128.Uint128, string) {
+	colours := cube.idColours()
+	return encodeColoursWithReflections(&colours)
+}
+
+func encodeColoursWithReflections(colours *[54]uint8) (uint128.Uint128, string) {
+	best := highestDigits
+	rotation := idRotations[lowestOrientation(colours, &best)]
+	// colours are named after the centres, so the L and R colours don't need swapping
+	var reflected [54]uint8
+	for i, colour := range colours {
+		reflected[reflectedSticker[i]] = colour
+	}
+	if k := lowestOrientation(&reflected, &best); k >= 0 {
+		rotation = string(Reflection) + idRotations[k]
+	}
+	return best.id(), rotation
+}
+
+// reflectedSticker is where each sticker is moved to by a reflection through the plane between L and R
+var reflectedSticker = func() (reflected [54]uint8) {
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			// U and D are laid out with L on the left, so only their columns are swapped
+			reflected[3*row+col] = uint8(3*row + 2 - col)
+			reflected[45+3*row+col] = uint8(45 + 3*row + 2 - col)
+			// the middle rows go round L, F, R then B, so L and R swap with each other
+			middle := 9 + 12*row
+			reflected[middle+col] = uint8(middle + 8 - col)
+			reflected[middle+3+col] = uint8(middle + 5 - col)
+			reflected[middle+6+col] = uint8(middle + 2 - col)
+			reflected[middle+9+col] = uint8(middle + 11 - col)
+		}
+	}
+	return reflected
+}()
+
+// Reflect gives the mirror image of the cube through the plane between L and R. The L and R
+// colours of NewSolvedCube are swapped as well, so the colours stay in the same scheme and the
+// reflection of a solved cube is solved. If transform gives a cube,
+// RotateTransform(string(Reflection), transform) gives its reflection
+func (cube *Cube) Reflect() *Cube {
+	var layout [54]int
+	for i, colour := range cube.Layout {
+		switch colour {
+		case 1:
+			colour = 3
+		case 3:
+			colour = 1
+		}
+		layout[reflectedSticker[i]] = colour
+	}
+	return NewCube(layout)
+}
+
+// GetNonSymmetricalRotations gives a rotation for each distinct orientation of the cube, a cube
+// with no symmetry has 24 and the solved cube has 1
+func (cube *Cube) GetNonSymmetricalRotations() []string {
+	colours := cube.idColours()
+	return nonSymmetricalRotations(&colours)
+}
+
+func nonSymmetricalRotations(colours *[54]uint8) []string {
+	var rotations []string
+	var seen []idDigits
+	for k := range idStickers {
+		digits := orientationDigits(colours, k)
+		if !slices.Contains(seen, digits) {
+			rotations = append(rotations, idTranslationTransforms[k])
+			seen = append(seen, digits)
+		}
+	}
+	return rotations
+}
@@ -0,0 +1,229 @@
+package cube
+
+import (
+	"github.com/davidminor/uint128"
+	"golang.org/x/exp/slices"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeCubeByMaps and nonSymmetricalRotationsByMaps read every orientation in full with a
+// map of colours, the way cubes were encoded before the digits were compared as they're read
+
+func (cube *Cube) encodeCubeByMaps() (uint128.Uint128, string) {
+	lowestId := uint128.Uint128{H: ^uint64(0), L: ^uint64(0)}
+	lowestIdRotation := 0
+	for i, translation := range idTranslations {
+		thisId := uint128.Uint128{}
+		thisMap := map[int]int{
+			cube.Layout[translation[4]]:  0,
+			cube.Layout[translation[22]]: 1,
+			cube.Layout[translation[25]]: 2,
+			cube.Layout[translation[28]]: 3,
+			cube.Layout[translation[31]]: 4,
+			cube.Layout[translation[49]]: 5,
+		}
+		for i, t := range translation {
+			if slices.Contains(faceCenters, i) {
+				continue
+			}
+			colour := cube.Layout[t]
+			mappedColour, success := thisMap[colour]
+			_ = success
+			thisId = thisId.Mult(uint128.Uint128{L: 6})
+			thisId = thisId.Add(uint128.Uint128{L: uint64(mappedColour)})
+		}
+		if thisId.H < lowestId.H || (thisId.H == lowestId.H && thisId.L < lowestId.L) {
+			lowestId = thisId
+			lowestIdRotation = i
+		}
+	}
+	return lowestId, strings.ToLower(idTranslationTransforms[lowestIdRotation])
+}
+
+func (cube *Cube) nonSymmetricalRotationsByMaps() []string {
+	var rotations []string
+	var ids []uint128.Uint128
+	for i, translation := range idTranslations {
+		thisId := uint128.Uint128{}
+		thisMap := map[int]int{
+			cube.Layout[translation[4]]:  0,
+			cube.Layout[translation[22]]: 1,
+			cube.Layout[translation[25]]: 2,
+			cube.Layout[translation[28]]: 3,
+			cube.Layout[translation[31]]: 4,
+			cube.Layout[translation[49]]: 5,
+		}
+		for i, t := range translation {
+			if slices.Contains(faceCenters, i) {
+				continue
+			}
+			colour := cube.Layout[t]
+			mappedColour, success := thisMap[colour]
+			_ = success
+			thisId = thisId.Mult(uint128.Uint128{L: 6})
+			thisId = thisId.Add(uint128.Uint128{L: uint64(mappedColour)})
+		}
+		unseenId := true
+		for _, id := range ids {
+			if thisId.Equals(id) {
+				unseenId = false
+				break
+			}
+		}
+		if unseenId {
+			rotations = append(rotations, idTranslationTransforms[i])
+			ids = append(ids, thisId)
+		}
+	}
+	return rotations
+}
+
+// symmetricalSetups give cubes with some rotational symmetry, where orientations share ids
+var symmetricalSetups = []string{"", "RRLLUUDDFFBB", "UUDD", "UdFbRl", "RLFBUDRL", "MMEESS", "XY", "UD", "RLRLRL"}
+
+func TestCube_EncodeCube_MatchesMaps(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	setups := append([]string{}, symmetricalSetups...)
+	for i := 0; i < 2000; i++ {
+		setups = append(setups, randomTransform(r, r.Intn(25)))
+	}
+	for _, setup := range setups {
+		c := NewSolvedCube()
+		c.Transform(setup)
+		id, rotation := c.EncodeCube()
+		expectedId, expectedRotation := c.encodeCubeByMaps()
+		if !id.Equals(expectedId) || rotation != expectedRotation {
+			t.Fatalf("%s encoded to %v %s rather than %v %s", setup, id, rotation, expectedId, expectedRotation)
+		}
+		rotations := c.GetNonSymmetricalRotations()
+		if expected := c.nonSymmetricalRotationsByMaps(); !reflect.DeepEqual(rotations, expected) {
+			t.Fatalf("%s has rotations %v rather than %v", setup, rotations, expected)
+		}
+	}
+}
+
+func TestCube_EncodeCube_MaxId(t *testing.T) {
+	var digits idDigits
+	for d := range digits {
+		digits[d] = 5
+	}
+	// 6^48 - 1
+	max := uint128.Uint128{H: 0x10e425c56daffabc, L: 0x35c0ffffffffffff}
+	if id := digits.id(); !id.Equals(max) {
+		t.Errorf("The largest id should be %v rather than %v", max, id)
+	}
+}
+
+func BenchmarkCube_EncodeCube(b *testing.B) {
+	cubes := randomCubes(rand.New(rand.NewSource(8)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cubes[i%len(cubes)].EncodeCube()
+	}
+}
+
+// BenchmarkCube_EncodeCubeByMaps encodes the same cubes as BenchmarkCube_EncodeCube the old way
+func BenchmarkCube_EncodeCubeByMaps(b *testing.B) {
+	cubes := randomCubes(rand.New(rand.NewSource(8)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cubes[i%len(cubes)].encodeCubeByMaps()
+	}
+}
+
+func BenchmarkCube_GetNonSymmetricalRotations(b *testing.B) {
+	cubes := randomCubes(rand.New(rand.NewSource(8)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cubes[i%len(cubes)].GetNonSymmetricalRotations()
+	}
+}
+
+func randomCubes(r *rand.Rand) []*Cube {
+	cubes := make([]*Cube, 1024)
+	for i := range cubes {
+		cubes[i] = NewSolvedCube()
+		cubes[i].Transform(randomTransform(r, 20))
+	}
+	return cubes
+}
+
+func TestCube_Reflect(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < 500; i++ {
+		transform := randomTransform(r, r.Intn(25)) + "RwlwUwdwFwbw"[2*r.Intn(6):][:2]
+		c := NewSolvedCube()
+		c.Transform(transform)
+		d := NewSolvedCube()
+		d.Transform(RotateTransform(string(Reflection), transform))
+		if c.Reflect().Layout != d.Layout {
+			t.Fatalf("Reflecting %s should give the cube from %s", transform, RotateTransform(string(Reflection), transform))
+		}
+		if c.Reflect().Reflect().Layout != c.Layout {
+			t.Fatalf("Reflecting %s twice should give the same cube", transform)
+		}
+	}
+	if RotateTransform(string(Reflection), "LRUMXRwFSb") != "rluMXlwfsB" {
+		t.Errorf("Reflecting should swap L and R and reverse every move but M and X, but gave %s", RotateTransform(string(Reflection), "LRUMXRwFSb"))
+	}
+}
+
+func TestCube_EncodeCubeWithReflections(t *testing.T) {
+	r := rand.New(rand.NewSource(10))
+	reflectedRotations := 0
+	for i := 0; i < 1000; i++ {
+		transform := randomTransform(r, r.Intn(25))
+		c := NewSolvedCube()
+		c.Transform(transform)
+		id, rotation := c.EncodeCubeWithReflections()
+		if reflectedId, _ := c.Reflect().EncodeCubeWithReflections(); !reflectedId.Equals(id) {
+			t.Fatalf("%s and its reflection should share an id", transform)
+		}
+		if rotationId, _ := c.EncodeCube(); rotationId.H < id.H || (rotationId.H == id.H && rotationId.L < id.L) {
+			t.Fatalf("%s has a lower id without reflections", transform)
+		}
+		if strings.HasPrefix(rotation, string(Reflection)) {
+			reflectedRotations++
+		}
+
+		// the solution is stored for the decoded cube the way the generator does it, then looked up
+		solution := RotateTransform(ReverseTransform(rotation), ReverseTransform(transform))
+		decoded := DecodeCube(id)
+		decoded.Transform(solution)
+		if !decoded.IsSolved() {
+			t.Fatalf("The solution stored for %s doesn't solve the cube decoded from its id", transform)
+		}
+		c.Transform(RotateTransform(rotation, solution))
+		if !c.IsSolved() {
+			t.Fatalf("The solution looked up for %s doesn't solve it", transform)
+		}
+	}
+	if reflectedRotations == 0 {
+		t.Errorf("Some ids should be read from the reflection")
+	}
+	if id, rotation := NewSolvedCube().EncodeCubeWithReflections(); !id.Equals(SolvedCubeId) || rotation != "" {
+		t.Errorf("The solved cube should keep its id rather than %v %s", id, rotation)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	for _, encoding := range []Encoding{RotationEncoding, ReflectionEncoding} {
+		if parsed, err := ParseEncoding(encoding.String()); err != nil || parsed != encoding {
+			t.Errorf("%s should parse to itself", encoding)
+		}
+	}
+	if _, err := ParseEncoding("mirrors"); err == nil {
+		t.Errorf("mirrors isn't an encoding")
+	}
+}
+
+func BenchmarkCube_EncodeCubeWithReflections(b *testing.B) {
+	cubes := randomCubes(rand.New(rand.NewSource(8)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cubes[i%len(cubes)].EncodeCubeWithReflections()
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"github.com/davidminor/uint128"
 	"golang.org/x/exp/slices"
+	"math/bits"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Cube struct {
@@ -336,6 +338,74 @@ var zRotationMap = map[int]int{
 
 var ziRotationMap = inverseTransformMap(zRotationMap)
 
+// middle slice, turns in the same direction as L
+var mRotationMap = map[int]int{
+	1:  13,
+	13: 46,
+	46: 43,
+	43: 1,
+	4:  25,
+	25: 49,
+	49: 31,
+	31: 4,
+	7:  37,
+	37: 52,
+	52: 19,
+	19: 7,
+}
+
+var miRotationMap = inverseTransformMap(mRotationMap)
+
+// equatorial slice, turns in the same direction as D
+var eRotationMap = map[int]int{
+	21: 24,
+	24: 27,
+	27: 30,
+	30: 21,
+	22: 25,
+	25: 28,
+	28: 31,
+	31: 22,
+	23: 26,
+	26: 29,
+	29: 32,
+	32: 23,
+}
+
+var eiRotationMap = inverseTransformMap(eRotationMap)
+
+// standing slice, turns in the same direction as F
+var sRotationMap = map[int]int{
+	3:  16,
+	16: 50,
+	50: 34,
+	34: 3,
+	4:  28,
+	28: 49,
+	49: 22,
+	22: 4,
+	5:  40,
+	40: 48,
+	48: 10,
+	10: 5,
+}
+
+var siRotationMap = inverseTransformMap(sRotationMap)
+
+// wide moves turn an outer face and the slice next to it, which never share stickers
+var fwRotationMap = unionTransformMaps(fRotationMap, sRotationMap)
+var fwiRotationMap = inverseTransformMap(fwRotationMap)
+var lwRotationMap = unionTransformMaps(lRotationMap, mRotationMap)
+var lwiRotationMap = inverseTransformMap(lwRotationMap)
+var rwRotationMap = unionTransformMaps(rRotationMap, miRotationMap)
+var rwiRotationMap = inverseTransformMap(rwRotationMap)
+var bwRotationMap = unionTransformMaps(bRotationMap, siRotationMap)
+var bwiRotationMap = inverseTransformMap(bwRotationMap)
+var uwRotationMap = unionTransformMaps(uRotationMap, eiRotationMap)
+var uwiRotationMap = inverseTransformMap(uwRotationMap)
+var dwRotationMap = unionTransformMaps(dRotationMap, eRotationMap)
+var dwiRotationMap = inverseTransformMap(dwRotationMap)
+
 func inverseTransformMap(m map[int]int) map[int]int {
 	n := make(map[int]int, len(m))
 	for k, v := range m {
@@ -344,6 +414,17 @@ func inverseTransformMap(m map[int]int) map[int]int {
 	return n
 }
 
+func unionTransformMaps(a, b map[int]int) map[int]int {
+	n := make(map[int]int, len(a)+len(b))
+	for k, v := range a {
+		n[k] = v
+	}
+	for k, v := range b {
+		n[k] = v
+	}
+	return n
+}
+
 func NewSolvedCube() *Cube {
 	return &Cube{
 		Layout:         [54]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4, 4, 1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4, 4, 1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4, 4, 5, 5, 5, 5, 5, 5, 5, 5, 5},
@@ -358,69 +439,45 @@ func NewCube(layout [54]int) *Cube {
 	}
 }
 
-// maintains an older previous state to save on creating a new array each transform
-func (cube *Cube) applyTransformMap(transformMap map[int]int) {
-	for i := 0; i < 54; i++ {
-		newIndex, exists := transformMap[i]
-		if exists {
-			cube.previousLayout[newIndex] = cube.Layout[i] // loads into old state
-		} else {
-			cube.previousLayout[i] = cube.Layout[i] // loads into old state
-		}
-	}
-	// swaps old state and new state
-	cube.previousLayout, cube.Layout = cube.Layout, cube.previousLayout
-}
-
 func (cube *Cube) transform(t string) {
-	switch t {
-	case "F":
-		cube.applyTransformMap(fRotationMap)
-	case "f":
-		cube.applyTransformMap(fiRotationMap)
-	case "L":
-		cube.applyTransformMap(lRotationMap)
-	case "l":
-		cube.applyTransformMap(liRotationMap)
-	case "R":
-		cube.applyTransformMap(rRotationMap)
-	case "r":
-		cube.applyTransformMap(riRotationMap)
-	case "B":
-		cube.applyTransformMap(bRotationMap)
-	case "b":
-		cube.applyTransformMap(biRotationMap)
-	case "U":
-		cube.applyTransformMap(uRotationMap)
-	case "u":
-		cube.applyTransformMap(uiRotationMap)
-	case "D":
-		cube.applyTransformMap(dRotationMap)
-	case "d":
-		cube.applyTransformMap(diRotationMap)
-	case "X":
-		cube.applyTransformMap(xRotationMap)
-	case "x":
-		cube.applyTransformMap(xiRotationMap)
-	case "Y":
-		cube.applyTransformMap(yRotationMap)
-	case "y":
-		cube.applyTransformMap(yiRotationMap)
-	case "Z":
-		cube.applyTransformMap(zRotationMap)
-	case "z":
-		cube.applyTransformMap(ziRotationMap)
-	default:
+	p := permutationOf(t)
+	if p == nil {
 		fmt.Printf("Invalid Transform :%v\n", t)
+		return
 	}
+	cube.applyPermutation(p)
 }
 
+// Transform applies a sequence of moves. Each move is a single rune, upper case for
+// clockwise and lower case for anticlockwise, except wide moves which are a face
+// followed by 'w' e.g. "Rw" or "rw"
 func (cube *Cube) Transform(t string) {
-	for _, t := range strings.Split(t, "") {
-		cube.transform(t)
+	// moves are sliced out of t as SplitTransform would split them, without allocating
+	for i := 0; i < len(t); {
+		_, size := utf8.DecodeRuneInString(t[i:])
+		if i+size < len(t) && t[i+size] == 'w' {
+			size++
+		}
+		cube.transform(t[i : i+size])
+		i += size
 	}
 }
 
+// SplitTransform splits a transform string into its individual moves
+func SplitTransform(transform string) []string {
+	runes := []rune(transform)
+	moves := make([]string, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if i+1 < len(runes) && runes[i+1] == 'w' {
+			moves = append(moves, string(runes[i:i+2]))
+			i++
+		} else {
+			moves = append(moves, string(runes[i]))
+		}
+	}
+	return moves
+}
+
 var idTranslations = [24][54]int{
 	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53},
 	{33, 21, 9, 34, 22, 10, 35, 23, 11, 51, 48, 45, 36, 24, 12, 6, 3, 0, 20, 32, 44, 52, 49, 46, 37, 25, 13, 7, 4, 1, 19, 31, 43, 53, 50, 47, 38, 26, 14, 8, 5, 2, 18, 30, 42, 39, 27, 15, 40, 28, 16, 41, 29, 17},
@@ -454,37 +511,23 @@ var idTranslationTransforms = [24]string{
 
 var faceCenters = []int{4, 22, 25, 28, 31, 49}
 
-func (cube *Cube) EncodeCube() (uint128.Uint128, string) {
-	lowestId := uint128.Uint128{H: ^uint64(0), L: ^uint64(0)}
-	lowestIdRotation := 0
-	for i, translation := range idTranslations {
-		thisId := uint128.Uint128{}
-		thisMap := map[int]int{
-			cube.Layout[translation[4]]:  0,
-			cube.Layout[translation[22]]: 1,
-			cube.Layout[translation[25]]: 2,
-			cube.Layout[translation[28]]: 3,
-			cube.Layout[translation[31]]: 4,
-			cube.Layout[translation[49]]: 5,
-		}
-		for i, t := range translation {
-			if slices.Contains(faceCenters, i) {
-				continue
-			}
-			colour := cube.Layout[t]
-			mappedColour, success := thisMap[colour]
-			if !success {
-				_ = fmt.Errorf("can't map colour %d, %d should be < 6", colour, colour)
-			}
-			thisId = thisId.Mult(uint128.Uint128{L: 6})
-			thisId = thisId.Add(uint128.Uint128{L: uint64(mappedColour)})
-		}
-		if thisId.H < lowestId.H || (thisId.H == lowestId.H && thisId.L < lowestId.L) {
-			lowestId = thisId
-			lowestIdRotation = i
+// DecodeCube is the inverse of EncodeCube. The cube is rebuilt in the orientation its id was taken
+// in, with each colour named after the face whose centre it is in that orientation
+func DecodeCube(id uint128.Uint128) *Cube {
+	var layout [54]int
+	hi, lo := id.H, id.L
+	for i := 53; i >= 0; i-- {
+		if centre := slices.Index(faceCenters, i); centre >= 0 {
+			// the centres are mapped to their face's colour when encoding
+			layout[i] = centre
+			continue
 		}
+		var rem uint64
+		hi, rem = hi/6, hi%6
+		lo, rem = bits.Div64(rem, lo, 6)
+		layout[i] = int(rem)
 	}
-	return lowestId, strings.ToLower(idTranslationTransforms[lowestIdRotation])
+	return NewCube(layout)
 }
 
 func (cube *Cube) IsSolved() bool {
@@ -492,46 +535,6 @@ func (cube *Cube) IsSolved() bool {
 	return cubeId.Equals(SolvedCubeId)
 }
 
-func (cube *Cube) GetNonSymmetricalRotations() []string {
-	var rotations []string
-	var ids []uint128.Uint128
-	for i, translation := range idTranslations {
-		thisId := uint128.Uint128{}
-		thisMap := map[int]int{
-			cube.Layout[translation[4]]:  0,
-			cube.Layout[translation[22]]: 1,
-			cube.Layout[translation[25]]: 2,
-			cube.Layout[translation[28]]: 3,
-			cube.Layout[translation[31]]: 4,
-			cube.Layout[translation[49]]: 5,
-		}
-		for i, t := range translation {
-			if slices.Contains(faceCenters, i) {
-				continue
-			}
-			colour := cube.Layout[t]
-			mappedColour, success := thisMap[colour]
-			if !success {
-				_ = fmt.Errorf("can't map colour %d, %d should be < 6", colour, colour)
-			}
-			thisId = thisId.Mult(uint128.Uint128{L: 6})
-			thisId = thisId.Add(uint128.Uint128{L: uint64(mappedColour)})
-		}
-		unseenId := true
-		for _, id := range ids {
-			if thisId.Equals(id) {
-				unseenId = false
-				break
-			}
-		}
-		if unseenId {
-			rotations = append(rotations, idTranslationTransforms[i])
-			ids = append(ids, thisId)
-		}
-	}
-	return rotations
-}
-
 var xRotationTransform = map[rune]rune{
 	'L': 'L',
 	'R': 'R',
@@ -582,6 +585,78 @@ var rotationMap = map[rune]map[rune]rune{
 	'z': ziRotationTransform,
 }
 
+// axisMoves are the slices and whole cube rotations, each of which turns in the same
+// direction as one of the outer faces
+var axisMoves = map[rune]rune{
+	'M': 'L',
+	'E': 'D',
+	'S': 'F',
+	'X': 'R',
+	'Y': 'U',
+	'Z': 'F',
+}
+
+type axisMove struct {
+	move     rune
+	inverted bool
+}
+
+var faceSlices = map[rune]axisMove{
+	'L': {'M', false},
+	'R': {'M', true},
+	'D': {'E', false},
+	'U': {'E', true},
+	'F': {'S', false},
+	'B': {'S', true},
+}
+
+var faceRotations = map[rune]axisMove{
+	'R': {'X', false},
+	'L': {'X', true},
+	'U': {'Y', false},
+	'D': {'Y', true},
+	'F': {'Z', false},
+	'B': {'Z', true},
+}
+
+// rotateMove maps a single move through faceMap, keeping its direction. Slices and
+// rotations are mapped using the face they turn with, so M under a y rotation is S
+func rotateMove(faceMap map[rune]rune, move string) string {
+	moveRunes := []rune(move)
+	char := moveRunes[0]
+	upperChar := unicode.ToUpper(char)
+	if axisFace, isAxisMove := axisMoves[upperChar]; isAxisMove {
+		axisMap := faceSlices
+		if _, isRotation := rotationMap[char]; isRotation {
+			axisMap = faceRotations
+		}
+		mapped := axisMap[faceMap[axisFace]]
+		if unicode.IsUpper(char) != mapped.inverted {
+			return string(mapped.move)
+		}
+		return string(unicode.ToLower(mapped.move))
+	}
+	moveRunes[0] = faceMap[upperChar]
+	if unicode.IsLower(char) {
+		moveRunes[0] = unicode.ToLower(moveRunes[0])
+	}
+	return string(moveRunes)
+}
+
+// Reflection can be part of the rotation given to RotateTransform. It mirrors the cube through
+// the plane between L and R, which swaps the L and R faces and reverses the direction of every
+// move except M and X
+const Reflection = '|'
+
+var reflectionTransform = map[rune]rune{
+	'F': 'F',
+	'B': 'B',
+	'U': 'U',
+	'D': 'D',
+	'L': 'R',
+	'R': 'L',
+}
+
 func RotateTransform(rotation, transform string) string {
 	faceMapA := map[rune]rune{
 		'F': 'F',
@@ -592,8 +667,13 @@ func RotateTransform(rotation, transform string) string {
 		'D': 'D',
 	}
 	faceMapB := make(map[rune]rune, 6)
+	reflected := false
 	for _, char := range []rune(rotation) {
 		m, validRotation := rotationMap[char]
+		if char == Reflection {
+			m, validRotation = reflectionTransform, true
+			reflected = !reflected
+		}
 		if !validRotation {
 			continue
 		}
@@ -603,26 +683,27 @@ func RotateTransform(rotation, transform string) string {
 		faceMapA, faceMapB = faceMapB, faceMapA
 	}
 	sb := strings.Builder{}
-	for _, char := range []rune(transform) {
-		if unicode.IsUpper(char) {
-			sb.WriteRune(faceMapA[char])
-		} else {
-			sb.WriteRune(unicode.ToLower(faceMapA[unicode.ToUpper(char)]))
+	for _, move := range SplitTransform(transform) {
+		move = rotateMove(faceMapA, move)
+		if reflected {
+			move = ReverseTransform(move)
 		}
+		sb.WriteString(move)
 	}
 	return sb.String()
 }
 
 func ReverseTransform(transform string) string {
 	res := strings.Builder{}
-	transformRunes := []rune(transform)
-	for i := len(transform) - 1; i >= 0; i-- {
-		c := transformRunes[i]
-		if unicode.IsUpper(c) {
-			res.WriteRune(unicode.ToLower(c))
+	moves := SplitTransform(transform)
+	for i := len(moves) - 1; i >= 0; i-- {
+		moveRunes := []rune(moves[i])
+		if unicode.IsUpper(moveRunes[0]) {
+			moveRunes[0] = unicode.ToLower(moveRunes[0])
 		} else {
-			res.WriteRune(unicode.ToUpper(c))
+			moveRunes[0] = unicode.ToUpper(moveRunes[0])
 		}
+		res.WriteString(string(moveRunes))
 	}
 	return res.String()
 }
@@ -638,7 +719,8 @@ func RemoveRotationTransforms(transform string) string {
 		'D': 'D',
 	}
 	faceMapB := make(map[rune]rune, 6)
-	for _, char := range []rune(transform) {
+	for _, move := range SplitTransform(transform) {
+		char := []rune(move)[0]
 		_, validRotation := rotationMap[char]
 		if validRotation {
 			var m map[rune]rune
@@ -652,11 +734,7 @@ func RemoveRotationTransforms(transform string) string {
 			}
 			faceMapA, faceMapB = faceMapB, faceMapA
 		} else { // must be a transform
-			if unicode.IsUpper(char) {
-				result.WriteRune(faceMapA[char])
-			} else {
-				result.WriteRune(unicode.ToLower(faceMapA[unicode.ToUpper(char)]))
-			}
+			result.WriteString(rotateMove(faceMapA, move))
 		}
 
 	}
@@ -3,9 +3,12 @@ package cube
 import (
 	"flag"
 	"fmt"
+	"golang.org/x/exp/slices"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
+	"unicode"
 )
 
 var _ = flag.String("db", "", "unused flag to allow testing of all packages with one command")
@@ -165,3 +168,145 @@ func TestRemoveRotationTransforms(t *testing.T) {
 		}
 	}
 }
+
+func TestCube_SliceMoves(t *testing.T) {
+	tests := [][2]string{
+		{"M", "xlR"},
+		{"m", "XLr"},
+		{"E", "yUd"},
+		{"e", "YuD"},
+		{"S", "ZfB"},
+		{"s", "zFb"},
+		{"MMMM", ""},
+		{"MmEeSs", ""},
+	}
+	for _, test := range tests {
+		c := newTestingCube()
+		c.Transform(test[0])
+		d := newTestingCube()
+		d.Transform(test[1])
+		if !reflect.DeepEqual(c.Layout, d.Layout) {
+			t.Errorf("%s should be equivalent to %s", test[0], test[1])
+		}
+	}
+}
+
+func TestCube_WideMoves(t *testing.T) {
+	tests := [][2]string{
+		{"Rw", "Rm"}, {"Rw", "rrrm"}, {"Rw", "LX"},
+		{"rw", "rM"}, {"Lw", "LM"}, {"lw", "lm"},
+		{"Uw", "Ue"}, {"uw", "uE"}, {"Dw", "DE"},
+		{"dw", "de"}, {"Fw", "FS"}, {"fw", "fs"},
+		{"Bw", "Bs"}, {"bw", "bS"}, {"Bw", "Fz"},
+	}
+	for _, test := range tests {
+		c := newTestingCube()
+		c.Transform(test[0])
+		d := newTestingCube()
+		d.Transform(test[1])
+		if !reflect.DeepEqual(c.Layout, d.Layout) {
+			t.Errorf("%s should be equivalent to %s", test[0], test[1])
+		}
+	}
+}
+
+func TestSplitTransform(t *testing.T) {
+	moves := SplitTransform("RwUmrwXfw")
+	if !reflect.DeepEqual(moves, []string{"Rw", "U", "m", "rw", "X", "fw"}) {
+		t.Errorf("Split into %v", moves)
+	}
+}
+
+func TestReverseTransform_SlicesAndWide(t *testing.T) {
+	if r := ReverseTransform("RwMuwE"); r != "eUwmrw" {
+		t.Errorf("RwMuwE should reverse to eUwmrw rather than %s", r)
+	}
+	c := NewSolvedCube()
+	c.Transform("FRwMuwSbw")
+	c.Transform(ReverseTransform("FRwMuwSbw"))
+	if !c.IsSolved() {
+		t.Errorf("A transform followed by its reverse should solve the cube")
+	}
+}
+
+// the setup breaks the symmetry of the cube so that different moves give different ids
+const asymmetricSetup = "FUrDlBBu"
+
+// RotateTransform maps moves as if each rotation was undone in the order given
+func undoRotations(rotation string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, rotation)
+}
+
+func TestCube_Rotations_SlicesAndWide(t *testing.T) {
+	for _, rotation := range []string{"X", "x", "Y", "y", "Z", "z", "XY", "Zyx"} {
+		for _, transform := range []string{"M", "m", "E", "e", "S", "s", "Rw", "lw", "Uw", "dw", "Fw", "bw", "MRwEu"} {
+			c := NewSolvedCube()
+			c.Transform(asymmetricSetup + undoRotations(rotation) + transform)
+			d := NewSolvedCube()
+			d.Transform(asymmetricSetup + RotateTransform(rotation, transform))
+			cId, _ := c.EncodeCube()
+			dId, _ := d.EncodeCube()
+			if !cId.Equals(dId) {
+				t.Errorf("%s + %s -> %s isn't equivalent", rotation, transform, RotateTransform(rotation, transform))
+			}
+		}
+	}
+}
+
+func TestRemoveRotationTransforms_SlicesAndWide(t *testing.T) {
+	tests := []string{"XM", "YMZE", "xSyRw", "zuwXFYbw", "MXEYSZ"}
+	for _, test := range tests {
+		c := NewSolvedCube()
+		c.Transform(asymmetricSetup + test)
+		d := NewSolvedCube()
+		d.Transform(asymmetricSetup + RemoveRotationTransforms(test))
+		cId, _ := c.EncodeCube()
+		dId, _ := d.EncodeCube()
+		if !cId.Equals(dId) {
+			t.Errorf("%s reduced to %s isn't equivalent", test, RemoveRotationTransforms(test))
+		}
+		if strings.ContainsAny(RemoveRotationTransforms(test), "XxYyZz") {
+			t.Errorf("%s reduced to %s still contains rotations", test, RemoveRotationTransforms(test))
+		}
+	}
+	if r := RemoveRotationTransforms("YM"); r != "S" {
+		t.Errorf("YM should be reduced to S rather than %s", r)
+	}
+}
+
+func TestDecodeCube(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 500; i++ {
+		c := NewSolvedCube()
+		c.Transform(randomTransform(r, r.Intn(30)))
+		id, rotation := c.EncodeCube()
+		d := DecodeCube(id)
+		decodedId, decodedRotation := d.EncodeCube()
+		if !decodedId.Equals(id) || decodedRotation != "" {
+			t.Fatalf("Decoding %v gave a cube with id %v and rotation %s", id, decodedId, decodedRotation)
+		}
+		if err := d.Validate(); err != nil {
+			t.Fatalf("Decoding %v gave an invalid cube: %s", id, err)
+		}
+		// the decoded cube is the cube seen through the translation the id was taken with
+		translation := idTranslations[slices.IndexFunc(idTranslationTransforms[:], func(s string) bool {
+			return strings.ToLower(s) == rotation
+		})]
+		for j, sticker := range translation {
+			if c.Layout[sticker] != c.Layout[translation[faceCenters[d.Layout[j]]]] {
+				t.Fatalf("Sticker %d of the cube decoded from %v doesn't match the encoded cube", j, id)
+			}
+		}
+	}
+}
+
+func TestDecodeCube_Solved(t *testing.T) {
+	if d := DecodeCube(SolvedCubeId); !d.IsSolved() || d.Layout != NewSolvedCube().Layout {
+		t.Errorf("Decoding the solved id should give the solved cube rather than %v", d.Layout)
+	}
+}
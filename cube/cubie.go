@@ -0,0 +1,230 @@
+package cube
+
+import (
+	"fmt"
+	"github.com/davidminor/uint128"
+	"strings"
+)
+
+// State is a cube that can be turned and looked up in the solution table. It is
+// implemented by both the sticker representation Cube and the cubie representation CubieCube
+type State interface {
+	Transform(t string)
+	EncodeCube() (uint128.Uint128, string)
+	EncodeCubeWithReflections() (uint128.Uint128, string)
+	IsSolved() bool
+	GetNonSymmetricalRotations() []string
+	Copy() State
+}
+
+// CubieCube describes a cube by which corner and edge is in each position and how
+// it's twisted, relative to the centres. CP[i] is the corner in position i and CO[i]
+// how far it's twisted clockwise, EP and EO are the same for edges. Colours is the
+// colour of each centre in the order U, L, F, R, B, D so the layout can be rebuilt exactly
+type CubieCube struct {
+	CP      [8]int
+	CO      [8]int
+	EP      [12]int
+	EO      [12]int
+	Colours [6]int
+}
+
+func NewSolvedCubieCube() *CubieCube {
+	return &CubieCube{
+		CP:      [8]int{0, 1, 2, 3, 4, 5, 6, 7},
+		EP:      [12]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+		Colours: [6]int{0, 1, 2, 3, 4, 5},
+	}
+}
+
+// ToCubieCube converts the layout to cubies, failing if the layout isn't a valid cube
+func (cube *Cube) ToCubieCube() (*CubieCube, error) {
+	c, err := cube.readCubies()
+	if err != nil {
+		return nil, err
+	}
+	cubieCube := &CubieCube{CP: c.cp, CO: c.co, EP: c.ep, EO: c.eo}
+	for face, centre := range faceCenters {
+		cubieCube.Colours[face] = cube.Layout[centre]
+	}
+	return cubieCube, nil
+}
+
+func (cubieCube *CubieCube) ToCube() *Cube {
+	var layout [54]int
+	for face, centre := range faceCenters {
+		layout[centre] = cubieCube.Colours[face]
+	}
+	for position, facelets := range cornerFacelets {
+		faces := cornerFaces[cubieCube.CP[position]]
+		for i, face := range faces {
+			layout[facelets[(i+cubieCube.CO[position])%3]] = cubieCube.Colours[face]
+		}
+	}
+	for position, facelets := range edgeFacelets {
+		faces := edgeFaces[cubieCube.EP[position]]
+		for i, face := range faces {
+			layout[facelets[(i+cubieCube.EO[position])%2]] = cubieCube.Colours[face]
+		}
+	}
+	return NewCube(layout)
+}
+
+// Multiply returns the state reached by applying other after cubieCube
+func (cubieCube *CubieCube) Multiply(other *CubieCube) *CubieCube {
+	result := &CubieCube{Colours: cubieCube.Colours}
+	for i := 0; i < 8; i++ {
+		result.CP[i] = cubieCube.CP[other.CP[i]]
+		result.CO[i] = (cubieCube.CO[other.CP[i]] + other.CO[i]) % 3
+	}
+	for i := 0; i < 12; i++ {
+		result.EP[i] = cubieCube.EP[other.EP[i]]
+		result.EO[i] = (cubieCube.EO[other.EP[i]] + other.EO[i]) % 2
+	}
+	return result
+}
+
+// Inverse returns the state that solves cubieCube when applied after it
+func (cubieCube *CubieCube) Inverse() *CubieCube {
+	result := &CubieCube{Colours: cubieCube.Colours}
+	for i := 0; i < 8; i++ {
+		result.CP[cubieCube.CP[i]] = i
+		result.CO[cubieCube.CP[i]] = (3 - cubieCube.CO[i]) % 3
+	}
+	for i := 0; i < 12; i++ {
+		result.EP[cubieCube.EP[i]] = i
+		result.EO[cubieCube.EP[i]] = cubieCube.EO[i]
+	}
+	return result
+}
+
+// cubieMoves are the face turns as cubie states, built from the sticker maps so both representations always agree
+var cubieMoves = func() map[string]*CubieCube {
+	moves := make(map[string]*CubieCube, 12)
+	for _, move := range []string{"F", "f", "L", "l", "R", "r", "B", "b", "U", "u", "D", "d"} {
+		c := NewSolvedCube()
+		c.transform(move)
+		cubieCube, err := c.ToCubieCube()
+		if err != nil {
+			panic(fmt.Sprintf("face turn %s doesn't give a valid cube: %s", move, err))
+		}
+		moves[move] = cubieCube
+	}
+	return moves
+}()
+
+// Transform applies the moves in the same encoding as Cube.Transform. Face turns are
+// applied to the cubies directly, other moves move the centres so go through the layout
+func (cubieCube *CubieCube) Transform(t string) {
+	for _, move := range SplitTransform(t) {
+		if cubieMove, isFaceTurn := cubieMoves[move]; isFaceTurn {
+			*cubieCube = *cubieCube.Multiply(cubieMove)
+			continue
+		}
+		c := cubieCube.ToCube()
+		c.transform(move)
+		moved, err := c.ToCubieCube()
+		if err != nil {
+			fmt.Printf("Invalid Transform :%v\n", move)
+			continue
+		}
+		*cubieCube = *moved
+	}
+}
+
+// idColours gives each sticker the face its cubie belongs to. Ids name colours after the centres,
+// so these read the same ids as the colours of the layout without building it
+func (cubieCube *CubieCube) idColours() (colours [54]uint8) {
+	for face, centre := range faceCenters {
+		colours[centre] = uint8(face)
+	}
+	for position, facelets := range cornerFacelets {
+		faces := &cornerFaces[cubieCube.CP[position]]
+		for i, face := range faces {
+			colours[facelets[(i+cubieCube.CO[position])%3]] = uint8(face)
+		}
+	}
+	for position, facelets := range edgeFacelets {
+		faces := &edgeFaces[cubieCube.EP[position]]
+		for i, face := range faces {
+			colours[facelets[(i+cubieCube.EO[position])%2]] = uint8(face)
+		}
+	}
+	return colours
+}
+
+// EncodeCube reads the id from the cubies, the same id as EncodeCube of its layout
+func (cubieCube *CubieCube) EncodeCube() (uint128.Uint128, string) {
+	colours := cubieCube.idColours()
+	return encodeColours(&colours)
+}
+
+// EncodeCubeWithReflections gives the id shared with the cube's rotations and mirror images
+func (cubieCube *CubieCube) EncodeCubeWithReflections() (uint128.Uint128, string) {
+	colours := cubieCube.idColours()
+	return encodeColoursWithReflections(&colours)
+}
+
+// IsSolved doesn't depend on the centres, as rotating a solved cube leaves it solved
+func (cubieCube *CubieCube) IsSolved() bool {
+	solved := NewSolvedCubieCube()
+	return cubieCube.CP == solved.CP && cubieCube.CO == solved.CO && cubieCube.EP == solved.EP && cubieCube.EO == solved.EO
+}
+
+func (cubieCube *CubieCube) GetNonSymmetricalRotations() []string {
+	colours := cubieCube.idColours()
+	return nonSymmetricalRotations(&colours)
+}
+
+func (cubieCube *CubieCube) Copy() State {
+	c := *cubieCube
+	return &c
+}
+
+func (cube *Cube) Copy() State {
+	return NewCube(cube.Layout)
+}
+
+// Backend chooses which representation of the cube is used by the generator and solver
+type Backend int
+
+const (
+	StickerBackend Backend = iota
+	CubieBackend
+)
+
+func ParseBackend(s string) (Backend, error) {
+	switch strings.ToLower(s) {
+	case "stickers":
+		return StickerBackend, nil
+	case "cubies":
+		return CubieBackend, nil
+	}
+	return StickerBackend, fmt.Errorf("unknown backend %q, expected stickers or cubies", s)
+}
+
+func (backend Backend) String() string {
+	if backend == CubieBackend {
+		return "cubies"
+	}
+	return "stickers"
+}
+
+func (backend Backend) NewSolved() State {
+	if backend == CubieBackend {
+		return NewSolvedCubieCube()
+	}
+	return NewSolvedCube()
+}
+
+// FromLayout creates a state in this representation. The cubie backend can only represent valid cubes
+func (backend Backend) FromLayout(layout [54]int) (State, error) {
+	if backend == CubieBackend {
+		cubieCube, err := NewCube(layout).ToCubieCube()
+		if err != nil {
+			return nil, err
+		}
+		return cubieCube, nil
+	}
+	return NewCube(layout), nil
+}
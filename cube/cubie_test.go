@@ -0,0 +1,145 @@
+package cube
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCubieCube_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		c := NewSolvedCube()
+		scramble := randomTransform(r, 25)
+		c.Transform(scramble)
+		cubieCube, err := c.ToCubieCube()
+		if err != nil {
+			t.Fatalf("Cube with scramble %s couldn't be converted: %v", scramble, err)
+		}
+		if !reflect.DeepEqual(cubieCube.ToCube().Layout, c.Layout) {
+			t.Errorf("Cube with scramble %s wasn't converted back to the same layout", scramble)
+		}
+	}
+}
+
+func TestCubieCube_Transform(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		scramble := randomTransform(r, 25) + "RwMuw"
+		c := NewSolvedCube()
+		c.Transform(scramble)
+		cubieCube := NewSolvedCubieCube()
+		cubieCube.Transform(scramble)
+		if !reflect.DeepEqual(cubieCube.ToCube().Layout, c.Layout) {
+			t.Errorf("Scramble %s gives different cubes for cubies and stickers", scramble)
+		}
+		cId, cRotation := c.EncodeCube()
+		cubieId, cubieRotation := cubieCube.EncodeCube()
+		if !cId.Equals(cubieId) || cRotation != cubieRotation {
+			t.Errorf("Scramble %s gives different ids for cubies and stickers", scramble)
+		}
+		if c.IsSolved() != cubieCube.IsSolved() {
+			t.Errorf("Scramble %s is solved for one representation but not the other", scramble)
+		}
+	}
+}
+
+func TestCubieCube_Multiply(t *testing.T) {
+	a := NewSolvedCubieCube()
+	a.Transform("FUrDl")
+	b := NewSolvedCubieCube()
+	b.Transform("BBdRf")
+	ab := NewSolvedCubieCube()
+	ab.Transform("FUrDlBBdRf")
+	if !reflect.DeepEqual(a.Multiply(b), ab) {
+		t.Errorf("Multiplying states should be the same as applying their transforms in order")
+	}
+}
+
+// toFaceTurns replaces slices with face turns so the centres stay where they are
+func toFaceTurns(r rune) rune {
+	switch r {
+	case 'M', 'E', 'S':
+		return 'R'
+	case 'm', 'e', 's':
+		return 'r'
+	}
+	return r
+}
+
+func TestCubieCube_Inverse(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 100; i++ {
+		scramble := RemoveRotationTransforms(randomTransform(r, 20))
+		c := NewSolvedCubieCube()
+		c.Transform(scramble)
+		if !c.Multiply(c.Inverse()).IsSolved() || !c.Inverse().Multiply(c).IsSolved() {
+			t.Errorf("A state multiplied by its inverse should be solved, scramble %s", scramble)
+		}
+		c = NewSolvedCubieCube()
+		c.Transform(strings.Map(toFaceTurns, scramble))
+		inverse := NewSolvedCubieCube()
+		inverse.Transform(ReverseTransform(strings.Map(toFaceTurns, scramble)))
+		if !reflect.DeepEqual(c.Inverse(), inverse) {
+			t.Errorf("Inverse of %s should match applying the reversed scramble", scramble)
+		}
+	}
+}
+
+func TestBackend(t *testing.T) {
+	for _, backend := range []Backend{StickerBackend, CubieBackend} {
+		parsed, err := ParseBackend(backend.String())
+		if err != nil || parsed != backend {
+			t.Errorf("%s should parse to itself", backend)
+		}
+		c := backend.NewSolved()
+		c.Transform("FRUbLd")
+		d := c.Copy()
+		d.Transform("DlBurf")
+		if c.IsSolved() || !d.IsSolved() {
+			t.Errorf("Copies using %s should be independent", backend)
+		}
+	}
+	invalid := NewSolvedCube()
+	invalid.Layout[8], invalid.Layout[15] = invalid.Layout[15], invalid.Layout[8]
+	if _, err := CubieBackend.FromLayout(invalid.Layout); err == nil {
+		t.Errorf("The cubie backend can't represent a twisted corner")
+	}
+}
+
+func TestCubieCube_EncodeCube(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 500; i++ {
+		scramble := randomTransform(r, 20)
+		c := NewSolvedCubieCube()
+		c.Transform(scramble)
+		layout := c.ToCube()
+		id, rotation := c.EncodeCube()
+		layoutId, layoutRotation := layout.EncodeCube()
+		if !id.Equals(layoutId) || rotation != layoutRotation {
+			t.Errorf("%s should be encoded the same from cubies and stickers", scramble)
+		}
+		id, rotation = c.EncodeCubeWithReflections()
+		layoutId, layoutRotation = layout.EncodeCubeWithReflections()
+		if !id.Equals(layoutId) || rotation != layoutRotation {
+			t.Errorf("%s should be encoded with reflections the same from cubies and stickers", scramble)
+		}
+		if !reflect.DeepEqual(c.GetNonSymmetricalRotations(), layout.GetNonSymmetricalRotations()) {
+			t.Errorf("%s should have the same rotations from cubies and stickers", scramble)
+		}
+	}
+}
+
+func BenchmarkCubieCube_EncodeCube(b *testing.B) {
+	r := rand.New(rand.NewSource(8))
+	cubes := make([]*CubieCube, 1024)
+	for i := range cubes {
+		cubes[i] = NewSolvedCubieCube()
+		cubes[i].Transform(randomTransform(r, 20))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cubes[i%len(cubes)].EncodeCube()
+	}
+}
@@ -0,0 +1,51 @@
+package cube
+
+import (
+	"fmt"
+	"github.com/davidminor/uint128"
+	"strings"
+)
+
+// Encoding decides which cubes share an id in the solutions table
+type Encoding int
+
+const (
+	// RotationEncoding gives a cube the same id as its 24 rotations
+	RotationEncoding Encoding = iota
+	// ReflectionEncoding also gives a cube the same id as its mirror images, so the table
+	// holds about half as many ids
+	ReflectionEncoding
+)
+
+func ParseEncoding(s string) (Encoding, error) {
+	switch strings.ToLower(s) {
+	case "rotations":
+		return RotationEncoding, nil
+	case "reflections":
+		return ReflectionEncoding, nil
+	}
+	return RotationEncoding, fmt.Errorf("unknown encoding %q, expected rotations or reflections", s)
+}
+
+func (encoding Encoding) String() string {
+	if encoding == ReflectionEncoding {
+		return "reflections"
+	}
+	return "rotations"
+}
+
+// Encode gives the id of the state and the rotation to pass to RotateTransform with its solution
+func (encoding Encoding) Encode(state State) (uint128.Uint128, string) {
+	if encoding == ReflectionEncoding {
+		return state.EncodeCubeWithReflections()
+	}
+	return state.EncodeCube()
+}
+
+// Symmetries is the most positions that share an id
+func (encoding Encoding) Symmetries() int {
+	if encoding == ReflectionEncoding {
+		return 48
+	}
+	return 24
+}
@@ -80,6 +80,12 @@ func (generator *Generator) GetCurrentTransformNum() int {
 	return generator.transformNum - 1
 }
 
+// GetNextTransformNum is the number of the transform Next will return, which is what a
+// generator carrying on from the current stack should be created with
+func (generator *Generator) GetNextTransformNum() int {
+	return generator.transformNum
+}
+
 func CreateNewGenerator(stack []int, transformNo int, file string) Generator {
 	g := new(Generator)
 	g.TransformStack = stack
@@ -103,6 +109,26 @@ var fileContent embed.FS
 const ID_TRANSFORM_GRAPH = "generator_graphs/id_transform_graph.csv"
 const TRANSFORM_GRAPH = "generator_graphs/transform_graph.csv"
 
+// the half turn metric graphs have an edge for each half turn, e.g. "FF", so that it is a single step
+const HTM_ID_TRANSFORM_GRAPH = "generator_graphs/htm_id_transform_graph.csv"
+const HTM_TRANSFORM_GRAPH = "generator_graphs/htm_transform_graph.csv"
+
+// LoadGraph reads one of the generator graphs, returning its start node
+func LoadGraph(file string) *Node {
+	startNode := createGraphFromFile(file)
+	return &startNode
+}
+
+// Moves are the transforms allowed from this node, in the order the generator uses them
+func (node *Node) Moves() []string {
+	return node.outboundEdges
+}
+
+// Next is the node reached by applying one of the moves allowed from this node
+func (node *Node) Next(move string) *Node {
+	return node.edges[move]
+}
+
 func createGraphFromFile(file string) Node {
 	f, err := fileContent.Open(file)
 	if err != nil {
@@ -36,7 +36,7 @@ for i in range(len(lines)):
             edge_styles.append("--")
         else:
             edge_styles.append("-")
-        edge_colours.append(transform_colour_map[transform.upper()])
+        edge_colours.append(transform_colour_map[transform[0].upper()])
 
 nx.draw_networkx_nodes(G, pos, node_color=node_colours)
 nx.draw_networkx_edges(G, pos, edge_color=edge_colours, style=edge_styles)
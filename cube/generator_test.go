@@ -1,7 +1,9 @@
 package cube
 
 import (
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -55,3 +57,77 @@ func TestGenerator_Valid(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerator_HTM_Counts(t *testing.T) {
+	// the number of cube states at each distance in the half turn metric, the graph
+	// should generate exactly one transform for each of them up to depth 3
+	expectedCounts := []int{1, 18, 243, 3240}
+	g := CreateNewGenerator([]int{0}, 0, HTM_TRANSFORM_GRAPH)
+	counts := make([]int, len(expectedCounts))
+	counts[0] = 1
+	states := map[[54]int]struct{}{NewSolvedCube().Layout: {}}
+	for g.GetCurrentDepth() < len(expectedCounts) {
+		depth := g.GetCurrentDepth()
+		s := g.Next()
+		counts[depth] += 1
+		if HalfTurnMetric.Length(s) != depth {
+			t.Errorf("%s should have length %d in the half turn metric", s, depth)
+		}
+		c := NewSolvedCube()
+		c.Transform(s)
+		states[c.Layout] = struct{}{}
+	}
+	if !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("Generated %v transforms at each depth rather than %v", counts, expectedCounts)
+	}
+	if len(states) != 1+18+243+3240 {
+		t.Errorf("Generated transforms should all give different cubes, only %d were unique", len(states))
+	}
+}
+
+func TestGenerator_HTM_Valid(t *testing.T) {
+	bannedPairs := []string{"FF", "LL", "UU", "BB", "RR", "DD", "BF", "RL", "DU"}
+	g := CreateNewGenerator([]int{0}, 0, HTM_ID_TRANSFORM_GRAPH)
+	for i := 0; i < 10000; i++ {
+		depth := g.GetCurrentDepth()
+		s := g.Next()
+		if HalfTurnMetric.Length(s) != depth {
+			t.Errorf("Produced %s which turns the same face twice in a row", s)
+		}
+		if !strings.HasPrefix(strings.ToUpper(s), "F") {
+			t.Errorf("Transforms from the id graph should start with an F turn, not %s", s)
+		}
+		faces := strings.Builder{}
+		for j, r := range strings.ToUpper(s) {
+			if j == 0 || strings.ToUpper(s)[j-1] != byte(r) {
+				faces.WriteRune(r)
+			}
+		}
+		for _, pair := range bannedPairs {
+			if strings.Contains(faces.String(), pair) {
+				t.Errorf("Produced banned transform combination %s", s)
+			}
+		}
+	}
+}
+
+func TestMetric_Length(t *testing.T) {
+	tests := []struct {
+		transform string
+		qtm, htm  int
+	}{
+		{"", 0, 0},
+		{"FF", 2, 1},
+		{"FFbRRu", 6, 4},
+		{"FFxRR", 4, 2},
+		{"MMRwRw", 4, 2},
+	}
+	for _, test := range tests {
+		if l := QuarterTurnMetric.Length(test.transform); l != test.qtm {
+			t.Errorf("%s has quarter turn length %d rather than %d", test.transform, l, test.qtm)
+		}
+		if l := HalfTurnMetric.Length(test.transform); l != test.htm {
+			t.Errorf("%s has half turn length %d rather than %d", test.transform, l, test.htm)
+		}
+	}
+}
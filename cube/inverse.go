@@ -0,0 +1,72 @@
+package cube
+
+import "errors"
+
+// The inverse of a cube is the cube its moves reach when they're undone from solved, so if a
+// transform turns a solved cube into this one then it also solves the inverse. A cube and its
+// inverse are the same distance from solved, and a solution of either gives a solution of the other
+
+var ErrNotOriented = errors.New("the centres aren't a rotation of the solved cube's")
+
+// homeRotations are the rotations that turn the cube so each centre is on its own face, by the
+// colours of the U and F centres. Entries for centres that can't be next to each other are empty
+var homeRotations = func() (rotations [6][6]*string) {
+	for k := range idTranslationTransforms {
+		c := NewSolvedCube()
+		c.Transform(ReverseTransform(idTranslationTransforms[k]))
+		rotations[c.Layout[faceCenters[0]]][c.Layout[faceCenters[2]]] = &idTranslationTransforms[k]
+	}
+	return rotations
+}()
+
+// homeRotation gives the rotation that turns the cube so each centre is on its own face
+func (cube *Cube) homeRotation() (string, bool) {
+	u, f := cube.Layout[faceCenters[0]], cube.Layout[faceCenters[2]]
+	if u < 0 || u > 5 || f < 0 || f > 5 || homeRotations[u][f] == nil {
+		return "", false
+	}
+	rotation := *homeRotations[u][f]
+	c := NewCube(cube.Layout)
+	c.Transform(rotation)
+	for face, centre := range faceCenters {
+		if c.Layout[centre] != face {
+			return "", false
+		}
+	}
+	return rotation, true
+}
+
+// Inverse gives the inverse of the cube with its centres on their own faces. It fails if the
+// layout isn't a valid cube
+func (cube *Cube) Inverse() (*Cube, error) {
+	rotation, found := cube.homeRotation()
+	if !found {
+		return nil, ErrNotOriented
+	}
+	oriented := NewCube(cube.Layout)
+	oriented.Transform(rotation)
+	cubieCube, err := oriented.ToCubieCube()
+	if err != nil {
+		return nil, err
+	}
+	return cubieCube.Inverse().ToCube(), nil
+}
+
+// InverseSolution turns a solution of the cube's inverse into a solution of the cube. Solutions
+// may leave the cube rotated, so the rotations between the two are found from the centres
+func (cube *Cube) InverseSolution(solution string) (string, error) {
+	rotation, found := cube.homeRotation()
+	if !found {
+		return "", ErrNotOriented
+	}
+	inverse, err := cube.Inverse()
+	if err != nil {
+		return "", err
+	}
+	inverse.Transform(solution)
+	solvedRotation, found := inverse.homeRotation()
+	if !found || !inverse.IsSolved() {
+		return "", errors.New("the solution doesn't solve the inverse")
+	}
+	return rotation + ReverseTransform(solution+solvedRotation), nil
+}
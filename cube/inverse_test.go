@@ -0,0 +1,76 @@
+package cube
+
+import (
+	"errors"
+	"github.com/davidminor/uint128"
+	"math/rand"
+	"testing"
+)
+
+func TestCube_Inverse(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 500; i++ {
+		scramble := randomTransform(r, 20)
+		c := NewSolvedCube()
+		c.Transform(scramble)
+		inverse, err := c.Inverse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reversed := NewSolvedCube()
+		reversed.Transform(ReverseTransform(scramble))
+		if id, _ := reversed.EncodeCube(); !id.Equals(idOf(inverse)) {
+			t.Errorf("The inverse of %s should be the reversed scramble up to rotation", scramble)
+		}
+		inverse.Transform(scramble)
+		if !inverse.IsSolved() {
+			t.Errorf("%s should solve its inverse", scramble)
+		}
+		twice, err := c.Inverse()
+		if err == nil {
+			twice, err = twice.Inverse()
+		}
+		if err != nil || !idOf(twice).Equals(idOf(c)) {
+			t.Errorf("The inverse of the inverse of %s should be the cube", scramble)
+		}
+	}
+	invalid := NewSolvedCube()
+	invalid.Layout[8], invalid.Layout[15] = invalid.Layout[15], invalid.Layout[8]
+	if _, err := invalid.Inverse(); err == nil {
+		t.Errorf("A twisted corner can't be inverted")
+	}
+	uncentred := NewSolvedCube()
+	uncentred.Layout[4] = 5
+	if _, err := uncentred.Inverse(); !errors.Is(err, ErrNotOriented) {
+		t.Errorf("Expected ErrNotOriented for two D centres but got %v", err)
+	}
+}
+
+func idOf(c *Cube) uint128.Uint128 {
+	id, _ := c.EncodeCube()
+	return id
+}
+
+func TestCube_InverseSolution(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 500; i++ {
+		scramble := randomTransform(r, 12)
+		c := NewSolvedCube()
+		c.Transform(scramble)
+		// any solution of the inverse will do, including ones that leave it rotated
+		rotation := idTranslationTransforms[r.Intn(len(idTranslationTransforms))]
+		solution, err := c.InverseSolution(scramble + rotation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("%s should solve %s", solution, scramble)
+		}
+	}
+	c := NewSolvedCube()
+	c.Transform("FRU")
+	if _, err := c.InverseSolution("fru"); err == nil {
+		t.Errorf("fru doesn't solve the inverse of FRU")
+	}
+}
@@ -0,0 +1,64 @@
+package cube
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Metric decides how moves are counted when measuring the length of a solution
+type Metric int
+
+const (
+	// QuarterTurnMetric counts a half turn as two moves
+	QuarterTurnMetric Metric = iota
+	// HalfTurnMetric counts any turn of a single face as one move
+	HalfTurnMetric
+)
+
+func ParseMetric(s string) (Metric, error) {
+	switch strings.ToLower(s) {
+	case "qtm":
+		return QuarterTurnMetric, nil
+	case "htm":
+		return HalfTurnMetric, nil
+	}
+	return QuarterTurnMetric, fmt.Errorf("unknown metric %q, expected qtm or htm", s)
+}
+
+func (metric Metric) String() string {
+	if metric == HalfTurnMetric {
+		return "htm"
+	}
+	return "qtm"
+}
+
+// IdTransformGraph is the generator graph used when building the lookup table from a solved cube
+func (metric Metric) IdTransformGraph() string {
+	if metric == HalfTurnMetric {
+		return HTM_ID_TRANSFORM_GRAPH
+	}
+	return ID_TRANSFORM_GRAPH
+}
+
+// TransformGraph is the generator graph used when searching from an arbitrary cube
+func (metric Metric) TransformGraph() string {
+	if metric == HalfTurnMetric {
+		return HTM_TRANSFORM_GRAPH
+	}
+	return TRANSFORM_GRAPH
+}
+
+// Length counts the moves in a transform. Rotations are free and in the half turn
+// metric repeated turns of the same face are a single move
+func (metric Metric) Length(transform string) int {
+	length := 0
+	lastMove := ""
+	for _, move := range SplitTransform(RemoveRotationTransforms(transform)) {
+		move = strings.ToUpper(move)
+		if metric == QuarterTurnMetric || move != lastMove {
+			length += 1
+		}
+		lastMove = move
+	}
+	return length
+}
@@ -0,0 +1,75 @@
+package cube
+
+import "unicode/utf8"
+
+// stickerPermutation is a move as the position each sticker takes its colour from, so a move is
+// applied in one pass over the layout without a map lookup per sticker
+type stickerPermutation [54]uint8
+
+func newStickerPermutation(transformMap map[int]int) *stickerPermutation {
+	p := new(stickerPermutation)
+	for i := range p {
+		p[i] = uint8(i)
+	}
+	for from, to := range transformMap {
+		p[to] = uint8(from)
+	}
+	return p
+}
+
+// moveTransformMaps are the sticker maps of every move Transform accepts
+var moveTransformMaps = map[string]map[int]int{
+	"F": fRotationMap, "f": fiRotationMap,
+	"L": lRotationMap, "l": liRotationMap,
+	"R": rRotationMap, "r": riRotationMap,
+	"B": bRotationMap, "b": biRotationMap,
+	"U": uRotationMap, "u": uiRotationMap,
+	"D": dRotationMap, "d": diRotationMap,
+	"X": xRotationMap, "x": xiRotationMap,
+	"Y": yRotationMap, "y": yiRotationMap,
+	"Z": zRotationMap, "z": ziRotationMap,
+	"M": mRotationMap, "m": miRotationMap,
+	"E": eRotationMap, "e": eiRotationMap,
+	"S": sRotationMap, "s": siRotationMap,
+	"Fw": fwRotationMap, "fw": fwiRotationMap,
+	"Lw": lwRotationMap, "lw": lwiRotationMap,
+	"Rw": rwRotationMap, "rw": rwiRotationMap,
+	"Bw": bwRotationMap, "bw": bwiRotationMap,
+	"Uw": uwRotationMap, "uw": uwiRotationMap,
+	"Dw": dwRotationMap, "dw": dwiRotationMap,
+}
+
+// movePermutations holds the single rune moves by their rune and wideMovePermutations holds
+// the wide moves by their face's rune, nil entries aren't moves
+var movePermutations, wideMovePermutations = func() (moves, wide [utf8.RuneSelf]*stickerPermutation) {
+	for move, transformMap := range moveTransformMaps {
+		if len(move) == 2 {
+			wide[move[0]] = newStickerPermutation(transformMap)
+		} else {
+			moves[move[0]] = newStickerPermutation(transformMap)
+		}
+	}
+	return moves, wide
+}()
+
+// permutationOf is the permutation of a single move, or nil if it isn't a move
+func permutationOf(move string) *stickerPermutation {
+	if len(move) == 0 || move[0] >= utf8.RuneSelf {
+		return nil
+	}
+	if len(move) == 1 {
+		return movePermutations[move[0]]
+	}
+	if len(move) == 2 && move[1] == 'w' {
+		return wideMovePermutations[move[0]]
+	}
+	return nil
+}
+
+// maintains an older previous state to save on creating a new array each transform
+func (cube *Cube) applyPermutation(p *stickerPermutation) {
+	for i, from := range p {
+		cube.previousLayout[i] = cube.Layout[from]
+	}
+	cube.Layout = cube.previousLayout
+}
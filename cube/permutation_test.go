@@ -0,0 +1,76 @@
+package cube
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// applyTransformMap moves each sticker with a map lookup, the way moves were applied before permutations
+func applyTransformMap(layout [54]int, transformMap map[int]int) [54]int {
+	var next [54]int
+	for i := 0; i < 54; i++ {
+		if newIndex, exists := transformMap[i]; exists {
+			next[newIndex] = layout[i]
+		} else {
+			next[i] = layout[i]
+		}
+	}
+	return next
+}
+
+func TestCube_Transform_MatchesTransformMaps(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	for move, transformMap := range moveTransformMaps {
+		for i := 0; i < 20; i++ {
+			c := NewSolvedCube()
+			c.Transform(randomTransform(r, 15))
+			expected := applyTransformMap(c.Layout, transformMap)
+			c.Transform(move)
+			if c.Layout != expected {
+				t.Fatalf("%s moved the stickers differently to its map", move)
+			}
+		}
+	}
+}
+
+func TestPermutationOf(t *testing.T) {
+	for _, move := range []string{"", "w", "Fww", "Xw", "FR", "é", "éw", "Q"} {
+		if permutationOf(move) != nil {
+			t.Errorf("%q shouldn't be a move", move)
+		}
+	}
+	for move := range moveTransformMaps {
+		if permutationOf(move) == nil {
+			t.Errorf("%q should be a move", move)
+		}
+	}
+}
+
+func randomTransforms(r *rand.Rand) []string {
+	transforms := make([]string, 1024)
+	for i := range transforms {
+		transforms[i] = randomTransform(r, 20)
+	}
+	return transforms
+}
+
+func BenchmarkCube_Transform(b *testing.B) {
+	transforms := randomTransforms(rand.New(rand.NewSource(5)))
+	c := NewSolvedCube()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Transform(transforms[i%len(transforms)])
+	}
+}
+
+// BenchmarkCube_TransformMaps applies the same moves as BenchmarkCube_Transform with the old map lookups
+func BenchmarkCube_TransformMaps(b *testing.B) {
+	transforms := randomTransforms(rand.New(rand.NewSource(5)))
+	layout := NewSolvedCube().Layout
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, move := range SplitTransform(transforms[i%len(transforms)]) {
+			layout = applyTransformMap(layout, moveTransformMaps[move])
+		}
+	}
+}
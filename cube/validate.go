@@ -0,0 +1,232 @@
+package cube
+
+import "fmt"
+
+// faces are numbered by the colour of their centre on a solved cube
+const (
+	faceU = iota
+	faceL
+	faceF
+	faceR
+	faceB
+	faceD
+)
+
+// cornerFacelets lists the stickers of each corner position, clockwise starting from the U or D sticker.
+// The positions are URF, UFL, ULB, UBR, DFR, DLF, DBL, DRB
+var cornerFacelets = [8][3]int{
+	{8, 15, 14},
+	{6, 12, 11},
+	{0, 9, 20},
+	{2, 18, 17},
+	{47, 38, 39},
+	{45, 35, 36},
+	{51, 44, 33},
+	{53, 41, 42},
+}
+
+// cornerFaces are the faces of each corner cubie, in the same order as cornerFacelets
+var cornerFaces = [8][3]int{
+	{faceU, faceR, faceF},
+	{faceU, faceF, faceL},
+	{faceU, faceL, faceB},
+	{faceU, faceB, faceR},
+	{faceD, faceF, faceR},
+	{faceD, faceL, faceF},
+	{faceD, faceB, faceL},
+	{faceD, faceR, faceB},
+}
+
+// edgeFacelets lists the stickers of each edge position, the U/D or F/B sticker first.
+// The positions are UR, UF, UL, UB, DR, DF, DL, DB, FR, FL, BL, BR
+var edgeFacelets = [12][2]int{
+	{5, 16},
+	{7, 13},
+	{3, 10},
+	{1, 19},
+	{50, 40},
+	{46, 37},
+	{48, 34},
+	{52, 43},
+	{26, 27},
+	{24, 23},
+	{32, 21},
+	{30, 29},
+}
+
+var edgeFaces = [12][2]int{
+	{faceU, faceR},
+	{faceU, faceF},
+	{faceU, faceL},
+	{faceU, faceB},
+	{faceD, faceR},
+	{faceD, faceF},
+	{faceD, faceL},
+	{faceD, faceB},
+	{faceF, faceR},
+	{faceF, faceL},
+	{faceB, faceL},
+	{faceB, faceR},
+}
+
+type ValidationReason string
+
+const (
+	InvalidColour     ValidationReason = "invalid_colour"
+	WrongColourCount  ValidationReason = "wrong_colour_count"
+	DuplicateCentre   ValidationReason = "duplicate_centre"
+	InvalidCorner     ValidationReason = "invalid_corner"
+	DuplicateCorner   ValidationReason = "duplicate_corner"
+	InvalidEdge       ValidationReason = "invalid_edge"
+	DuplicateEdge     ValidationReason = "duplicate_edge"
+	TwistedCorner     ValidationReason = "twisted_corner"
+	FlippedEdge       ValidationReason = "flipped_edge"
+	PermutationParity ValidationReason = "permutation_parity"
+)
+
+// ValidationError explains why a layout can't be reached from a solved cube.
+// Reason is stable and suitable for clients to check, Detail is for people
+type ValidationError struct {
+	Reason ValidationReason
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+}
+
+// Validate checks that the layout can be reached from a solved cube using face turns
+// and rotations. Faces are identified by the colour of their centre so any colour scheme is accepted
+func (cube *Cube) Validate() error {
+	_, err := cube.readCubies()
+	return err
+}
+
+// cubies holds the position and orientation of each corner and edge, relative to the centres
+type cubies struct {
+	cp [8]int
+	co [8]int
+	ep [12]int
+	eo [12]int
+}
+
+func (cube *Cube) readCubies() (*cubies, error) {
+	var colourCounts [6]int
+	for i, colour := range cube.Layout {
+		if colour < 0 || colour >= 6 {
+			return nil, &ValidationError{InvalidColour, fmt.Sprintf("sticker %d has colour %d, colours should be 0 to 5", i, colour)}
+		}
+		colourCounts[colour] += 1
+	}
+	for colour, count := range colourCounts {
+		if count != 9 {
+			return nil, &ValidationError{WrongColourCount, fmt.Sprintf("colour %d appears %d times rather than 9", colour, count)}
+		}
+	}
+
+	var faceOfColour [6]int
+	var seenCentre [6]bool
+	for face, centre := range faceCenters {
+		colour := cube.Layout[centre]
+		if seenCentre[colour] {
+			return nil, &ValidationError{DuplicateCentre, fmt.Sprintf("colour %d is the centre of more than one face", colour)}
+		}
+		seenCentre[colour] = true
+		faceOfColour[colour] = face
+	}
+
+	c := new(cubies)
+	var seenCorner [8]bool
+	for position, facelets := range cornerFacelets {
+		var faces [3]int
+		for i, facelet := range facelets {
+			faces[i] = faceOfColour[cube.Layout[facelet]]
+		}
+		cubie, orientation := findCorner(faces)
+		if cubie == -1 {
+			return nil, &ValidationError{InvalidCorner, fmt.Sprintf("stickers %v don't make a corner", facelets)}
+		}
+		if seenCorner[cubie] {
+			return nil, &ValidationError{DuplicateCorner, fmt.Sprintf("the corner at stickers %v appears more than once", facelets)}
+		}
+		seenCorner[cubie] = true
+		c.cp[position] = cubie
+		c.co[position] = orientation
+	}
+
+	var seenEdge [12]bool
+	for position, facelets := range edgeFacelets {
+		faces := [2]int{faceOfColour[cube.Layout[facelets[0]]], faceOfColour[cube.Layout[facelets[1]]]}
+		cubie, orientation := findEdge(faces)
+		if cubie == -1 {
+			return nil, &ValidationError{InvalidEdge, fmt.Sprintf("stickers %v don't make an edge", facelets)}
+		}
+		if seenEdge[cubie] {
+			return nil, &ValidationError{DuplicateEdge, fmt.Sprintf("the edge at stickers %v appears more than once", facelets)}
+		}
+		seenEdge[cubie] = true
+		c.ep[position] = cubie
+		c.eo[position] = orientation
+	}
+
+	twist := 0
+	for _, orientation := range c.co {
+		twist += orientation
+	}
+	if twist%3 != 0 {
+		return nil, &ValidationError{TwistedCorner, "the corner twists don't add up, a single corner has been twisted"}
+	}
+	flip := 0
+	for _, orientation := range c.eo {
+		flip += orientation
+	}
+	if flip%2 != 0 {
+		return nil, &ValidationError{FlippedEdge, "a single edge has been flipped"}
+	}
+	if permutationParity(c.cp[:]) != permutationParity(c.ep[:]) {
+		return nil, &ValidationError{PermutationParity, "two pieces have been swapped"}
+	}
+	return c, nil
+}
+
+// findCorner returns which corner cubie has the faces given and how far it's twisted
+// clockwise, or -1 if no corner matches
+func findCorner(faces [3]int) (int, int) {
+	for orientation := 0; orientation < 3; orientation++ {
+		if faces[orientation] != faceU && faces[orientation] != faceD {
+			continue
+		}
+		for cubie, cubieFaces := range cornerFaces {
+			if faces[orientation] == cubieFaces[0] && faces[(orientation+1)%3] == cubieFaces[1] && faces[(orientation+2)%3] == cubieFaces[2] {
+				return cubie, orientation
+			}
+		}
+	}
+	return -1, 0
+}
+
+// findEdge returns which edge cubie has the faces given and whether it's flipped, or -1 if no edge matches
+func findEdge(faces [2]int) (int, int) {
+	for cubie, cubieFaces := range edgeFaces {
+		if faces[0] == cubieFaces[0] && faces[1] == cubieFaces[1] {
+			return cubie, 0
+		}
+		if faces[1] == cubieFaces[0] && faces[0] == cubieFaces[1] {
+			return cubie, 1
+		}
+	}
+	return -1, 0
+}
+
+// permutationParity is 0 for an even permutation and 1 for an odd one
+func permutationParity(permutation []int) int {
+	parity := 0
+	for i := 0; i < len(permutation); i++ {
+		for j := i + 1; j < len(permutation); j++ {
+			if permutation[i] > permutation[j] {
+				parity ^= 1
+			}
+		}
+	}
+	return parity
+}
@@ -0,0 +1,105 @@
+package cube
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomTransform(r *rand.Rand, length int) string {
+	moves := []rune("FfLlRrBbUuDdXxYyZzMmEeSs")
+	transform := make([]rune, length)
+	for i := range transform {
+		transform[i] = moves[r.Intn(len(moves))]
+	}
+	return string(transform)
+}
+
+func TestCube_Validate_Scrambles(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		c := NewSolvedCube()
+		scramble := randomTransform(r, 30)
+		c.Transform(scramble)
+		if err := c.Validate(); err != nil {
+			t.Errorf("Cube with scramble %s should be valid but got %v", scramble, err)
+		}
+	}
+}
+
+func expectValidationReason(t *testing.T, c *Cube, reason ValidationReason) {
+	var validationError *ValidationError
+	err := c.Validate()
+	if !errors.As(err, &validationError) {
+		t.Errorf("Expected %s but got %v", reason, err)
+		return
+	}
+	if validationError.Reason != reason {
+		t.Errorf("Expected %s but got %v", reason, err)
+	}
+}
+
+func swapStickers(c *Cube, a, b int) {
+	c.Layout[a], c.Layout[b] = c.Layout[b], c.Layout[a]
+}
+
+func TestCube_Validate_Errors(t *testing.T) {
+	c := NewSolvedCube()
+	c.Layout[0] = 6
+	expectValidationReason(t, c, InvalidColour)
+
+	c = NewSolvedCube()
+	c.Layout[0] = 1
+	expectValidationReason(t, c, WrongColourCount)
+
+	c = NewSolvedCube()
+	swapStickers(c, 4, 13) // U centre swapped with a F edge sticker
+	expectValidationReason(t, c, DuplicateCentre)
+
+	c = NewSolvedCube()
+	swapStickers(c, 8, 6) // URF and UFL both have two U stickers
+	swapStickers(c, 15, 11)
+	c.Transform("RUF")
+	expectValidationReason(t, c, InvalidCorner)
+
+	c = NewSolvedCube()
+	c.Transform("FUr")
+	cornerColours := c.Layout
+	c.Layout[cornerFacelets[0][1]], c.Layout[cornerFacelets[0][2]] = cornerColours[cornerFacelets[0][2]], cornerColours[cornerFacelets[0][1]]
+	expectValidationReason(t, c, InvalidCorner) // a mirror image corner
+
+	c = NewSolvedCube()
+	swapStickers(c, 13, 40) // UF becomes a second UR edge and DR becomes DF
+	expectValidationReason(t, c, DuplicateEdge)
+
+	c = NewSolvedCube()
+	swapStickers(c, 13, 46) // UF has U and D stickers
+	expectValidationReason(t, c, InvalidEdge)
+
+	c = NewSolvedCube()
+	c.Transform("FRbDL")
+	c.Layout[8], c.Layout[15], c.Layout[14] = c.Layout[14], c.Layout[8], c.Layout[15]
+	expectValidationReason(t, c, TwistedCorner)
+
+	c = NewSolvedCube()
+	c.Transform("uLdBr")
+	swapStickers(c, 5, 16)
+	expectValidationReason(t, c, FlippedEdge)
+
+	c = NewSolvedCube()
+	c.Transform("URfDb")
+	swapStickers(c, 5, 7) // swap UR and UF
+	swapStickers(c, 16, 13)
+	expectValidationReason(t, c, PermutationParity)
+}
+
+func TestCube_Validate_ColourScheme(t *testing.T) {
+	c := NewSolvedCube()
+	c.Transform("FRUXblDz")
+	for i := range c.Layout {
+		c.Layout[i] = (c.Layout[i] + 2) % 6
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Any colour scheme should be valid but got %v", err)
+	}
+}
@@ -145,10 +145,10 @@ const App = () => {
     });
   }, [cubeLayout, setCubeLayout]);
 
-  const solveCubeMinimal = () => {
+  const solveCube = (strategy) => {
     fetch(window.location.href + "cubeMinimalSol", {
       method: "POST",
-      body: JSON.stringify({CubeLayout: cubeLayout})
+      body: JSON.stringify({CubeLayout: cubeLayout, Strategy: strategy})
     })
     .then(response => {
       if (!response.ok) throw new Error("Error fetching cube solution");
@@ -205,9 +205,12 @@ const App = () => {
       <button onClick={() => setPlayTransforms(prev => !prev)} >
         {playTransforms ? "Pause": "Play"}
       </button>
-      <button onClick={solveCubeMinimal}>
+      <button onClick={() => solveCube("minimal")}>
         Get Minimal Solution (May be very slow)
       </button>
+      <button onClick={() => solveCube("twophase")}>
+        Get Fast Solution
+      </button>
       <div id="sceneContainer" />
     </div>
   );
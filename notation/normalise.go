@@ -0,0 +1,110 @@
+package notation
+
+import "sort"
+
+// axes groups the faces that turn about the same axis, moves about the same axis commute
+var axes = map[rune]int{
+	'U': 0, 'D': 0, 'E': 0, 'y': 0,
+	'L': 1, 'R': 1, 'M': 1, 'x': 1,
+	'F': 2, 'B': 2, 'S': 2, 'z': 2,
+}
+
+// faceOrder is the order moves that commute are written in by Normalise
+var faceOrder = map[rune]int{
+	'U': 0, 'D': 1, 'E': 2, 'y': 3,
+	'L': 0, 'R': 1, 'M': 2, 'x': 3,
+	'F': 0, 'B': 1, 'S': 2, 'z': 3,
+}
+
+// Normalise writes moves so that sequences that only differ in the order of moves that commute,
+// like "U D" and "D U", are the same. Runs of moves about one axis are put in a fixed order and
+// turns of the same face are combined
+func Normalise(moves []Move) []Move {
+	for {
+		normalised, changed := normaliseRuns(moves)
+		if !changed {
+			return normalised
+		}
+		// combining turns can empty a run and leave two runs about the same axis next to each other
+		moves = normalised
+	}
+}
+
+// normaliseRuns puts each run of moves about one axis in order, combining turns of the same face.
+// It reports whether any run lost moves
+func normaliseRuns(moves []Move) ([]Move, bool) {
+	var result []Move
+	changed := false
+	for start := 0; start < len(moves); {
+		end := start + 1
+		for end < len(moves) && axes[moves[end].Face] == axes[moves[start].Face] {
+			end++
+		}
+		run := make([]Move, 0, end-start)
+		for _, move := range moves[start:end] {
+			combined := false
+			for i := range run {
+				if run[i].Face == move.Face && run[i].Wide == move.Wide {
+					run[i].Turns += move.Turns
+					combined = true
+				}
+			}
+			if !combined {
+				run = append(run, move)
+			}
+		}
+		sort.SliceStable(run, func(i, j int) bool {
+			if faceOrder[run[i].Face] != faceOrder[run[j].Face] {
+				return faceOrder[run[i].Face] < faceOrder[run[j].Face]
+			}
+			return !run[i].Wide && run[j].Wide
+		})
+		for _, move := range run {
+			switch normaliseTurns(move.Turns) {
+			case 0:
+				changed = true
+			case 1:
+				result = append(result, Move{Face: move.Face, Turns: 1, Wide: move.Wide})
+			case 2:
+				result = append(result, Move{Face: move.Face, Turns: 2, Wide: move.Wide})
+			case 3:
+				result = append(result, Move{Face: move.Face, Turns: -1, Wide: move.Wide})
+			}
+		}
+		start = end
+	}
+	return result, changed
+}
+
+// faceCosts rate how awkward a turn of each face is holding the cube with F towards you. R and U
+// are turned by the right hand without changing grip and L and F nearly as easily, D needs the
+// ring finger and B a regrip. Slices are turned with a finger between the faces
+var faceCosts = map[rune]int{
+	'R': 1, 'U': 1,
+	'L': 2, 'F': 2,
+	'D': 3, 'M': 3,
+	'B': 4, 'E': 4, 'S': 4,
+}
+
+// rotationCost is what a whole cube rotation costs, it needs a regrip
+const rotationCost = 3
+
+// ErgonomicCost rates how awkward moves are to perform, lower is easier. Each move costs its face's
+// cost, with one more for a half turn or a wide move
+func ErgonomicCost(moves []Move) int {
+	cost := 0
+	for _, move := range moves {
+		if move.IsRotation() {
+			cost += rotationCost
+			continue
+		}
+		cost += faceCosts[move.Face]
+		if normaliseTurns(move.Turns) == 2 {
+			cost += 1
+		}
+		if move.Wide {
+			cost += 1
+		}
+	}
+	return cost
+}
@@ -0,0 +1,290 @@
+package notation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Move is a single turn in Singmaster notation. Face is one of the outer faces
+// U, D, L, R, F, B, the slices M, E, S or one of the whole cube rotations x, y, z.
+// Wide moves turn an outer face together with the slice next to it. Turns is 1 for
+// a clockwise quarter turn, 2 for a half turn and -1 for an anticlockwise quarter turn
+type Move struct {
+	Face  rune
+	Turns int
+	Wide  bool
+}
+
+var ErrUnknownMove = errors.New("unknown move")
+var ErrInvalidSuffix = errors.New("invalid move suffix")
+var ErrUnterminatedComment = errors.New("unterminated comment")
+
+// ParseError reports where in the input a move sequence couldn't be understood.
+// Pos is the byte offset of the offending token
+type ParseError struct {
+	Input string
+	Pos   int
+	Token string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v %q at position %d", e.Err, e.Token, e.Pos)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// faces maps each notation face to the rune used by cube.Transform for a clockwise turn
+var faces = map[rune]rune{
+	'F': 'F',
+	'L': 'L',
+	'R': 'R',
+	'B': 'B',
+	'U': 'U',
+	'D': 'D',
+	'M': 'M',
+	'E': 'E',
+	'S': 'S',
+	'x': 'X',
+	'y': 'Y',
+	'z': 'Z',
+}
+
+// transformFaces is the inverse of faces, used when reading cube.Transform strings
+var transformFaces = func() map[rune]rune {
+	m := make(map[rune]rune, len(faces))
+	for k, v := range faces {
+		m[v] = k
+	}
+	return m
+}()
+
+func (m Move) IsRotation() bool {
+	return m.Face == 'x' || m.Face == 'y' || m.Face == 'z'
+}
+
+// innerSlices gives the slice and direction of the layer next to each face, used for moves like 2R
+var innerSlices = map[rune]Move{
+	'L': {Face: 'M', Turns: 1},
+	'R': {Face: 'M', Turns: -1},
+	'D': {Face: 'E', Turns: 1},
+	'U': {Face: 'E', Turns: -1},
+	'F': {Face: 'S', Turns: 1},
+	'B': {Face: 'S', Turns: -1},
+}
+
+func (m Move) IsOuterFace() bool {
+	_, isOuterFace := innerSlices[m.Face]
+	return isOuterFace
+}
+
+func (m Move) String() string {
+	face := string(m.Face)
+	if m.Wide {
+		face += "w"
+	}
+	switch normaliseTurns(m.Turns) {
+	case 1:
+		return face
+	case 2:
+		return face + "2"
+	case 3:
+		return face + "'"
+	}
+	return ""
+}
+
+// normaliseTurns reduces a number of clockwise quarter turns to 0, 1, 2 or 3
+func normaliseTurns(turns int) int {
+	return ((turns % 4) + 4) % 4
+}
+
+// Parse reads a sequence of moves such as "R U R' U2 x y'". Moves may be separated
+// by whitespace or written together, and comments are ignored. Both "//" line
+// comments and "/* */" block comments are supported. Wide moves can be written as
+// "Rw" or "r", and "2R" is the inner slice next to R
+func Parse(s string) ([]Move, error) {
+	var moves []Move
+	i := 0
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		if strings.HasPrefix(s[i:], "//") {
+			end := strings.IndexByte(s[i:], '\n')
+			if end == -1 {
+				break
+			}
+			i += end + 1
+			continue
+		}
+		if strings.HasPrefix(s[i:], "/*") {
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return nil, &ParseError{Input: s, Pos: i, Token: s[i:], Err: ErrUnterminatedComment}
+			}
+			i += end + 4
+			continue
+		}
+
+		move, moveSize, err := readMove(s, i)
+		if err != nil {
+			return nil, err
+		}
+		i += moveSize
+
+		suffix, suffixSize := readSuffix(s[i:])
+		switch suffix {
+		case "":
+		case "'", "’":
+			move.Turns = -move.Turns
+		case "2", "2'", "2’":
+			move.Turns = 2
+		default:
+			return nil, &ParseError{Input: s, Pos: i, Token: suffix, Err: ErrInvalidSuffix}
+		}
+		i += suffixSize
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+// readMove reads the face of a move starting at position i, including any wide or slice markers
+func readMove(s string, i int) (Move, int, error) {
+	r, size := utf8.DecodeRuneInString(s[i:])
+	if r == '2' {
+		face, faceSize := utf8.DecodeRuneInString(s[i+size:])
+		slice, validFace := innerSlices[face]
+		if !validFace {
+			return Move{}, 0, &ParseError{Input: s, Pos: i, Token: s[i : i+size+faceSize], Err: ErrUnknownMove}
+		}
+		return slice, size + faceSize, nil
+	}
+	if _, validFace := innerSlices[unicode.ToUpper(r)]; validFace && unicode.IsLower(r) {
+		return Move{Face: unicode.ToUpper(r), Turns: 1, Wide: true}, size, nil
+	}
+	if _, validFace := faces[r]; !validFace {
+		return Move{}, 0, &ParseError{Input: s, Pos: i, Token: string(r), Err: ErrUnknownMove}
+	}
+	move := Move{Face: r, Turns: 1}
+	if strings.HasPrefix(s[i+size:], "w") {
+		if !move.IsOuterFace() {
+			return Move{}, 0, &ParseError{Input: s, Pos: i, Token: s[i : i+size+1], Err: ErrUnknownMove}
+		}
+		move.Wide = true
+		size += 1
+	}
+	return move, size, nil
+}
+
+// readSuffix returns the run of digits and primes directly following a face
+func readSuffix(s string) (string, int) {
+	size := 0
+	for size < len(s) {
+		r, runeSize := utf8.DecodeRuneInString(s[size:])
+		if !unicode.IsDigit(r) && r != '\'' && r != '’' {
+			break
+		}
+		size += runeSize
+	}
+	return s[:size], size
+}
+
+// Format writes moves in standard notation separated by single spaces
+func Format(moves []Move) string {
+	parts := make([]string, 0, len(moves))
+	for _, move := range moves {
+		if s := move.String(); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ToTransform converts moves into the encoding used by cube.Transform, where an
+// upper case rune is a clockwise quarter turn and lower case is anticlockwise.
+// Wide moves are followed by a 'w', e.g. "Rw" and "rw"
+func ToTransform(moves []Move) string {
+	sb := strings.Builder{}
+	for _, move := range moves {
+		clockwise := string(faces[move.Face])
+		anticlockwise := strings.ToLower(clockwise)
+		if move.Wide {
+			clockwise += "w"
+			anticlockwise += "w"
+		}
+		switch normaliseTurns(move.Turns) {
+		case 1:
+			sb.WriteString(clockwise)
+		case 2:
+			sb.WriteString(clockwise)
+			sb.WriteString(clockwise)
+		case 3:
+			sb.WriteString(anticlockwise)
+		}
+	}
+	return sb.String()
+}
+
+// FromTransform converts a cube.Transform string into moves. Turns of the same face next to
+// each other are combined, so "FF" becomes F2, "RRR" becomes R' and "Ff" cancels out
+func FromTransform(transform string) ([]Move, error) {
+	var moves []Move
+	for i := 0; i < len(transform); {
+		r, size := utf8.DecodeRuneInString(transform[i:])
+		face, validFace := transformFaces[unicode.ToUpper(r)]
+		if !validFace {
+			return nil, &ParseError{Input: transform, Pos: i, Token: string(r), Err: ErrUnknownMove}
+		}
+		move := Move{Face: face, Turns: 1}
+		if unicode.IsLower(r) {
+			move.Turns = -1
+		}
+		if strings.HasPrefix(transform[i+size:], "w") {
+			if !move.IsOuterFace() {
+				return nil, &ParseError{Input: transform, Pos: i, Token: transform[i : i+size+1], Err: ErrUnknownMove}
+			}
+			move.Wide = true
+			size += 1
+		}
+		i += size
+		if last := len(moves) - 1; last >= 0 && moves[last].Face == move.Face && moves[last].Wide == move.Wide {
+			moves[last].Turns += move.Turns
+			// a move that cancels out lets the moves either side of it combine
+			if normaliseTurns(moves[last].Turns) == 0 {
+				moves = moves[:last]
+			}
+			continue
+		}
+		moves = append(moves, move)
+	}
+	result := moves[:0]
+	for _, move := range moves {
+		switch normaliseTurns(move.Turns) {
+		case 1:
+			result = append(result, Move{Face: move.Face, Turns: 1, Wide: move.Wide})
+		case 2:
+			result = append(result, Move{Face: move.Face, Turns: 2, Wide: move.Wide})
+		case 3:
+			result = append(result, Move{Face: move.Face, Turns: -1, Wide: move.Wide})
+		}
+	}
+	return result, nil
+}
+
+// Translate parses a move sequence in standard notation and returns it in the
+// encoding used by cube.Transform
+func Translate(s string) (string, error) {
+	moves, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return ToTransform(moves), nil
+}
@@ -0,0 +1,245 @@
+package notation
+
+import (
+	"errors"
+	"github.com/matthewjackswann/rubiks/cube"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Move
+	}{
+		{"", nil},
+		{"R", []Move{{'R', 1, false}}},
+		{"R U R' U2 F2", []Move{{'R', 1, false}, {'U', 1, false}, {'R', -1, false}, {'U', 2, false}, {'F', 2, false}}},
+		{"RUR'U'", []Move{{'R', 1, false}, {'U', 1, false}, {'R', -1, false}, {'U', -1, false}}},
+		{"  x y'\tz2\n", []Move{{'x', 1, false}, {'y', -1, false}, {'z', 2, false}}},
+		{"R’ U2'", []Move{{'R', -1, false}, {'U', 2, false}}},
+		{"R U // sexy move\nR' U'", []Move{{'R', 1, false}, {'U', 1, false}, {'R', -1, false}, {'U', -1, false}}},
+		{"F /* setup */ D2 // trailing", []Move{{'F', 1, false}, {'D', 2, false}}},
+	}
+	for _, test := range tests {
+		moves, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%q should parse but got %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(moves, test.expected) {
+			t.Errorf("%q parsed to %v rather than %v", test.input, moves, test.expected)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		err   error
+	}{
+		{"R U Q", 4, ErrUnknownMove},
+		{"R X", 2, ErrUnknownMove},
+		{"R3", 1, ErrInvalidSuffix},
+		{"R U2'2", 3, ErrInvalidSuffix},
+		{"R /* comment", 2, ErrUnterminatedComment},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("%q should fail with a ParseError but got %v", test.input, err)
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q failed with %v rather than %v", test.input, parseError.Err, test.err)
+		}
+		if parseError.Pos != test.pos {
+			t.Errorf("%q failed at position %d rather than %d", test.input, parseError.Pos, test.pos)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	moves := []Move{{'R', 1, false}, {'U', -1, false}, {'F', 2, false}, {'x', 3, false}, {'y', -2, false}, {'D', 4, false}}
+	if s := Format(moves); s != "R U' F2 x' y2" {
+		t.Errorf("Formatted as %q", s)
+	}
+}
+
+func TestToTransform(t *testing.T) {
+	tests := [][2]string{
+		{"R U R' U'", "RUru"},
+		{"F2 B2", "FFBB"},
+		{"x y' z2", "XyZZ"},
+	}
+	for _, test := range tests {
+		transform, err := Translate(test[0])
+		if err != nil {
+			t.Errorf("%q should parse but got %v", test[0], err)
+		}
+		if transform != test[1] {
+			t.Errorf("%q should translate to %s rather than %s", test[0], test[1], transform)
+		}
+	}
+}
+
+func TestFromTransform(t *testing.T) {
+	tests := [][2]string{
+		{"RUru", "R U R' U'"},
+		{"FFbb", "F2 B2"},
+		{"RRRL", "R' L"},
+		{"UUUUD", "D"},
+		{"XyZZ", "x y' z2"},
+		{"Ff", ""},
+		{"RFfU", "R U"},
+		{"RFfr", ""},
+		{"RwrwU", "U"},
+		{"UUUUuD", "U' D"},
+	}
+	for _, test := range tests {
+		moves, err := FromTransform(test[0])
+		if err != nil {
+			t.Errorf("%s should convert but got %v", test[0], err)
+		}
+		if s := Format(moves); s != test[1] {
+			t.Errorf("%s should convert to %q rather than %q", test[0], test[1], s)
+		}
+	}
+
+	_, err := FromTransform("RU?")
+	var parseError *ParseError
+	if !errors.As(err, &parseError) || parseError.Pos != 2 {
+		t.Errorf("RU? should fail at position 2 but got %v", err)
+	}
+}
+
+func TestTranslate_AppliesToCube(t *testing.T) {
+	transform, err := Translate("R U R' U' R' F R2 U' R' U' R U R' F'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cube.NewSolvedCube()
+	c.Transform(transform)
+	c.Transform(transform)
+	if !c.IsSolved() {
+		t.Errorf("T perm applied twice should solve the cube")
+	}
+
+	moves, err := FromTransform(cube.ReverseTransform(transform))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Format(moves); s != "F R U' R' U R U R2 F' R U R U' R'" {
+		t.Errorf("Reversed T perm formatted as %q", s)
+	}
+}
+
+func TestParse_SlicesAndWide(t *testing.T) {
+	tests := [][2]string{
+		{"M E S M' E2 S'", "M E S M' E2 S'"},
+		{"r u' f2 l d b", "Rw Uw' Fw2 Lw Dw Bw"},
+		{"Rw Uw' Fw2", "Rw Uw' Fw2"},
+		{"2R 2L' 2U 2D 2F2 2B", "M' M' E' E S2 S'"},
+		{"x r2 M'", "x Rw2 M'"},
+	}
+	for _, test := range tests {
+		moves, err := Parse(test[0])
+		if err != nil {
+			t.Errorf("%q should parse but got %v", test[0], err)
+			continue
+		}
+		if s := Format(moves); s != test[1] {
+			t.Errorf("%q should format as %q rather than %q", test[0], test[1], s)
+		}
+	}
+
+	for _, input := range []string{"Mw", "xw", "2M", "2x", "3R"} {
+		if _, err := Parse(input); !errors.Is(err, ErrUnknownMove) {
+			t.Errorf("%q should fail as an unknown move but got %v", input, err)
+		}
+	}
+}
+
+func TestToTransform_SlicesAndWide(t *testing.T) {
+	transform, err := Translate("M' Rw u2 S 2R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transform != "mRwUwUwSm" {
+		t.Errorf("Translated to %s", transform)
+	}
+	moves, err := FromTransform(transform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Format(moves); s != "M' Rw Uw2 S M'" {
+		t.Errorf("Converted back to %q", s)
+	}
+
+	c := cube.NewSolvedCube()
+	c.Transform(transform)
+	d := cube.NewSolvedCube()
+	d.Transform("mLXDyDySm")
+	cId, _ := c.EncodeCube()
+	dId, _ := d.EncodeCube()
+	if !cId.Equals(dId) {
+		t.Errorf("Slice and wide moves should match the equivalent face turns and rotations")
+	}
+}
+
+func TestNormalise(t *testing.T) {
+	tests := [][2]string{
+		{"D U", "U D"},
+		{"U D", "U D"},
+		{"R U D' F", "R U D' F"},
+		{"R L' M", "L' R M"},
+		{"U D U", "U2 D"},
+		{"U R R' D", "U D"},
+		{"U R R' U'", ""},
+		{"B F B'", "F"},
+		{"Uw U y", "U Uw y"},
+		{"R U R' U'", "R U R' U'"},
+	}
+	for _, test := range tests {
+		moves, err := Parse(test[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := Format(Normalise(moves)); s != test[1] {
+			t.Errorf("%q should normalise to %q rather than %q", test[0], test[1], s)
+		}
+	}
+}
+
+func TestNormalise_SameCube(t *testing.T) {
+	for _, s := range []string{"D U F2 B' R L2 M E S' Dw x y'", "B F B' D U D' R L R'"} {
+		moves, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, normalised := cube.NewSolvedCube(), cube.NewSolvedCube()
+		c.Transform(ToTransform(moves))
+		normalised.Transform(ToTransform(Normalise(moves)))
+		if c.Layout != normalised.Layout {
+			t.Errorf("Normalising %q changed the cube it gives", s)
+		}
+	}
+}
+
+func TestErgonomicCost(t *testing.T) {
+	cost := func(s string) int {
+		moves, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ErgonomicCost(moves)
+	}
+	if cost("") != 0 || cost("R U R' U'") != 4 {
+		t.Errorf("R and U turns should cost one each")
+	}
+	if cost("R U") >= cost("B D") || cost("R") >= cost("R2") || cost("R") >= cost("Rw") || cost("R") >= cost("x R") {
+		t.Errorf("B and D, half turns, wide moves and rotations should all be more awkward")
+	}
+}
@@ -6,23 +6,71 @@ import (
 	"flag"
 	"fmt"
 	"github.com/matthewjackswann/rubiks/cube"
+	"github.com/matthewjackswann/rubiks/notation"
 	"github.com/matthewjackswann/rubiks/util"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
 	serverFlags := flag.NewFlagSet("server", flag.ExitOnError)
 	serverPort := serverFlags.Int("port", 3000, "Port the server will be hosted on")
-	dbPathServer := serverFlags.String("db", "", "Path to sqlite database")
+	dbPathServer := serverFlags.String("db", "", "Path to sqlite database or table file")
+	backendServer := serverFlags.String("backend", "stickers", "Cube representation used when searching, 'stickers' or 'cubies'")
+	patternsServer := serverFlags.String("patterns", "", "Directory of pattern databases, enables the optimal strategy")
+	metricServer := serverFlags.String("metric", "htm", "Metric the optimal strategy's solutions are optimal in, 'qtm' or 'htm'")
 
 	generateFlags := flag.NewFlagSet("generate", flag.ExitOnError)
 	dbPathGenerator := generateFlags.String("db", "", "Path to sqlite database")
+	metricGenerator := generateFlags.String("metric", "qtm", "Metric solutions are optimal in, 'qtm' (quarter turns) or 'htm' (half turns)")
+	encodingGenerator := generateFlags.String("encoding", "rotations", "Cubes sharing an id, 'rotations' or 'reflections' to also share with mirror images and save about half the space")
+	backendGenerator := generateFlags.String("backend", "stickers", "Cube representation used to apply transforms, 'stickers' or 'cubies'")
+	filterGenerator := generateFlags.Bool("filter", false, "Build a bloom filter of the saved cubes next to the database")
+	workersGenerator := generateFlags.Int("workers", runtime.NumCPU(), "Number of goroutines applying transforms to cubes")
+	maxDurationGenerator := generateFlags.Duration("max-duration", 0, "Stop generating after this long, e.g. '8h', 0 for no limit")
+	maxStatesGenerator := generateFlags.Int("max-states", 0, "Stop generating after this many states, 0 for no limit")
+	batchSizeGenerator := generateFlags.Int("batch-size", 0, "Transforms saved in each batch, 0 to adapt to how long batches take to save")
+	memoryBudgetGenerator := generateFlags.String("memory-budget", "0", "Memory the batches can use, e.g. '512MB', 0 for no limit")
+	minFreeGenerator := generateFlags.String("min-free", util.DefaultDiskThreshold.String(), "Free space to keep on the database's disk, a percentage like '5%' or a size like '10GB'")
+	diskFullGenerator := generateFlags.String("disk-full", "stop", "What to do when the disk reaches -min-free, 'stop' or 'pause' until space is freed")
+	progressGenerator := generateFlags.String("progress", "auto", "Progress output, 'tty' for a status line, 'json' for a JSON object per line or 'auto' to pick by whether stdout is a terminal")
+	frontierGenerator := generateFlags.Bool("frontier", false, "Generate a depth at a time from the cubes of the last depth, giving exact distances")
+	frontierDirGenerator := generateFlags.String("frontier-dir", "", "Directory the frontier of each depth is kept in, defaults to the database path with .frontier")
+
+	patternFlags := flag.NewFlagSet("patterns", flag.ExitOnError)
+	dirPatterns := patternFlags.String("dir", "", "Directory to save the pattern databases in")
+	metricPatterns := patternFlags.String("metric", "htm", "Metric the pattern databases count moves in, 'qtm' or 'htm'")
+
+	convertFlags := flag.NewFlagSet("convert", flag.ExitOnError)
+	dbPathConvert := convertFlags.String("db", "", "Path to sqlite database to convert")
+	outPathConvert := convertFlags.String("out", "", "Path to write the table file to")
+
+	filterFlags := flag.NewFlagSet("filter", flag.ExitOnError)
+	dbPathFilter := filterFlags.String("db", "", "Path to sqlite database to build the bloom filter for")
+	falsePositiveRateFilter := filterFlags.Float64("fp", 0.01, "False positive rate the bloom filter is sized for")
+
+	dbInfoFlags := flag.NewFlagSet("db info", flag.ExitOnError)
+	dbPathInfo := dbInfoFlags.String("db", "", "Path to sqlite database or table file to inspect")
+	jsonInfo := dbInfoFlags.Bool("json", false, "Print the information as JSON")
+
+	dbVerifyFlags := flag.NewFlagSet("db verify", flag.ExitOnError)
+	dbPathVerify := dbVerifyFlags.String("db", "", "Path to sqlite database or table file to verify")
+	sampleVerify := dbVerifyFlags.Int("sample", 0, "Number of random rows to verify, 0 verifies every row")
+	workersVerify := dbVerifyFlags.Int("workers", runtime.NumCPU(), "Number of goroutines verifying rows")
+	fixVerify := dbVerifyFlags.String("fix", "none", "What to do with wrong rows: none, delete or repair")
+	jsonVerify := dbVerifyFlags.Bool("json", false, "Print the result as JSON")
+
+	dbMigrateFlags := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	dbPathMigrate := dbMigrateFlags.String("db", "", "Path to sqlite database to migrate")
+	outPathMigrate := dbMigrateFlags.String("out", "", "Path to write the migrated sqlite database to")
+	encodingMigrate := dbMigrateFlags.String("encoding", "reflections", "Encoding of the migrated database, 'rotations' or 'reflections'")
 
 	if len(os.Args) < 2 {
-		fmt.Println("Not enough arguments\nExpected 'server' or 'generate' subcommand")
+		fmt.Println("Not enough arguments\nExpected 'server', 'generate', 'patterns', 'convert', 'filter' or 'db' subcommand")
 		return
 	}
 
@@ -34,14 +82,42 @@ func main() {
 			return
 		}
 		if *dbPathServer == "" {
-			fmt.Println("Please provide a path to the database to use for cube lookups")
+			fmt.Println("No database provided, the minimal strategy won't be available")
+		} else if _, err := os.Stat(*dbPathServer); errors.Is(err, os.ErrNotExist) {
+			fmt.Println("couldn't resolve file at location", *dbPathServer)
 			return
 		}
-		if _, err := os.Stat(*dbPathServer); errors.Is(err, os.ErrNotExist) {
-			fmt.Println("couldn't resolve file at location", *dbPathServer)
+		backend, err := cube.ParseBackend(*backendServer)
+		if err != nil {
+			fmt.Println(err)
 			return
 		}
-		startServer(*serverPort, *dbPathServer)
+		var korfSolver *util.KorfSolver
+		if *patternsServer != "" {
+			metric, err := cube.ParseMetric(*metricServer)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			databases, err := util.LoadPatternDatabases(*patternsServer, metric, util.KorfPatternSets)
+			if err != nil {
+				fmt.Println("Couldn't load pattern databases")
+				fmt.Println(err)
+				return
+			}
+			korfSolver = util.NewKorfSolver(metric, databases)
+		}
+		var store util.SolutionStore
+		if *dbPathServer != "" {
+			store, err = openSolutionStore(*dbPathServer)
+			if err != nil {
+				fmt.Println("Couldn't open the database")
+				fmt.Println(err)
+				return
+			}
+			defer store.Close()
+		}
+		startServer(*serverPort, store, backend, korfSolver)
 
 	case "generate":
 		if err := generateFlags.Parse(os.Args[2:]); err != nil {
@@ -52,8 +128,94 @@ func main() {
 			fmt.Println("Please provide a path to the database to save the generated cubes to")
 			return
 		}
+		metric, err := cube.ParseMetric(*metricGenerator)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		encoding, err := cube.ParseEncoding(*encodingGenerator)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		backend, err := cube.ParseBackend(*backendGenerator)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		db := util.CreateDBConnection(*dbPathGenerator)
-		nextInfo := db.GetNextTransforms()
+		if !db.IsEmpty() && db.GetMetric() != metric {
+			fmt.Printf("The database was generated using the %s metric, it can't be continued using %s\n", db.GetMetric(), metric)
+			db.Close()
+			return
+		}
+		if !db.IsEmpty() && db.GetEncoding() != encoding {
+			fmt.Printf("The database was generated using %s, it can't be continued using %s, see 'db migrate'\n", db.GetEncoding(), encoding)
+			db.Close()
+			return
+		}
+		if !db.SetMetric(metric) || !db.SetEncoding(encoding) {
+			db.Close()
+			return
+		}
+		memoryBudget, err := util.ParseByteSize(*memoryBudgetGenerator)
+		if err != nil {
+			fmt.Println(err)
+			db.Close()
+			return
+		}
+		minFree, err := util.ParseDiskThreshold(*minFreeGenerator)
+		if err != nil {
+			fmt.Println(err)
+			db.Close()
+			return
+		}
+		if *diskFullGenerator != "stop" && *diskFullGenerator != "pause" {
+			fmt.Printf("Unknown -disk-full %q, expected stop or pause\n", *diskFullGenerator)
+			db.Close()
+			return
+		}
+		disk := util.DiskGuardConfig{Threshold: minFree, ThresholdSet: true, Pause: *diskFullGenerator == "pause"}
+		progressFormat, err := util.ParseProgressFormat(*progressGenerator)
+		if err != nil {
+			fmt.Println(err)
+			db.Close()
+			return
+		}
+		progress := util.NewProgressReporter(os.Stdout, progressFormat, metric, encoding, *dbPathGenerator)
+		var store util.SolutionStore = db
+		if *filterGenerator {
+			store, err = util.OpenGeneratorFilter(db, 0.01)
+			if err != nil {
+				fmt.Println("Couldn't build the bloom filter")
+				fmt.Println(err)
+				db.Close()
+				return
+			}
+		}
+		if *frontierGenerator {
+			frontierDir := *frontierDirGenerator
+			if frontierDir == "" {
+				frontierDir = *dbPathGenerator + ".frontier"
+			}
+			err := util.StartFrontierGenerator(store, util.FrontierConfig{
+				MaximumDepth: util.MaxEncodedDepth(metric),
+				Metric:       metric,
+				Backend:      backend,
+				Dir:          frontierDir,
+				Workers:      *workersGenerator,
+				MaxDuration:  *maxDurationGenerator,
+				MaxStates:    *maxStatesGenerator,
+				Progress:     progress,
+				Disk:         disk,
+			})
+			if err != nil {
+				fmt.Println("Error generating frontiers")
+				fmt.Println(err)
+			}
+			return
+		}
+		nextInfo := db.GetCheckpoint()
 		stackString := strings.Split(nextInfo.EncodedStack, ",")
 		initStack := make([]int, len(stackString))
 		for i, s := range stackString {
@@ -64,20 +226,215 @@ func main() {
 			}
 			initStack[i] = si
 		}
-		startGenerator(db, initStack, nextInfo.NextNum, 16)
+		startGenerator(store, initStack, nextInfo.NextNum, util.GeneratorConfig{
+			MaximumDepth: util.MaxEncodedDepth(metric),
+			Metric:       metric,
+			Backend:      backend,
+			Workers:      *workersGenerator,
+			MaxDuration:  *maxDurationGenerator,
+			MaxStates:    *maxStatesGenerator,
+			Progress:     progress,
+			BatchSize:    *batchSizeGenerator,
+			MemoryBudget: memoryBudget,
+			Disk:         disk,
+		})
+
+	case "patterns":
+		if err := patternFlags.Parse(os.Args[2:]); err != nil {
+			fmt.Println("error processing patterns args")
+			return
+		}
+		if *dirPatterns == "" {
+			fmt.Println("Please provide a directory to save the pattern databases to")
+			return
+		}
+		metric, err := cube.ParseMetric(*metricPatterns)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if _, err := util.LoadPatternDatabases(*dirPatterns, metric, util.KorfPatternSets); err != nil {
+			fmt.Println(err)
+		}
+
+	case "convert":
+		if err := convertFlags.Parse(os.Args[2:]); err != nil {
+			fmt.Println("error processing convert args")
+			return
+		}
+		if *dbPathConvert == "" || *outPathConvert == "" {
+			fmt.Println("Please provide the database to convert and where to write the table file")
+			return
+		}
+		if _, err := os.Stat(*dbPathConvert); errors.Is(err, os.ErrNotExist) {
+			fmt.Println("couldn't resolve file at location", *dbPathConvert)
+			return
+		}
+		db := util.CreateDBConnection(*dbPathConvert)
+		if err := util.ConvertToTableFile(db, *outPathConvert); err != nil {
+			fmt.Println("Couldn't convert the database")
+			fmt.Println(err)
+		}
+		db.Close()
+
+	case "filter":
+		if err := filterFlags.Parse(os.Args[2:]); err != nil {
+			fmt.Println("error processing filter args")
+			return
+		}
+		if *dbPathFilter == "" {
+			fmt.Println("Please provide the database to build the bloom filter for")
+			return
+		}
+		if _, err := os.Stat(*dbPathFilter); errors.Is(err, os.ErrNotExist) {
+			fmt.Println("couldn't resolve file at location", *dbPathFilter)
+			return
+		}
+		db := util.CreateDBConnection(*dbPathFilter)
+		filter, err := util.BuildBloomFilter(db, 0, *falsePositiveRateFilter)
+		if err == nil {
+			err = filter.Write(util.BloomFilterPath(*dbPathFilter), db.GetCheckpoint())
+		}
+		if err != nil {
+			fmt.Println("Couldn't build the bloom filter")
+			fmt.Println(err)
+		} else {
+			fmt.Println(filter)
+			fmt.Printf("Measured false positive rate %.4f%%\n", 100*filter.MeasureFalsePositiveRate(1000000))
+		}
+		db.Close()
+
+	case "db":
+		if len(os.Args) < 3 || (os.Args[2] != "info" && os.Args[2] != "verify" && os.Args[2] != "migrate") {
+			fmt.Println("Expected 'db info', 'db verify' or 'db migrate'")
+			return
+		}
+		if os.Args[2] == "migrate" {
+			if err := dbMigrateFlags.Parse(os.Args[3:]); err != nil {
+				fmt.Println("error processing db migrate args")
+				return
+			}
+			migrateDatabase(*dbPathMigrate, *outPathMigrate, *encodingMigrate)
+			return
+		}
+		if os.Args[2] == "verify" {
+			if err := dbVerifyFlags.Parse(os.Args[3:]); err != nil {
+				fmt.Println("error processing db verify args")
+				return
+			}
+			verifyDatabase(*dbPathVerify, *sampleVerify, *workersVerify, *fixVerify, *jsonVerify)
+			return
+		}
+		if err := dbInfoFlags.Parse(os.Args[3:]); err != nil {
+			fmt.Println("error processing db info args")
+			return
+		}
+		if *dbPathInfo == "" {
+			fmt.Println("Please provide the database to inspect")
+			return
+		}
+		info, err := util.InspectDatabase(*dbPathInfo)
+		if err != nil {
+			fmt.Println("Couldn't inspect the database")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *jsonInfo {
+			encoded, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(encoded))
+		} else {
+			fmt.Print(info)
+		}
+		if !info.Healthy() {
+			os.Exit(1)
+		}
 
 	default:
-		fmt.Println("Expected 'server' or 'generate' subcommand")
+		fmt.Println("Expected 'server', 'generate', 'patterns', 'convert', 'filter' or 'db' subcommand")
 	}
 }
 
+// verifyDatabase runs db verify, exiting with 1 if any row is wrong
+func verifyDatabase(dbPath string, sample, workers int, fix string, asJson bool) {
+	if dbPath == "" {
+		fmt.Println("Please provide the database to verify")
+		return
+	}
+	verifyFix, err := util.ParseVerifyFix(fix)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	result, err := util.VerifyDatabase(dbPath, util.VerifyConfig{Sample: sample, Workers: workers, Fix: verifyFix})
+	if err != nil {
+		fmt.Println("Couldn't verify the database")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if asJson {
+		encoded, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(encoded))
+	} else {
+		fmt.Print(result)
+	}
+	if len(result.Mismatches) > result.Deleted+result.Repaired {
+		os.Exit(1)
+	}
+}
+
+// migrateDatabase runs db migrate, exiting with 1 if the migration fails
+func migrateDatabase(dbPath, outPath, encodingName string) {
+	if dbPath == "" || outPath == "" {
+		fmt.Println("Please provide the database to migrate and where to write the migrated database")
+		return
+	}
+	encoding, err := cube.ParseEncoding(encodingName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		fmt.Println("couldn't resolve file at location", dbPath)
+		return
+	}
+	db := util.CreateDBConnection(dbPath)
+	defer db.Close()
+	ids, err := util.MigrateEncoding(db, outPath, encoding)
+	if err != nil {
+		fmt.Println("Couldn't migrate the database")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d cube ids encoded with %s to %s\n", ids, encoding, outPath)
+}
+
 // server stuff
 
-func startServer(port int, dbPath string) {
+// openSolutionStore opens a table file, or a sqlite database if the file isn't a table file
+func openSolutionStore(path string) (util.SolutionStore, error) {
+	table, err := util.OpenTableFile(path)
+	if err == nil {
+		return table, nil
+	}
+	if !errors.Is(err, util.ErrNotTableFile) {
+		return nil, err
+	}
+	return util.OpenFilteredStore(util.CreateDBConnection(path)), nil
+}
+
+func startServer(port int, store util.SolutionStore, backend cube.Backend, korfSolver *util.KorfSolver) {
 	fmt.Printf("Starting Server at localhost:%d \nUse ^C to stop\n", port)
 	http.Handle("/", http.FileServer(http.Dir("./frontEnd/build")))
 	http.HandleFunc("/cube", fulfillCubeTransformRequest)
-	http.HandleFunc("/cubeMinimalSol", fulfillCubeMinimalSolveRequest(dbPath))
+	http.HandleFunc("/cubeMinimalSol", fulfillCubeMinimalSolveRequest(store, backend, korfSolver))
+	http.HandleFunc("/cubeAllSolutions", fulfillCubeAllSolutionsRequest(store, backend))
+	go util.WarmUpTwoPhase()
 	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 	if err != nil {
 		fmt.Println("Couldn't start server")
@@ -89,6 +446,7 @@ func startServer(port int, dbPath string) {
 type CubeData struct {
 	CubeLayout     [54]int
 	Transformation string
+	Notation       string // optional, standard notation applied after Transformation
 }
 
 func fulfillCubeTransformRequest(w http.ResponseWriter, r *http.Request) {
@@ -100,8 +458,20 @@ func fulfillCubeTransformRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notationTransform, err := notation.Translate(data.Notation)
+	if err != nil {
+		fmt.Println(fmt.Errorf("error: %v", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	c := cube.NewCube(data.CubeLayout)
+	if err := c.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
 	c.Transform(data.Transformation)
+	c.Transform(notationTransform)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -112,16 +482,49 @@ func fulfillCubeTransformRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type CubeValidationFailure struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail"`
+}
+
+// writeValidationError responds with 422 and the reason a cube layout is impossible
+func writeValidationError(w http.ResponseWriter, err error) {
+	failure := CubeValidationFailure{Error: "invalid_cube", Detail: err.Error()}
+	var validationError *cube.ValidationError
+	if errors.As(err, &validationError) {
+		failure = CubeValidationFailure{Error: string(validationError.Reason), Detail: validationError.Detail}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	err = json.NewEncoder(w).Encode(failure)
+	if err != nil {
+		fmt.Println(fmt.Errorf("error: %v", err))
+	}
+}
+
+// solving strategies, minimal searches the database for an optimal solution, optimal
+// searches using pattern databases and twophase quickly finds a short solution without either
+const (
+	minimalStrategy  = "minimal"
+	optimalStrategy  = "optimal"
+	twoPhaseStrategy = "twophase"
+)
+
 type CubeDescription struct {
 	CubeLayout [54]int
+	Strategy   string // optional, defaults to minimal when the server has a database
 }
 
 type CubeSolution struct {
 	Success   bool   `json:"success"`
+	Strategy  string `json:"strategy"`
 	Transform string `json:"transform"`
+	Notation  string `json:"notation"`
+	Metric    string `json:"metric"`
+	Length    int    `json:"length"`
 }
 
-func fulfillCubeMinimalSolveRequest(dbPath string) func(w http.ResponseWriter, r *http.Request) {
+func fulfillCubeMinimalSolveRequest(store util.SolutionStore, backend cube.Backend, korfSolver *util.KorfSolver) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := new(CubeDescription)
 		err := json.NewDecoder(r.Body).Decode(data)
@@ -131,15 +534,77 @@ func fulfillCubeMinimalSolveRequest(dbPath string) func(w http.ResponseWriter, r
 			return
 		}
 
-		db := util.CreateDBConnection(dbPath)
 		c := cube.NewCube(data.CubeLayout)
-		solution, success := db.SolveCubeBySearch(c, 6, 10)
+		if err := c.Validate(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		state, err := backend.FromLayout(c.Layout)
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		strategy := data.Strategy
+		if strategy == "" {
+			strategy = minimalStrategy
+			if store == nil {
+				strategy = twoPhaseStrategy
+			}
+		}
+
+		var solution string
+		var success bool
+		var metric cube.Metric
+		switch strategy {
+		case minimalStrategy:
+			if store == nil {
+				http.Error(w, "the minimal strategy needs the server to be started with a database", http.StatusBadRequest)
+				return
+			}
+			solution, success = util.SolveCubeBySearch(store, state, 6, 10)
+			metric = store.GetMetric()
+		case optimalStrategy:
+			if korfSolver == nil {
+				http.Error(w, "the optimal strategy needs the server to be started with pattern databases", http.StatusBadRequest)
+				return
+			}
+			// every cube can be solved in 20 half turns or 26 quarter turns
+			maxDepth := 20
+			if korfSolver.Metric == cube.QuarterTurnMetric {
+				maxDepth = 26
+			}
+			solution, success, err = korfSolver.Solve(state, maxDepth, 30*time.Second)
+			if errors.Is(err, util.ErrKorfTimeout) {
+				http.Error(w, "the optimal search ran out of time, try the minimal or twophase strategy", http.StatusServiceUnavailable)
+				return
+			}
+			metric = korfSolver.Metric
+		case twoPhaseStrategy:
+			solution, success = util.SolveCubeByTwoPhase(state, 24, 5*time.Second)
+			metric = cube.HalfTurnMetric
+		default:
+			http.Error(w, fmt.Sprintf("unknown strategy %q, expected %s, %s or %s", strategy, minimalStrategy, optimalStrategy, twoPhaseStrategy), http.StatusBadRequest)
+			return
+		}
+
+		// joining the search to the stored solution can leave turns like "FFF" to tidy up
+		moves, err := notation.FromTransform(solution)
+		if err != nil {
+			fmt.Println(fmt.Errorf("error: %v", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		solution = notation.ToTransform(moves)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		err = json.NewEncoder(w).Encode(CubeSolution{
 			Success:   success,
+			Strategy:  strategy,
 			Transform: solution,
+			Notation:  notation.Format(moves),
+			Metric:    metric.String(),
+			Length:    metric.Length(solution),
 		})
 		if err != nil {
 			fmt.Println(fmt.Errorf("error: %v", err))
@@ -148,8 +613,85 @@ func fulfillCubeMinimalSolveRequest(dbPath string) func(w http.ResponseWriter, r
 	}
 }
 
+type CubeSolutionsRequest struct {
+	CubeLayout [54]int
+	Limit      int // optional, the most solutions to return, 0 for all of them
+}
+
+type SolutionMoves struct {
+	Transform string `json:"transform"`
+	Notation  string `json:"notation"`
+}
+
+type CubeSolutions struct {
+	Success   bool            `json:"success"`
+	Metric    string          `json:"metric"`
+	Length    int             `json:"length"`
+	Count     int             `json:"count"` // distinct solutions found, more than returned if limited
+	Solutions []SolutionMoves `json:"solutions"`
+}
+
+// fulfillCubeAllSolutionsRequest gives every distinct shortest solution the database leads to,
+// easiest to perform first
+func fulfillCubeAllSolutionsRequest(store util.SolutionStore, backend cube.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := new(CubeSolutionsRequest)
+		err := json.NewDecoder(r.Body).Decode(data)
+		if err != nil {
+			fmt.Println(fmt.Errorf("error: %v", err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if store == nil {
+			http.Error(w, "finding every solution needs the server to be started with a database", http.StatusBadRequest)
+			return
+		}
+		if data.Limit < 0 {
+			http.Error(w, "the limit can't be negative", http.StatusBadRequest)
+			return
+		}
+
+		c := cube.NewCube(data.CubeLayout)
+		if err := c.Validate(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		state, err := backend.FromLayout(c.Layout)
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		transforms, count, err := util.SolveCubeBySearchAll(store, state, 6, 10, data.Limit, 5*time.Second)
+		if errors.Is(err, util.ErrSearchTimeout) {
+			http.Error(w, "the search for every solution ran out of time, try the minimal strategy of /cubeMinimalSol", http.StatusServiceUnavailable)
+			return
+		}
+		metric := store.GetMetric()
+		response := CubeSolutions{Success: count > 0, Metric: metric.String(), Count: count, Solutions: []SolutionMoves{}}
+		for _, transform := range transforms {
+			moves, err := notation.FromTransform(transform)
+			if err != nil {
+				fmt.Println(fmt.Errorf("error: %v", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			response.Solutions = append(response.Solutions, SolutionMoves{Transform: transform, Notation: notation.Format(moves)})
+			response.Length = metric.Length(transform)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			fmt.Println(fmt.Errorf("error: %v", err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
 // generator stuff
 
-func startGenerator(db util.DBConnection, init []int, i, maximumDepth int) {
-	util.StartSolutionGenerator(db, init, i, maximumDepth)
+func startGenerator(db util.SolutionStore, init []int, i int, config util.GeneratorConfig) {
+	util.StartSolutionGenerator(db, init, i, config)
 }
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bytesPerBatchState is roughly the memory a state takes while its batch is generated and
+// saved: the transform string, its entry in the results map and in the map of first indexes
+const bytesPerBatchState = 128
+
+// the generator's batches are kept between these sizes per worker when adapting
+const minBatchPerWorker = 100
+const defaultBatchPerWorker = 1000
+const maxBatchPerWorker = 1000000
+
+// targetCommitLatency is how long an adaptive batch should take to save. Bigger batches spread
+// the cost of each transaction over more cubes but save the checkpoint less often
+const targetCommitLatency = 500 * time.Millisecond
+
+// batchSizer picks the number of transforms in each of the generator's batches
+type batchSizer struct {
+	size, min, max int
+	adaptive       bool
+}
+
+// newBatchSizer uses batchSize if it's set, otherwise it adapts to how long batches take to
+// save. Two batches are held at once, one being generated and one being saved, so both fit
+// within memoryBudget bytes if it's set
+func newBatchSizer(batchSize int, memoryBudget int64, workers int) *batchSizer {
+	b := &batchSizer{
+		size:     defaultBatchPerWorker * workers,
+		min:      minBatchPerWorker * workers,
+		max:      maxBatchPerWorker * workers,
+		adaptive: batchSize <= 0,
+	}
+	if memoryBudget > 0 {
+		budgetSize := int(memoryBudget / (2 * bytesPerBatchState))
+		if budgetSize < 1 {
+			budgetSize = 1
+		}
+		if budgetSize < b.max {
+			b.max = budgetSize
+		}
+		if b.min > b.max {
+			b.min = b.max
+		}
+	}
+	if !b.adaptive {
+		b.size = batchSize
+	}
+	if b.size > b.max {
+		b.size = b.max
+	}
+	if b.size < b.min && b.adaptive {
+		b.size = b.min
+	}
+	return b
+}
+
+// adjust changes the batch size after a batch of size took commit to save, returning whether it changed
+func (b *batchSizer) adjust(size int, commit time.Duration) bool {
+	if !b.adaptive || size < b.size {
+		// batches cut short at the end of a depth don't say much about the batch size
+		return false
+	}
+	next := b.size
+	if commit < targetCommitLatency/2 {
+		next = 2 * b.size
+	} else if commit > 2*targetCommitLatency {
+		next = b.size / 2
+	}
+	if next > b.max {
+		next = b.max
+	}
+	if next < b.min {
+		next = b.min
+	}
+	changed := next != b.size
+	b.size = next
+	return changed
+}
+
+// ParseByteSize reads sizes like "512MB" or "2GB", a number on its own is in bytes
+func ParseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected something like 512MB", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
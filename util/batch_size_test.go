@@ -0,0 +1,61 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchSizer(t *testing.T) {
+	fixed := newBatchSizer(5000, 0, 4)
+	if fixed.size != 5000 || fixed.adjust(5000, time.Millisecond) || fixed.size != 5000 {
+		t.Errorf("A fixed batch size shouldn't change, got %d", fixed.size)
+	}
+
+	adaptive := newBatchSizer(0, 0, 2)
+	if adaptive.size != 2000 {
+		t.Fatalf("The adaptive batch size should start at 2000 but was %d", adaptive.size)
+	}
+	if !adaptive.adjust(2000, time.Millisecond) || adaptive.size != 4000 {
+		t.Errorf("A quick save should grow the batch, got %d", adaptive.size)
+	}
+	if adaptive.adjust(100, time.Millisecond) {
+		t.Errorf("A batch cut short by the end of a depth shouldn't change the size")
+	}
+	if adaptive.adjust(4000, targetCommitLatency) || adaptive.size != 4000 {
+		t.Errorf("A save taking the target time should keep the size, got %d", adaptive.size)
+	}
+	if !adaptive.adjust(4000, 3*targetCommitLatency) || adaptive.size != 2000 {
+		t.Errorf("A slow save should shrink the batch, got %d", adaptive.size)
+	}
+	for i := 0; i < 10; i++ {
+		adaptive.adjust(adaptive.size, 10*targetCommitLatency)
+	}
+	if adaptive.size != 200 {
+		t.Errorf("The batch shouldn't shrink below 100 per worker, got %d", adaptive.size)
+	}
+
+	// two batches of 128 bytes per state fit in 1MB with up to 4096 states
+	budget := newBatchSizer(0, 1<<20, 8)
+	for i := 0; i < 20; i++ {
+		budget.adjust(budget.size, time.Millisecond)
+	}
+	if budget.size != 4096 {
+		t.Errorf("The batch should grow to fit the memory budget, got %d", budget.size)
+	}
+	if capped := newBatchSizer(100000, 1<<20, 1); capped.size != 4096 {
+		t.Errorf("A fixed batch size should be capped by the memory budget, got %d", capped.size)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]int64{"0": 0, "1024": 1024, "512MB": 512 << 20, "2gb": 2 << 30, "1.5KB": 1536, "10 B": 10} {
+		if size, err := ParseByteSize(s); err != nil || size != expected {
+			t.Errorf("%q should be %d bytes but got %d %v", s, expected, size, err)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1GB", "lots"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("%q shouldn't be a valid size", s)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package util
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// BloomFilter records which cube ids have been saved. It never says a saved cube is
+// missing but can say an unsaved cube might be there, at roughly the rate it was sized for
+type BloomFilter struct {
+	bits     []uint64
+	size     uint64 // number of bits
+	hashes   int
+	count    uint64
+	capacity uint64 // number of cubes it was sized for
+}
+
+// NewBloomFilter sizes the filter to hold capacity cubes with the false positive rate given
+func NewBloomFilter(capacity uint64, falsePositiveRate float64) *BloomFilter {
+	if capacity == 0 {
+		capacity = 1
+	}
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	size = (size + 63) / 64 * 64
+	hashes := int(math.Round(float64(size) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{bits: make([]uint64, size/64), size: size, hashes: hashes, capacity: capacity}
+}
+
+// Full is whether the filter holds as many cubes as it was sized for, past which its false
+// positive rate climbs quickly
+func (filter *BloomFilter) Full() bool {
+	return filter.count >= filter.capacity
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// bloomHashes gives two independent hashes of the id, combined to make each of the filter's hashes
+func bloomHashes(cubeId uint128.Uint128) (uint64, uint64) {
+	h1 := splitMix64(cubeId.L ^ splitMix64(cubeId.H))
+	h2 := splitMix64(h1^cubeId.H) | 1
+	return h1, h2
+}
+
+func (filter *BloomFilter) Add(cubeId uint128.Uint128) {
+	h1, h2 := bloomHashes(cubeId)
+	for i := 0; i < filter.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % filter.size
+		filter.bits[bit/64] |= 1 << (bit % 64)
+	}
+	filter.count += 1
+}
+
+func (filter *BloomFilter) MayContain(cubeId uint128.Uint128) bool {
+	h1, h2 := bloomHashes(cubeId)
+	for i := 0; i < filter.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % filter.size
+		if filter.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpectedFalsePositiveRate is the chance an unsaved cube passes the filter, given how full it is
+func (filter *BloomFilter) ExpectedFalsePositiveRate() float64 {
+	return math.Pow(1-math.Exp(-float64(filter.hashes)*float64(filter.count)/float64(filter.size)), float64(filter.hashes))
+}
+
+// MeasureFalsePositiveRate probes random ids, which are almost certainly not real cubes
+func (filter *BloomFilter) MeasureFalsePositiveRate(probes int) float64 {
+	r := rand.New(rand.NewSource(1))
+	positives := 0
+	for i := 0; i < probes; i++ {
+		if filter.MayContain(uint128.Uint128{H: r.Uint64(), L: r.Uint64()}) {
+			positives += 1
+		}
+	}
+	return float64(positives) / float64(probes)
+}
+
+func (filter *BloomFilter) String() string {
+	return fmt.Sprintf("%d cubes in %d bits (%.1fMB) with %d hashes, expected false positive rate %.4f%%",
+		filter.count, filter.size, float64(filter.size)/8/1e6, filter.hashes, 100*filter.ExpectedFalsePositiveRate())
+}
+
+// A filter sidecar is saved next to the database as <db path>.bloom. It records the
+// database's checkpoint when it was written, so a filter that missed some saves isn't used
+
+const bloomFilterMagic = "RCBF"
+const bloomFilterVersion = 1
+
+var ErrStaleFilter = errors.New("the filter doesn't match the database, rebuild it")
+
+func BloomFilterPath(dbPath string) string {
+	return dbPath + ".bloom"
+}
+
+func (filter *BloomFilter) Write(path string, checkpoint Checkpoint) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	header := make([]byte, 32)
+	copy(header[0:4], bloomFilterMagic)
+	binary.LittleEndian.PutUint32(header[4:8], bloomFilterVersion)
+	binary.LittleEndian.PutUint64(header[8:16], filter.size)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(filter.hashes))
+	binary.LittleEndian.PutUint64(header[24:32], filter.count)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	checkpointHeader := make([]byte, 16)
+	binary.LittleEndian.PutUint64(checkpointHeader[0:8], uint64(checkpoint.NextNum))
+	binary.LittleEndian.PutUint64(checkpointHeader[8:16], uint64(len(checkpoint.EncodedStack)))
+	if _, err := w.Write(checkpointHeader); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(checkpoint.EncodedStack); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, filter.bits); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// LoadBloomFilter reads a filter, failing with ErrStaleFilter if it was written at a different checkpoint
+func LoadBloomFilter(path string, checkpoint Checkpoint) (*BloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, 48)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != bloomFilterMagic || binary.LittleEndian.Uint32(header[4:8]) != bloomFilterVersion {
+		return nil, fmt.Errorf("%s isn't a bloom filter", path)
+	}
+	filter := &BloomFilter{
+		size:   binary.LittleEndian.Uint64(header[8:16]),
+		hashes: int(binary.LittleEndian.Uint64(header[16:24])),
+		count:  binary.LittleEndian.Uint64(header[24:32]),
+	}
+	// the capacity isn't saved, but is what the size and hashes were chosen for
+	filter.capacity = uint64(float64(filter.size) * math.Ln2 / float64(filter.hashes))
+	stack := make([]byte, binary.LittleEndian.Uint64(header[40:48]))
+	if _, err := io.ReadFull(r, stack); err != nil {
+		return nil, err
+	}
+	if int(binary.LittleEndian.Uint64(header[32:40])) != checkpoint.NextNum || string(stack) != checkpoint.EncodedStack {
+		return nil, ErrStaleFilter
+	}
+	filter.bits = make([]uint64, filter.size/64)
+	if err := binary.Read(r, binary.LittleEndian, filter.bits); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// BuildBloomFilter adds every cube in the database to a new filter. A capacity of 0 sizes
+// the filter for the cubes already in the database
+func BuildBloomFilter(dbConnection *DBConnection, capacity uint64, falsePositiveRate float64) (*BloomFilter, error) {
+	if capacity == 0 {
+		count, err := dbConnection.countCubes()
+		if err != nil {
+			return nil, err
+		}
+		capacity = count
+	}
+	filter := NewBloomFilter(capacity, falsePositiveRate)
+	rows, err := dbConnection.db.Query("SELECT cube_id_l, cube_id_h FROM cubes;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l, h int64
+		if err := rows.Scan(&l, &h); err != nil {
+			return nil, err
+		}
+		filter.Add(uint128.Uint128{H: uint64(h), L: uint64(l)})
+	}
+	return filter, rows.Err()
+}
+
+// FilterStats counts how often the filter saved a lookup. False positives are cubes
+// that passed the filter but weren't in the store
+type FilterStats struct {
+	Probes         uint64
+	Rejected       uint64
+	FalsePositives uint64
+}
+
+// FalsePositiveRate is the share of cubes not in the store that still passed the filter
+func (stats FilterStats) FalsePositiveRate() float64 {
+	misses := stats.Rejected + stats.FalsePositives
+	if misses == 0 {
+		return 0
+	}
+	return float64(stats.FalsePositives) / float64(misses)
+}
+
+func (stats FilterStats) String() string {
+	return fmt.Sprintf("filter probes: %d, rejected: %d, false positives: %d (%.4f%%)",
+		stats.Probes, stats.Rejected, stats.FalsePositives, 100*stats.FalsePositiveRate())
+}
+
+// FilteredStore checks a bloom filter before looking cubes up in the store, so most
+// misses never reach it. Saved cubes are added to the filter, which is written to
+// filterPath when the store is closed
+type FilteredStore struct {
+	SolutionStore
+	filter     *BloomFilter
+	filterPath string
+	rebuild    func() (*BloomFilter, error) // makes a bigger filter once this one is full, if set
+	lock       sync.RWMutex
+	stats      FilterStats
+}
+
+func NewFilteredStore(store SolutionStore, filter *BloomFilter, filterPath string) *FilteredStore {
+	return &FilteredStore{SolutionStore: store, filter: filter, filterPath: filterPath}
+}
+
+func (store *FilteredStore) mayContain(cubeId uint128.Uint128) bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	atomic.AddUint64(&store.stats.Probes, 1)
+	if !store.filter.MayContain(cubeId) {
+		atomic.AddUint64(&store.stats.Rejected, 1)
+		return false
+	}
+	return true
+}
+
+func (store *FilteredStore) Lookup(cubeId uint128.Uint128) (string, bool) {
+	if !store.mayContain(cubeId) {
+		return "", false
+	}
+	solution, found := store.SolutionStore.Lookup(cubeId)
+	if !found {
+		atomic.AddUint64(&store.stats.FalsePositives, 1)
+	}
+	return solution, found
+}
+
+func (store *FilteredStore) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	solutions := make([]string, len(cubeIds))
+	found := make([]bool, len(cubeIds))
+	var passed []uint128.Uint128
+	var positions []int
+	for i, cubeId := range cubeIds {
+		if store.mayContain(cubeId) {
+			passed = append(passed, cubeId)
+			positions = append(positions, i)
+		}
+	}
+	if len(passed) == 0 {
+		return solutions, found
+	}
+	passedSolutions, passedFound := store.SolutionStore.BatchLookup(passed)
+	for j, i := range positions {
+		solutions[i], found[i] = passedSolutions[j], passedFound[j]
+		if !found[i] {
+			atomic.AddUint64(&store.stats.FalsePositives, 1)
+		}
+	}
+	return solutions, found
+}
+
+func (store *FilteredStore) Save(results map[uint128.Uint128]uint64, checkpoint Checkpoint) bool {
+	// the filter is updated first so a lookup can't miss a cube that's been saved
+	store.lock.Lock()
+	for cubeId := range results {
+		store.filter.Add(cubeId)
+	}
+	full := store.filter.Full()
+	store.lock.Unlock()
+	if !store.SolutionStore.Save(results, checkpoint) {
+		return false
+	}
+	// lookups carry on using the full filter while the new one is built, as it still has every saved cube
+	if full && store.rebuild != nil {
+		filter, err := store.rebuild()
+		if err != nil {
+			fmt.Println("Couldn't rebuild the full bloom filter")
+			fmt.Println(err)
+			return true
+		}
+		store.lock.Lock()
+		store.filter = filter
+		store.lock.Unlock()
+	}
+	return true
+}
+
+func (store *FilteredStore) Stats() FilterStats {
+	return FilterStats{
+		Probes:         atomic.LoadUint64(&store.stats.Probes),
+		Rejected:       atomic.LoadUint64(&store.stats.Rejected),
+		FalsePositives: atomic.LoadUint64(&store.stats.FalsePositives),
+	}
+}
+
+func (store *FilteredStore) Close() {
+	if store.filterPath != "" {
+		if err := store.filter.Write(store.filterPath, store.GetCheckpoint()); err != nil {
+			fmt.Println("Couldn't save the bloom filter")
+			fmt.Println(err)
+		}
+	}
+	store.SolutionStore.Close()
+}
+
+// OpenFilteredStore puts the database's filter sidecar in front of it if there is an
+// up to date one, otherwise the database is used directly
+func OpenFilteredStore(dbConnection *DBConnection) SolutionStore {
+	path := BloomFilterPath(dbConnection.Path())
+	filter, err := LoadBloomFilter(path, dbConnection.GetCheckpoint())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Not using the bloom filter: %s\n", err)
+		}
+		return dbConnection
+	}
+	fmt.Printf("Using bloom filter, %s\n", filter)
+	return NewFilteredStore(dbConnection, filter, "")
+}
+
+// generatorFilterCapacity is how many cubes the generator's filter should hold, every cube
+// up to the depth being generated, or twice the cubes saved so far if that's more or unknown
+func generatorFilterCapacity(count uint64, checkpoint Checkpoint, metric cube.Metric, encoding cube.Encoding) uint64 {
+	depth := len(strings.Split(checkpoint.EncodedStack, ","))
+	projected := 0.0
+	for d := 0; d <= depth; d++ {
+		cubes, known := expectedDepthCubes(metric, encoding, d)
+		if !known {
+			projected = 0
+			break
+		}
+		projected += cubes
+	}
+	if capacity := uint64(projected); capacity > 2*count {
+		return capacity
+	}
+	return 2 * count
+}
+
+// OpenGeneratorFilter puts a filter in front of the database for the generator, which
+// adds each saved cube to it and writes the sidecar when it stops. A missing, stale or full
+// sidecar is rebuilt from the cubes already in the database, sized for the depth being generated
+func OpenGeneratorFilter(dbConnection *DBConnection, falsePositiveRate float64) (SolutionStore, error) {
+	rebuild := func() (*BloomFilter, error) {
+		count, err := dbConnection.countCubes()
+		if err != nil {
+			return nil, err
+		}
+		capacity := generatorFilterCapacity(count, dbConnection.GetCheckpoint(), dbConnection.GetMetric(), dbConnection.GetEncoding())
+		return BuildBloomFilter(dbConnection, capacity, falsePositiveRate)
+	}
+	path := BloomFilterPath(dbConnection.Path())
+	filter, err := LoadBloomFilter(path, dbConnection.GetCheckpoint())
+	if err == nil && filter.Full() {
+		err = errors.New("the filter is full")
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Rebuilding the bloom filter: %s\n", err)
+		}
+		filter, err = rebuild()
+		if err != nil {
+			return nil, err
+		}
+	}
+	store := NewFilteredStore(dbConnection, filter, path)
+	store.rebuild = rebuild
+	return store, nil
+}
@@ -0,0 +1,146 @@
+package util
+
+import (
+	"errors"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func randomIds(r *rand.Rand, n int) []uint128.Uint128 {
+	ids := make([]uint128.Uint128, n)
+	for i := range ids {
+		ids[i] = uint128.Uint128{H: r.Uint64(), L: r.Uint64()}
+	}
+	return ids
+}
+
+func TestBloomFilter(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	filter := NewBloomFilter(10000, 0.01)
+	ids := randomIds(r, 10000)
+	for _, id := range ids {
+		filter.Add(id)
+	}
+	for _, id := range ids {
+		if !filter.MayContain(id) {
+			t.Fatalf("The filter is missing %v", id)
+		}
+	}
+	if rate := filter.MeasureFalsePositiveRate(100000); rate > 0.02 {
+		t.Errorf("The false positive rate is %f for a filter sized for 0.01", rate)
+	}
+	if rate := filter.ExpectedFalsePositiveRate(); rate < 0.005 || rate > 0.015 {
+		t.Errorf("The expected false positive rate is %f for a filter sized for 0.01", rate)
+	}
+}
+
+func TestBloomFilter_WriteAndLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	filter := NewBloomFilter(1000, 0.01)
+	ids := randomIds(r, 1000)
+	for _, id := range ids {
+		filter.Add(id)
+	}
+	path := filepath.Join(t.TempDir(), "cubes.db.bloom")
+	checkpoint := Checkpoint{NextNum: 12, EncodedStack: "0,3,1"}
+	if err := filter.Write(path, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBloomFilter(path, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if !loaded.MayContain(id) {
+			t.Fatalf("The loaded filter is missing %v", id)
+		}
+	}
+	if loaded.count != filter.count || loaded.hashes != filter.hashes {
+		t.Errorf("The loaded filter has different settings")
+	}
+
+	if _, err := LoadBloomFilter(path, Checkpoint{NextNum: 13, EncodedStack: "0,3,1"}); !errors.Is(err, ErrStaleFilter) {
+		t.Errorf("A filter from a different checkpoint should be stale but got %v", err)
+	}
+}
+
+func TestFilteredStore(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	memoryStore := NewMemoryStore()
+	store := NewFilteredStore(memoryStore, NewBloomFilter(1000, 0.01), "")
+
+	saved := randomIds(r, 1000)
+	results := make(map[uint128.Uint128]uint64)
+	for _, id := range saved {
+		results[id] = encodeTransform("FRu")
+	}
+	store.Save(results, initialCheckpoint)
+
+	unsaved := randomIds(r, 5000)
+	ids := append(append([]uint128.Uint128{}, saved...), unsaved...)
+	solutions, found := store.BatchLookup(ids)
+	for i, id := range ids {
+		solution, exists := memoryStore.Lookup(id)
+		if found[i] != exists || solutions[i] != solution {
+			t.Errorf("Filtered lookup of %v gave %s %t rather than %s %t", id, solutions[i], found[i], solution, exists)
+		}
+		if single, singleFound := store.Lookup(id); singleFound != exists || single != solution {
+			t.Errorf("Filtered lookup of %v gave %s %t rather than %s %t", id, single, singleFound, solution, exists)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Probes != uint64(2*len(ids)) {
+		t.Errorf("%d probes were counted rather than %d", stats.Probes, 2*len(ids))
+	}
+	if stats.Rejected+stats.FalsePositives != uint64(2*len(unsaved)) {
+		t.Errorf("Every unsaved cube should be rejected or a false positive, %s", stats)
+	}
+	if stats.FalsePositiveRate() > 0.02 {
+		t.Errorf("The false positive rate is too high, %s", stats)
+	}
+}
+
+func TestFilteredStore_RebuildsWhenFull(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	memoryStore := NewMemoryStore()
+	store := NewFilteredStore(memoryStore, NewBloomFilter(100, 0.01), "")
+	rebuilds := 0
+	store.rebuild = func() (*BloomFilter, error) {
+		rebuilds += 1
+		filter := NewBloomFilter(uint64(2*memoryStore.Count()), 0.01)
+		for id := range memoryStore.solutions {
+			filter.Add(id)
+		}
+		return filter, nil
+	}
+
+	saved := randomIds(r, 150)
+	results := make(map[uint128.Uint128]uint64)
+	for _, id := range saved {
+		results[id] = encodeTransform("FRu")
+	}
+	store.Save(results, initialCheckpoint)
+	if rebuilds != 1 || store.filter.Full() {
+		t.Errorf("A full filter should be rebuilt bigger, rebuilt %d times to %s", rebuilds, store.filter)
+	}
+	for _, id := range saved {
+		if _, found := store.Lookup(id); !found {
+			t.Fatalf("The rebuilt filter is missing %v", id)
+		}
+	}
+}
+
+func TestGeneratorFilterCapacity(t *testing.T) {
+	// QTM has 1 + 12 + 114 + 1068 positions up to depth 3, and most ids cover 24 of them
+	if capacity := generatorFilterCapacity(10, Checkpoint{EncodedStack: "0,0,0"}, cube.QuarterTurnMetric, cube.RotationEncoding); capacity < 40 || capacity > 60 {
+		t.Errorf("The filter should be sized for about 50 cubes up to depth 3, got %d", capacity)
+	}
+	if capacity := generatorFilterCapacity(1000, Checkpoint{EncodedStack: "0,0,0"}, cube.QuarterTurnMetric, cube.RotationEncoding); capacity != 2000 {
+		t.Errorf("A filter with more cubes than projected should double, got %d", capacity)
+	}
+}
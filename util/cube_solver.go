@@ -2,9 +2,14 @@ package util
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/davidminor/uint128"
 	"github.com/matthewjackswann/rubiks/cube"
+	"github.com/matthewjackswann/rubiks/notation"
+	"sort"
+	"sync"
+	"time"
 )
 
 func loadSolution(id uint128.Uint128, preparedStmt *sql.Stmt) (string, bool) {
@@ -30,136 +35,331 @@ func loadSolution(id uint128.Uint128, preparedStmt *sql.Stmt) (string, bool) {
 		return "", false
 	}
 
-	solution := ""
-	for i := 0; i < 16; i++ {
-		solutionPart := IntToTransform[encodedSolution&0xF]
-		if solutionPart == 0 {
-			break
-		}
-		solution += string(solutionPart) // Extract the 4 least significant bits
-		encodedSolution >>= 4
-	}
-
-	return solution, true
+	return decodeTransform(encodedSolution), true
 }
 
 // LookupCube is used to find the solution for a single cube if it exists in the database
 func (dbConnection *DBConnection) LookupCube(cubeId uint128.Uint128, rotation string) (string, bool) {
-	if cubeId.Equals(cube.SolvedCubeId) {
-		return "", true
+	return LookupCube(dbConnection, cubeId, rotation)
+}
+
+// searchBatchSize is how many cubes are sent to the lookup workers at once
+const searchBatchSize = 512
+
+// toCube gets the stickers of either representation
+func toCube(state cube.State) (*cube.Cube, bool) {
+	switch c := state.(type) {
+	case *cube.Cube:
+		return c, true
+	case *cube.CubieCube:
+		return c.ToCube(), true
 	}
-	stmt, err := dbConnection.db.Prepare("SELECT solution FROM cubes WHERE cube_id_l = ? AND cube_id_h = ?;")
-	if err != nil {
-		fmt.Printf("Error creating prepared statement %s\n", err)
+	return nil, false
+}
+
+// inverseOf gives the inverse of either representation, failing for layouts that aren't valid cubes
+func inverseOf(state cube.State) (*cube.Cube, bool) {
+	c, ok := toCube(state)
+	if !ok {
+		return nil, false
+	}
+	inverse, err := c.Inverse()
+	return inverse, err == nil
+}
+
+// inverseSolution turns a solution of the inverse of the state after transform into a solution
+// of the state, starting with transform
+func inverseSolution(state cube.State, transform, solution string) (string, bool) {
+	c, ok := toCube(state)
+	if !ok {
 		return "", false
 	}
-	defer func(stmt *sql.Stmt) {
-		err := stmt.Close()
-		if err != nil {
-			fmt.Println("Error closing statement")
-			fmt.Println(err)
+	c = cube.NewCube(c.Layout)
+	c.Transform(transform)
+	solution, err := c.InverseSolution(solution)
+	return transform + solution, err == nil
+}
+
+// probeCache looks each id up in the store once and remembers the result. The search reaches the
+// same cube by many transforms and a cube's inverse often has the same id as another probe, so
+// most lookups are answered by the cache. Repeats are almost all at the same depth, so the cache
+// is cleared after each one, and it stops remembering new ids once it holds probeCacheSize
+type probeCache struct {
+	SolutionStore
+	lock    sync.Mutex
+	looked  *sync.Cond // broadcast whenever lookups finish, for workers waiting on them
+	results map[uint128.Uint128]probeResult
+}
+
+const probeCacheSize = 1 << 20
+
+// probeResult is the lookup of an id, pending until the worker looking it up has the result
+type probeResult struct {
+	solution string
+	found    bool
+	pending  bool
+}
+
+func newProbeCache(store SolutionStore) *probeCache {
+	cache := &probeCache{SolutionStore: store, results: make(map[uint128.Uint128]probeResult)}
+	cache.looked = sync.NewCond(&cache.lock)
+	return cache
+}
+
+// clear forgets every id, it must only be called when no lookups are running
+func (store *probeCache) clear() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.results = make(map[uint128.Uint128]probeResult)
+}
+
+// BatchLookup looks up the ids no other batch has, then waits for the ones other workers are looking
+// up. Every worker finishes its own lookups before waiting, so they can't wait on each other
+func (store *probeCache) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	solutions := make([]string, len(cubeIds))
+	found := make([]bool, len(cubeIds))
+	var owned []uint128.Uint128
+	var ownedPositions []int
+	store.lock.Lock()
+	for i, cubeId := range cubeIds {
+		if _, cached := store.results[cubeId]; !cached {
+			if len(store.results) < probeCacheSize {
+				store.results[cubeId] = probeResult{pending: true}
+			}
+			owned = append(owned, cubeId)
+			ownedPositions = append(ownedPositions, i)
+		}
+	}
+	store.lock.Unlock()
+
+	if len(owned) > 0 {
+		ownedSolutions, ownedFound := store.SolutionStore.BatchLookup(owned)
+		store.lock.Lock()
+		for j, i := range ownedPositions {
+			solutions[i], found[i] = ownedSolutions[j], ownedFound[j]
+			if _, cached := store.results[owned[j]]; cached {
+				store.results[owned[j]] = probeResult{solution: solutions[i], found: found[i]}
+			}
 		}
-	}(stmt)
-	return dbConnection.lookupCube(cubeId, rotation, stmt)
+		store.looked.Broadcast()
+		store.lock.Unlock()
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	next := 0
+	for i, cubeId := range cubeIds {
+		if next < len(ownedPositions) && ownedPositions[next] == i {
+			next += 1
+			continue
+		}
+		result := store.results[cubeId]
+		for result.pending {
+			store.looked.Wait()
+			result = store.results[cubeId]
+		}
+		solutions[i], found[i] = result.solution, result.found
+	}
+	return solutions, found
+}
+
+// inverseMayHelp is whether looking up the inverses of cubes can find any the search wouldn't
+// otherwise. A cube and its inverse are the same distance from solved, so when the store has
+// finished every depth it has one is saved exactly when the other is
+func inverseMayHelp(store SolutionStore) bool {
+	checkpoint := store.GetCheckpoint()
+	// stores that weren't filled by a generator, or tables converted from them, don't know how far they got
+	return checkpoint == initialCheckpoint || completedFrontierDepth(checkpoint) < 0
+}
+
+// searchProbe is a cube the search looks up, the base cube after transform or its inverse
+type searchProbe struct {
+	transform string
+	inverse   bool
 }
 
-func (dbConnection *DBConnection) lookupCube(cubeId uint128.Uint128, rotation string, stmt *sql.Stmt) (string, bool) {
-	idSolution, success := loadSolution(cubeId, stmt)
-	if !success {
+// SolveCubeBySearch tries every transform of the generator graph from the cube, one depth
+// at a time, until one reaches a cube in the store. A solution of a cube's inverse gives one
+// of the cube, so when the store's last depth is only partly generated the inverse of each cube
+// is looked up too, finding solutions at shallower depths. Each depth is looked up in batches
+// and the shortest solution found at that depth is used
+func SolveCubeBySearch(store SolutionStore, baseCube cube.State, workers, maxDepth int) (string, bool) {
+	solutions, _ := searchCube(store, baseCube, workers, maxDepth, false, time.Time{})
+	if len(solutions) == 0 {
 		return "", false
 	}
-	return cube.RotateTransform(rotation, idSolution), true
+	return solutions[0], true
 }
 
-func (dbConnection *DBConnection) SolveCubeBySearch(baseCube *cube.Cube, workers, maxDepth int) (string, bool) {
-	solution, success := dbConnection.LookupCube(baseCube.EncodeCube())
-	// not in lookup table, start brute forcing from cube direction
-	if success {
-		return solution, true
+// SolveCubeBySearchAll searches like SolveCubeBySearch but finishes the depth the first solution is
+// found at, giving every distinct shortest solution found there. Each is a transform of the search
+// joined to the stored solution of the cube it reaches, so solutions the store doesn't lead to
+// aren't found. Solutions that only differ in the order of moves that commute are the same, and
+// they're given easiest to perform first. At most limit are returned if it's above 0, along with
+// how many there were. It gives up with ErrSearchTimeout if the search takes longer than timeout
+func SolveCubeBySearchAll(store SolutionStore, baseCube cube.State, workers, maxDepth, limit int, timeout time.Duration) ([]string, int, error) {
+	type candidate struct {
+		transform string
+		cost      int
+	}
+	var candidates []candidate
+	seen := make(map[string]bool)
+	found, err := searchCube(store, baseCube, workers, maxDepth, true, time.Now().Add(timeout))
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, solution := range found {
+		moves, err := notation.FromTransform(solution)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		moves = notation.Normalise(moves)
+		transform := notation.ToTransform(moves)
+		if seen[transform] {
+			continue
+		}
+		seen[transform] = true
+		candidates = append(candidates, candidate{transform: transform, cost: notation.ErgonomicCost(moves)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].cost != candidates[j].cost {
+			return candidates[i].cost < candidates[j].cost
+		}
+		return candidates[i].transform < candidates[j].transform
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
 	}
+	solutions := make([]string, len(candidates))
+	for i, c := range candidates {
+		solutions[i] = c.transform
+	}
+	return solutions, len(seen), nil
+}
 
-	parallelLookup := CreateLookupWorkers(32, workers, dbConnection.path)
-	cubeTransformChan := make(chan string, 32)
-	cubeTransformWorkerStop := make(chan interface{}, workers)
+// ErrSearchTimeout is returned when searching for every solution doesn't finish before its deadline
+var ErrSearchTimeout = errors.New("search timed out")
 
-	for i := 0; i < workers; i++ {
-		go func() {
-			for {
-				transform := <-cubeTransformChan
-				if transform == "" {
-					cubeTransformWorkerStop <- nil
-					return
-				}
-				c := cube.NewCube(baseCube.Layout)
-				c.Transform(transform)
-				parallelLookup.requestChan <- &lookupWorkerRequest{
-					cube: c,
-					data: transform,
-				}
+// searchCube gives the shortest solutions found at the first depth with any, without rotations.
+// Unless all is set it stops sending cubes at that depth as soon as one is found. It stops with
+// ErrSearchTimeout once the deadline has passed, unless the deadline is zero
+func searchCube(store SolutionStore, baseCube cube.State, workers, maxDepth int, all bool, deadline time.Time) ([]string, error) {
+	metric := store.GetMetric()
+	var best []string
+	bestLength := -1
+	consider := func(solution string) {
+		solution = cube.RemoveRotationTransforms(solution)
+		length := metric.Length(solution)
+		if bestLength == -1 || length < bestLength {
+			best, bestLength = nil, length
+		}
+		if length == bestLength {
+			best = append(best, solution)
+		}
+	}
+
+	encoding := store.GetEncoding()
+	id, rotation := encoding.Encode(baseCube)
+	if solution, success := LookupCube(store, id, rotation); success {
+		consider(solution)
+	}
+	baseInverse, invertible := inverseOf(baseCube)
+	invertible = invertible && inverseMayHelp(store)
+	if invertible {
+		id, rotation := encoding.Encode(baseInverse)
+		if solution, success := LookupCube(store, id, rotation); success {
+			if solution, ok := inverseSolution(baseCube, "", solution); ok {
+				consider(solution)
 			}
-		}()
+		}
+	}
+	if bestLength != -1 {
+		return best, nil
 	}
+	// not in lookup table, start brute forcing from cube direction
 
 	baseRotations := baseCube.GetNonSymmetricalRotations()
 
 	var generator cube.Generator
 	if len(baseRotations) < 6 {
-		generator = cube.CreateNewGenerator([]int{0}, 0, cube.ID_TRANSFORM_GRAPH)
+		generator = cube.CreateNewGenerator([]int{0}, 0, metric.IdTransformGraph())
 	} else {
-		generator = cube.CreateNewGenerator([]int{0}, 0, cube.TRANSFORM_GRAPH)
+		generator = cube.CreateNewGenerator([]int{0}, 0, metric.TransformGraph())
 		baseRotations = []string{""} // no need to consider any other rotations. Just use the identity
 	}
 
-	resultsFound := false
-	var lookupResult *lookupWorkerResponse
+	cache := newProbeCache(store)
+	lookup := CreateBatchLookupWorkers(workers, workers, cache)
+	defer lookup.Stop()
 
-	transformsInBuffer := 0
-	currentDepth := 0
+	pending := 0
+	receive := func(responses []*lookupWorkerResponse) {
+		pending -= 1
+		for _, response := range responses {
+			if !response.success {
+				continue
+			}
+			probe := response.data.(searchProbe)
+			solution := probe.transform + response.solution
+			if probe.inverse {
+				var ok bool
+				if solution, ok = inverseSolution(baseCube, probe.transform, response.solution); !ok {
+					continue
+				}
+			}
+			consider(solution)
+		}
+	}
 
-	for {
-		// send cubes
-		if generator.GetCurrentDepth() != currentDepth && transformsInBuffer == 0 {
-			currentDepth = generator.GetCurrentDepth()
-			// if max depth reached
-			if currentDepth == maxDepth+1 {
-				for i := 0; i < workers; i++ {
-					cubeTransformChan <- ""
+	probesPerTransform := len(baseRotations)
+	if invertible {
+		probesPerTransform *= 2
+	}
+	for depth := generator.GetCurrentDepth(); depth <= maxDepth && bestLength == -1; depth = generator.GetCurrentDepth() {
+		for generator.GetCurrentDepth() == depth && (all || bestLength == -1) {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				for pending > 0 {
+					receive(<-lookup.resultsChan)
 				}
-				for i := 0; i < workers; i++ {
-					<-cubeTransformWorkerStop
+				return nil, ErrSearchTimeout
+			}
+			batch := make([]*lookupWorkerRequest, 0, searchBatchSize+probesPerTransform)
+			for len(batch) < searchBatchSize && generator.GetCurrentDepth() == depth {
+				baseTransform := generator.Next()
+				for _, baseRotation := range baseRotations {
+					transform := baseRotation + baseTransform
+					batch = append(batch, &lookupWorkerRequest{
+						cube:      baseCube,
+						transform: transform,
+						data:      searchProbe{transform: transform},
+					})
+					if invertible {
+						batch = append(batch, &lookupWorkerRequest{
+							cube:      baseCube,
+							transform: transform,
+							inverse:   true,
+							data:      searchProbe{transform: transform, inverse: true},
+						})
+					}
 				}
-				parallelLookup.StopForcefully()
-				return "", false
 			}
-		}
-		if generator.GetCurrentDepth() == currentDepth && transformsInBuffer < 32-len(baseRotations) {
-			baseTransform := generator.Next()
-			for _, baseRotation := range baseRotations {
-				cubeTransformChan <- baseRotation + baseTransform
-				transformsInBuffer += 1
+			// keep receiving while sending so the workers never block on a full results channel
+			for sent := false; !sent; {
+				select {
+				case lookup.requestChan <- batch:
+					sent = true
+					pending += 1
+				case responses := <-lookup.resultsChan:
+					receive(responses)
+				}
 			}
 		}
-
-		// receive results, if found stop all
-		select {
-		case lookupResult = <-parallelLookup.resultsChan:
-			resultsFound = lookupResult.success
-			transformsInBuffer -= 1
-		default:
-
+		// finish the depth so the shortest solution at it is found
+		for pending > 0 {
+			receive(<-lookup.resultsChan)
 		}
-
-		if resultsFound {
-			break
-		}
-	}
-
-	for i := 0; i < workers; i++ {
-		cubeTransformChan <- ""
-	}
-	for i := 0; i < workers; i++ {
-		<-cubeTransformWorkerStop
+		cache.clear()
 	}
-	parallelLookup.StopForcefully()
-	return cube.RemoveRotationTransforms(lookupResult.data.(string) + lookupResult.solution), true
+	return best, nil
 }
@@ -0,0 +1,230 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/matthewjackswann/rubiks/cube"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DatabaseInfo describes what a sqlite database or table file of solutions holds
+type DatabaseInfo struct {
+	Path           string `json:"path"`
+	Format         string `json:"format"` // sqlite or table
+	Metric         string `json:"metric"`
+	Encoding       string `json:"encoding"`
+	FileBytes      int64  `json:"file_bytes"`
+	Rows           int    `json:"rows"`
+	NextTransform  int    `json:"next_transform,omitempty"`
+	Stack          string `json:"stack,omitempty"`
+	CompletedDepth int    `json:"completed_depth"` // every cube up to this depth is saved, -1 if it's not known
+	// SolutionLengths counts the solutions of each length in the database's metric
+	SolutionLengths map[int]int      `json:"solution_lengths"`
+	Checks          []IntegrityCheck `json:"checks"`
+}
+
+type IntegrityCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Healthy is whether every integrity check passed
+func (info DatabaseInfo) Healthy() bool {
+	for _, check := range info.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (info *DatabaseInfo) check(name string, passed bool, detail string) {
+	info.Checks = append(info.Checks, IntegrityCheck{Name: name, Passed: passed, Detail: detail})
+}
+
+func (info DatabaseInfo) String() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Database: %s (%s, %s)\n", info.Path, info.Format, humanBytes(info.FileBytes))
+	fmt.Fprintf(&b, "Metric: %s\n", info.Metric)
+	fmt.Fprintf(&b, "Encoding: %s\n", info.Encoding)
+	fmt.Fprintf(&b, "Rows: %d\n", info.Rows)
+	if info.Format == "sqlite" {
+		fmt.Fprintf(&b, "Checkpoint: transform %d, stack %s\n", info.NextTransform, info.Stack)
+	}
+	if info.CompletedDepth >= 0 {
+		fmt.Fprintf(&b, "Completed depth: %d\n", info.CompletedDepth)
+	} else {
+		b.WriteString("Completed depth: unknown\n")
+	}
+	b.WriteString("Solution lengths:\n")
+	lengths := make([]int, 0, len(info.SolutionLengths))
+	for length := range info.SolutionLengths {
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+	for _, length := range lengths {
+		fmt.Fprintf(&b, "  %2d: %d\n", length, info.SolutionLengths[length])
+	}
+	b.WriteString("Checks:\n")
+	for _, check := range info.Checks {
+		status := "ok  "
+		if !check.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "  %s %s", status, check.Name)
+		if check.Detail != "" {
+			fmt.Fprintf(&b, ": %s", check.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// InspectDatabase reads every solution in the table file or sqlite database at path
+func InspectDatabase(path string) (DatabaseInfo, error) {
+	info := DatabaseInfo{Path: path, CompletedDepth: -1, SolutionLengths: make(map[int]int)}
+	for _, file := range []string{path, path + "-wal"} {
+		if stat, err := os.Stat(file); err == nil {
+			info.FileBytes += stat.Size()
+		} else if file == path {
+			return info, err
+		}
+	}
+
+	table, err := OpenTableFile(path)
+	if err == nil {
+		defer table.Close()
+		inspectTableFile(table, &info)
+		return info, nil
+	}
+	if !errors.Is(err, ErrNotTableFile) {
+		return info, err
+	}
+	db := CreateDBConnection(path)
+	defer db.Close()
+	return info, inspectSqlite(db, &info)
+}
+
+// solutionChecker counts solution lengths and looks for solutions that don't decode
+type solutionChecker struct {
+	metric    cube.Metric
+	lengths   map[int]int
+	invalid   int
+	maxLength int
+}
+
+func (c *solutionChecker) add(encoded uint64) {
+	solution := decodeTransform(encoded)
+	if reencoded, ok := tryEncodeTransform(solution); !ok || reencoded != encoded {
+		c.invalid += 1
+		return
+	}
+	length := c.metric.Length(solution)
+	c.lengths[length] += 1
+	if length > c.maxLength {
+		c.maxLength = length
+	}
+}
+
+func (c *solutionChecker) report(info *DatabaseInfo, completedDepth int) {
+	info.check("solutions decode", c.invalid == 0, countDetail(c.invalid, "solution doesn't", "solutions don't", "decode to moves"))
+	if completedDepth >= 0 {
+		// the depth after the completed one may be part way through
+		info.check("solutions within the generated depth", c.maxLength <= completedDepth+1,
+			fmt.Sprintf("the longest solution is %d moves", c.maxLength))
+	}
+}
+
+func countDetail(n int, singular, plural, rest string) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("1 %s %s", singular, rest)
+	}
+	return fmt.Sprintf("%d %s %s", n, plural, rest)
+}
+
+func inspectSqlite(db *DBConnection, info *DatabaseInfo) error {
+	info.Format = "sqlite"
+	metric := db.GetMetric()
+	info.Metric = metric.String()
+	info.Encoding = db.GetEncoding().String()
+	checkpoint := db.GetCheckpoint()
+	info.NextTransform, info.Stack = checkpoint.NextNum, checkpoint.EncodedStack
+
+	var quickCheck string
+	if err := db.db.QueryRow("PRAGMA quick_check;").Scan(&quickCheck); err != nil {
+		return err
+	}
+	info.check("sqlite quick_check", quickCheck == "ok", strings.TrimPrefix(quickCheck, "ok"))
+
+	stackValid := true
+	for _, s := range strings.Split(checkpoint.EncodedStack, ",") {
+		if n, err := strconv.Atoi(s); err != nil || n < 0 {
+			stackValid = false
+		}
+	}
+	info.check("checkpoint stack", stackValid, "")
+	if stackValid {
+		info.CompletedDepth = len(strings.Split(checkpoint.EncodedStack, ",")) - 1
+	}
+
+	rows, err := db.db.Query("SELECT solution FROM cubes;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	checker := &solutionChecker{metric: metric, lengths: info.SolutionLengths}
+	for rows.Next() {
+		var solution int64
+		if err := rows.Scan(&solution); err != nil {
+			return err
+		}
+		info.Rows += 1
+		checker.add(uint64(solution))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	checker.report(info, info.CompletedDepth)
+
+	if _, err := os.Stat(BloomFilterPath(db.Path())); err == nil {
+		_, err := LoadBloomFilter(BloomFilterPath(db.Path()), checkpoint)
+		detail := ""
+		if err != nil {
+			detail = err.Error()
+		}
+		info.check("bloom filter up to date", err == nil, detail)
+	}
+	return nil
+}
+
+func inspectTableFile(table *TableFile, info *DatabaseInfo) {
+	info.Format = "table"
+	info.Metric = table.GetMetric().String()
+	info.Encoding = table.GetEncoding().String()
+	info.Rows = table.Count()
+	if checkpoint := table.GetCheckpoint(); checkpoint != initialCheckpoint {
+		info.NextTransform, info.Stack = checkpoint.NextNum, checkpoint.EncodedStack
+		info.CompletedDepth = len(strings.Split(checkpoint.EncodedStack, ",")) - 1
+	}
+
+	checker := &solutionChecker{metric: table.GetMetric(), lengths: info.SolutionLengths}
+	unsorted := 0
+	var lastL, lastH int64
+	for i := 0; i < table.Count(); i++ {
+		l, h, solution := table.entry(i)
+		if i > 0 && (l < lastL || (l == lastL && h <= lastH)) {
+			unsorted += 1
+		}
+		lastL, lastH = l, h
+		checker.add(solution)
+	}
+	info.check("entries sorted by id", unsorted == 0, countDetail(unsorted, "entry is", "entries are", "out of order or repeated"))
+	checker.report(info, info.CompletedDepth)
+}
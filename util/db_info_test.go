@@ -0,0 +1,61 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cubes.db")
+	db := CreateDBConnection(dbPath)
+	db.SetMetric(cube.QuarterTurnMetric)
+	StartSolutionGenerator(db, []int{0}, 0, GeneratorConfig{MaximumDepth: 3, Metric: cube.QuarterTurnMetric, Backend: cube.StickerBackend,
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")})
+
+	info, err := InspectDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLengths := map[int]int{1: 2, 2: 8, 3: 50}
+	if info.Format != "sqlite" || info.Metric != "qtm" || info.Rows != 60 || info.CompletedDepth != 3 || info.Stack != "0,0,0,0" {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	for length, count := range expectedLengths {
+		if info.SolutionLengths[length] != count {
+			t.Errorf("Expected %d solutions of length %d but there were %d", count, length, info.SolutionLengths[length])
+		}
+	}
+	if !info.Healthy() {
+		t.Errorf("The database should be healthy, %s", info)
+	}
+
+	tablePath := filepath.Join(dir, "cubes.table")
+	db = CreateDBConnection(dbPath)
+	if err := ConvertToTableFile(db, tablePath); err != nil {
+		t.Fatal(err)
+	}
+	// a solution with a nibble that isn't a move
+	if _, err := db.db.Exec("INSERT INTO cubes (cube_id_l, cube_id_h, solution) VALUES (1, 2, 15);"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	info, err = InspectDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Healthy() || info.Rows != 61 {
+		t.Errorf("The database has a solution that doesn't decode, %s", info)
+	}
+
+	info, err = InspectDatabase(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "table" || info.Rows != 60 || info.SolutionLengths[3] != 50 || info.CompletedDepth != 3 || !info.Healthy() {
+		t.Errorf("Unexpected table file info %s", info)
+	}
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"os"
+)
+
+const migrateSaveBatchSize = 10000
+
+// MigrateEncoding writes every cube in the database to a new database at path with its ids in
+// encoding, returning the number of ids written. Each solution is moved to its cube's id in the
+// new encoding, and when the old ids covered reflections the reflected solution is added for the
+// mirror image too. The checkpoint is kept so the generator can carry on with the new database.
+// The cubes are written to a temporary file that's only moved to path once they're all copied,
+// so a migration that fails or is interrupted can just be run again
+func MigrateEncoding(dbConnection *DBConnection, path string, encoding cube.Encoding) (int, error) {
+	if dbConnection.GetEncoding() == encoding {
+		return 0, fmt.Errorf("the database is already encoded with %s", encoding)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return 0, fmt.Errorf("%s already exists", path)
+	}
+	tempPath := path + ".migrating"
+	// left behind if an earlier migration was killed
+	removeDatabase(tempPath)
+	count, err := migrateEncodingTo(dbConnection, tempPath, encoding)
+	if err != nil {
+		removeDatabase(tempPath)
+		return 0, err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		removeDatabase(tempPath)
+		return 0, err
+	}
+	return count, nil
+}
+
+// removeDatabase deletes a sqlite database along with its write ahead log
+func removeDatabase(path string) {
+	for _, file := range []string{path, path + "-wal", path + "-shm"} {
+		os.Remove(file)
+	}
+}
+
+func migrateEncodingTo(dbConnection *DBConnection, path string, encoding cube.Encoding) (int, error) {
+	from := dbConnection.GetEncoding()
+	rows, err := dbConnection.db.Query("SELECT cube_id_l, cube_id_h, solution FROM cubes;")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	out := CreateDBConnection(path)
+	defer out.Close()
+	if !out.SetMetric(dbConnection.GetMetric()) || !out.SetEncoding(encoding) {
+		return 0, errors.New("couldn't save the settings of the new database")
+	}
+
+	// ids already saved in an earlier batch are ignored by the database, keeping the first solution
+	batch := make(map[uint128.Uint128]uint64, migrateSaveBatchSize)
+	add := func(c *cube.Cube, solution string) {
+		id, rotation := encoding.Encode(c)
+		if _, seen := batch[id]; !seen {
+			batch[id] = encodeTransform(cube.RotateTransform(cube.ReverseTransform(rotation), solution))
+		}
+	}
+	for rows.Next() {
+		var l, h, encodedSolution int64
+		if err := rows.Scan(&l, &h, &encodedSolution); err != nil {
+			return 0, err
+		}
+		c := cube.DecodeCube(uint128.Uint128{H: uint64(h), L: uint64(l)})
+		solution := decodeTransform(uint64(encodedSolution))
+		add(c, solution)
+		if from == cube.ReflectionEncoding {
+			add(c.Reflect(), cube.RotateTransform(string(cube.Reflection), solution))
+		}
+		if len(batch) >= migrateSaveBatchSize {
+			if !out.Save(batch, initialCheckpoint) {
+				return 0, errors.New("couldn't save cubes to the new database")
+			}
+			batch = make(map[uint128.Uint128]uint64, migrateSaveBatchSize)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if !out.Save(batch, dbConnection.GetCheckpoint()) {
+		return 0, errors.New("couldn't save cubes to the new database")
+	}
+
+	var count int
+	if err := out.db.QueryRow("SELECT COUNT(*) FROM cubes;").Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateEncoding(t *testing.T) {
+	dir := t.TempDir()
+	rotationsPath := filepath.Join(dir, "rotations.db")
+	db := CreateDBConnection(rotationsPath)
+	db.SetMetric(cube.QuarterTurnMetric)
+	StartSolutionGenerator(db, []int{0}, 0, GeneratorConfig{MaximumDepth: 4, Metric: cube.QuarterTurnMetric, Backend: cube.StickerBackend,
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")})
+
+	db = CreateDBConnection(rotationsPath)
+	reflectionsPath := filepath.Join(dir, "reflections.db")
+	// an interrupted migration leaves its temporary file, which shouldn't stop it being run again
+	if err := os.WriteFile(reflectionsPath+".migrating", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reflectionIds, err := MigrateEncoding(db, reflectionsPath, cube.ReflectionEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(reflectionsPath + ".migrating"); !os.IsNotExist(err) {
+		t.Errorf("The temporary file should be moved to the new database")
+	}
+	if _, err := MigrateEncoding(db, filepath.Join(dir, "same.db"), cube.RotationEncoding); err == nil {
+		t.Errorf("The database is already encoded with rotations")
+	}
+	checkpoint := db.GetCheckpoint()
+	var originalIds int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM cubes;").Scan(&originalIds); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// most ids are shared with the mirror image, apart from the few cubes that are their own
+	if reflectionIds <= originalIds/2 || reflectionIds >= originalIds {
+		t.Errorf("Expected about half of the %d cubes to have an id with reflections but there were %d", originalIds, reflectionIds)
+	}
+	reflections := CreateDBConnection(reflectionsPath)
+	if reflections.GetEncoding() != cube.ReflectionEncoding || reflections.GetCheckpoint() != checkpoint {
+		t.Errorf("The migrated database should use reflections and keep the checkpoint %v", checkpoint)
+	}
+	generated := NewMemoryStore()
+	generated.SetEncoding(cube.ReflectionEncoding)
+	generateWithWorkers(generated, 4, 2)
+	if generated.Count() != reflectionIds {
+		t.Errorf("Generating with reflections found %d cubes but migrating gave %d", generated.Count(), reflectionIds)
+	}
+
+	r := rand.New(rand.NewSource(11))
+	for i := 0; i < 100; i++ {
+		scramble := randomScramble(r, r.Intn(5))
+		c := cube.NewSolvedCube()
+		c.Transform(scramble)
+		for _, store := range []SolutionStore{reflections, generated} {
+			id, rotation := store.GetEncoding().Encode(c)
+			solution, found := LookupCube(store, id, rotation)
+			if !found || len(solution) > len(scramble) {
+				t.Fatalf("%s should be solved in at most %d moves but got %q", scramble, len(scramble), solution)
+			}
+			d := cube.NewCube(c.Layout)
+			d.Transform(solution)
+			if !d.IsSolved() {
+				t.Fatalf("%s doesn't solve %s", solution, scramble)
+			}
+		}
+	}
+
+	back := filepath.Join(dir, "back.db")
+	rotationIds, err := MigrateEncoding(reflections, back, cube.RotationEncoding)
+	reflections.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotationIds != originalIds {
+		t.Errorf("Migrating back should give the %d cubes rather than %d", originalIds, rotationIds)
+	}
+	for _, path := range []string{reflectionsPath, back} {
+		result, err := VerifyDatabase(path, VerifyConfig{Workers: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Mismatches) != 0 {
+			t.Errorf("The migrated database %s has wrong rows, %s", path, result)
+		}
+	}
+}
+
+func TestSolveCubeBySearch_Reflections(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetEncoding(cube.ReflectionEncoding)
+	generateWithWorkers(store, 3, 1)
+	r := rand.New(rand.NewSource(12))
+	for i := 0; i < 20; i++ {
+		scramble := randomScramble(r, 5)
+		c := cube.NewSolvedCube()
+		c.Transform(scramble)
+		solution, found := SolveCubeBySearch(store, c, 2, 2)
+		if !found {
+			t.Errorf("Cube with setup %s should be solved within two moves of the store", scramble)
+			continue
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve setup %s", solution, scramble)
+		}
+	}
+}
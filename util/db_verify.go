@@ -0,0 +1,257 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"strings"
+	"sync"
+)
+
+// VerifyFix is what db verify does with rows whose solution doesn't solve their cube
+type VerifyFix int
+
+const (
+	// VerifyReport only reports the rows
+	VerifyReport VerifyFix = iota
+	// VerifyDelete removes the rows, so the cubes are solved by searching instead
+	VerifyDelete
+	// VerifyRepair replaces the solution with one found through a neighbouring cube in the table,
+	// rows that can't be repaired are left as they are
+	VerifyRepair
+)
+
+func ParseVerifyFix(s string) (VerifyFix, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return VerifyReport, nil
+	case "delete":
+		return VerifyDelete, nil
+	case "repair":
+		return VerifyRepair, nil
+	}
+	return VerifyReport, fmt.Errorf("unknown fix %q, expected none, delete or repair", s)
+}
+
+// VerifyConfig holds the settings for verifying a database
+type VerifyConfig struct {
+	Sample  int // rows to check, picked at random, 0 checks every row
+	Workers int // number of goroutines checking rows, at least one is used
+	Fix     VerifyFix
+}
+
+// VerifyMismatch is a row whose solution is wrong
+type VerifyMismatch struct {
+	Id       uint128.Uint128 `json:"id"`
+	Solution string          `json:"solution"`
+	Reason   string          `json:"reason"`
+	Repaired string          `json:"repaired,omitempty"` // the new solution if it was repaired
+}
+
+type VerifyResult struct {
+	Checked    int              `json:"checked"`
+	Mismatches []VerifyMismatch `json:"mismatches"`
+	Deleted    int              `json:"deleted"`
+	Repaired   int              `json:"repaired"`
+}
+
+func (result VerifyResult) String() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Checked %d rows, %d wrong\n", result.Checked, len(result.Mismatches))
+	for _, mismatch := range result.Mismatches {
+		fmt.Fprintf(&b, "  %d,%d %q: %s", int64(mismatch.Id.L), int64(mismatch.Id.H), mismatch.Solution, mismatch.Reason)
+		if mismatch.Repaired != "" {
+			fmt.Fprintf(&b, ", repaired with %s", mismatch.Repaired)
+		}
+		b.WriteString("\n")
+	}
+	if result.Deleted > 0 {
+		fmt.Fprintf(&b, "Deleted %d rows\n", result.Deleted)
+	}
+	if result.Repaired > 0 {
+		fmt.Fprintf(&b, "Repaired %d rows\n", result.Repaired)
+	}
+	return b.String()
+}
+
+var ErrReadOnlyFix = errors.New("table files are read only, fix the sqlite database and convert it again")
+
+type verifyRow struct {
+	id       uint128.Uint128
+	solution uint64
+}
+
+// checkRow replays the row's solution on the cube rebuilt from its id, returning why it's wrong or ""
+func checkRow(row verifyRow, encoding cube.Encoding) string {
+	solution := decodeTransform(row.solution)
+	if encoded, ok := tryEncodeTransform(solution); !ok || encoded != row.solution {
+		return "the solution doesn't decode to moves"
+	}
+	c := cube.DecodeCube(row.id)
+	if id, rotation := encoding.Encode(c); !id.Equals(row.id) || rotation != "" {
+		return "the id isn't a cube's id"
+	}
+	c.Transform(solution)
+	if !c.IsSolved() {
+		return "the solution doesn't solve the cube"
+	}
+	return ""
+}
+
+// VerifyDatabase checks the solution of each row, or a sample of them, solves its cube
+func VerifyDatabase(path string, config VerifyConfig) (VerifyResult, error) {
+	table, err := OpenTableFile(path)
+	if err == nil {
+		defer table.Close()
+		if config.Fix != VerifyReport {
+			return VerifyResult{}, ErrReadOnlyFix
+		}
+		return verifyRows(config, table.GetEncoding(), func(rows chan<- verifyRow) error {
+			for _, i := range sampleIndexes(table.Count(), config.Sample) {
+				l, h, solution := table.entry(i)
+				rows <- verifyRow{id: uint128.Uint128{H: uint64(h), L: uint64(l)}, solution: solution}
+			}
+			return nil
+		})
+	}
+	if !errors.Is(err, ErrNotTableFile) {
+		return VerifyResult{}, err
+	}
+
+	db := CreateDBConnection(path)
+	defer db.Close()
+	result, err := verifyRows(config, db.GetEncoding(), func(rows chan<- verifyRow) error {
+		query, args := "SELECT cube_id_l, cube_id_h, solution FROM cubes;", []interface{}{}
+		if config.Sample > 0 {
+			query, args = "SELECT cube_id_l, cube_id_h, solution FROM cubes ORDER BY RANDOM() LIMIT ?;", []interface{}{config.Sample}
+		}
+		results, err := db.db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer results.Close()
+		for results.Next() {
+			var l, h, solution int64
+			if err := results.Scan(&l, &h, &solution); err != nil {
+				return err
+			}
+			rows <- verifyRow{id: uint128.Uint128{H: uint64(h), L: uint64(l)}, solution: uint64(solution)}
+		}
+		return results.Err()
+	})
+	if err != nil {
+		return result, err
+	}
+	// the rows are only changed once they've all been read
+	for i := range result.Mismatches {
+		mismatch := &result.Mismatches[i]
+		switch config.Fix {
+		case VerifyDelete:
+			if _, err := db.db.Exec("DELETE FROM cubes WHERE cube_id_l = ? AND cube_id_h = ?;", int64(mismatch.Id.L), int64(mismatch.Id.H)); err != nil {
+				return result, err
+			}
+			result.Deleted += 1
+		case VerifyRepair:
+			repaired, found := repairSolution(db, mismatch.Id)
+			if !found {
+				continue
+			}
+			if _, err := db.db.Exec("UPDATE cubes SET solution = ? WHERE cube_id_l = ? AND cube_id_h = ?;",
+				int64(encodeTransform(repaired)), int64(mismatch.Id.L), int64(mismatch.Id.H)); err != nil {
+				return result, err
+			}
+			mismatch.Repaired = repaired
+			result.Repaired += 1
+		}
+	}
+	return result, nil
+}
+
+// verifyRows checks the rows sent by read on as many goroutines as config asks for
+func verifyRows(config VerifyConfig, encoding cube.Encoding, read func(rows chan<- verifyRow) error) (VerifyResult, error) {
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	rows := make(chan verifyRow, channelBufferSize)
+	mismatches := make(chan VerifyMismatch, channelBufferSize)
+	checked := make([]int, workers)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for row := range rows {
+				checked[w] += 1
+				if reason := checkRow(row, encoding); reason != "" {
+					mismatches <- VerifyMismatch{Id: row.id, Solution: decodeTransform(row.solution), Reason: reason}
+				}
+			}
+		}(w)
+	}
+
+	result := VerifyResult{Mismatches: []VerifyMismatch{}}
+	collected := make(chan struct{})
+	go func() {
+		for mismatch := range mismatches {
+			result.Mismatches = append(result.Mismatches, mismatch)
+		}
+		close(collected)
+	}()
+
+	err := read(rows)
+	close(rows)
+	wg.Wait()
+	close(mismatches)
+	<-collected
+	for _, n := range checked {
+		result.Checked += n
+	}
+	return result, err
+}
+
+// sampleIndexes are the indexes of sample rows spread evenly through count rows, or all of them
+func sampleIndexes(count, sample int) []int {
+	if sample <= 0 || sample > count {
+		sample = count
+	}
+	indexes := make([]int, sample)
+	for i := range indexes {
+		indexes[i] = int(int64(i) * int64(count) / int64(sample))
+	}
+	return indexes
+}
+
+// repairSolution finds a solution for the cube with id through the neighbour with the
+// shortest solution that works. A cube at depth d always has a neighbour at depth d-1
+func repairSolution(store SolutionStore, id uint128.Uint128) (string, bool) {
+	metric, encoding := store.GetMetric(), store.GetEncoding()
+	c := cube.DecodeCube(id)
+	best, found := "", false
+	for _, move := range metricMoves(metric) {
+		neighbour := cube.NewCube(c.Layout)
+		neighbour.Transform(move)
+		neighbourId, rotation := encoding.Encode(neighbour)
+		if neighbourId.Equals(id) {
+			continue
+		}
+		solution, exists := LookupCube(store, neighbourId, rotation)
+		if !exists {
+			continue
+		}
+		candidate := move + solution
+		if _, ok := tryEncodeTransform(candidate); !ok {
+			continue
+		}
+		check := cube.NewCube(c.Layout)
+		check.Transform(candidate)
+		if !check.IsSolved() {
+			continue
+		}
+		if !found || metric.Length(candidate) < metric.Length(best) {
+			best, found = candidate, true
+		}
+	}
+	return best, found
+}
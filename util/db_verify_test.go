@@ -0,0 +1,117 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "cubes.db")
+	db := CreateDBConnection(dbPath)
+	db.SetMetric(cube.QuarterTurnMetric)
+	StartSolutionGenerator(db, []int{0}, 0, GeneratorConfig{MaximumDepth: 3, Metric: cube.QuarterTurnMetric, Backend: cube.StickerBackend,
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")})
+
+	result, err := VerifyDatabase(dbPath, VerifyConfig{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 60 || len(result.Mismatches) != 0 {
+		t.Fatalf("Expected 60 correct rows, %s", result)
+	}
+	result, err = VerifyDatabase(dbPath, VerifyConfig{Sample: 10, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 10 || len(result.Mismatches) != 0 {
+		t.Fatalf("Expected 10 correct rows, %s", result)
+	}
+
+	tablePath := filepath.Join(dir, "cubes.table")
+	db = CreateDBConnection(dbPath)
+	if err := ConvertToTableFile(db, tablePath); err != nil {
+		t.Fatal(err)
+	}
+	// swap the solutions of a depth 2 and a depth 3 cube
+	c := cube.NewSolvedCube()
+	c.Transform("FU")
+	depth2, _ := c.EncodeCube()
+	c.Transform("R")
+	depth3, _ := c.EncodeCube()
+	var solution2, solution3 int64
+	for _, row := range []struct {
+		l, h     uint64
+		solution *int64
+	}{{depth2.L, depth2.H, &solution2}, {depth3.L, depth3.H, &solution3}} {
+		if err := db.db.QueryRow("SELECT solution FROM cubes WHERE cube_id_l = ? AND cube_id_h = ?;", int64(row.l), int64(row.h)).Scan(row.solution); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, row := range []struct {
+		l, h     uint64
+		solution int64
+	}{{depth2.L, depth2.H, solution3}, {depth3.L, depth3.H, solution2}} {
+		if _, err := db.db.Exec("UPDATE cubes SET solution = ? WHERE cube_id_l = ? AND cube_id_h = ?;", row.solution, int64(row.l), int64(row.h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a solution with a nibble that isn't a move
+	if _, err := db.db.Exec("INSERT INTO cubes (cube_id_l, cube_id_h, solution) VALUES (1, 2, 15);"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	result, err = VerifyDatabase(dbPath, VerifyConfig{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 61 || len(result.Mismatches) != 3 {
+		t.Fatalf("Expected 3 wrong rows, %s", result)
+	}
+
+	result, err = VerifyDatabase(dbPath, VerifyConfig{Workers: 2, Fix: VerifyRepair})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the row that isn't a cube has no neighbours to be repaired from
+	if len(result.Mismatches) != 3 || result.Repaired != 2 {
+		t.Fatalf("Expected the 2 swapped rows to be repaired, %s", result)
+	}
+	for _, mismatch := range result.Mismatches {
+		if mismatch.Id.Equals(depth2) && cube.QuarterTurnMetric.Length(mismatch.Repaired) != 2 {
+			t.Errorf("Expected a 2 move repair but got %q", mismatch.Repaired)
+		}
+		if mismatch.Id.Equals(depth3) && cube.QuarterTurnMetric.Length(mismatch.Repaired) != 3 {
+			t.Errorf("Expected a 3 move repair but got %q", mismatch.Repaired)
+		}
+	}
+
+	result, err = VerifyDatabase(dbPath, VerifyConfig{Fix: VerifyDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Mismatches) != 1 || result.Deleted != 1 {
+		t.Fatalf("Expected the row that isn't a cube to be deleted, %s", result)
+	}
+	result, err = VerifyDatabase(dbPath, VerifyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 60 || len(result.Mismatches) != 0 {
+		t.Fatalf("Expected the database to be fixed, %s", result)
+	}
+
+	result, err = VerifyDatabase(tablePath, VerifyConfig{Sample: 25, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 25 || len(result.Mismatches) != 0 {
+		t.Errorf("Expected 25 correct table file rows, %s", result)
+	}
+	if _, err := VerifyDatabase(tablePath, VerifyConfig{Fix: VerifyDelete}); err != ErrReadOnlyFix {
+		t.Errorf("Expected table files to be read only but got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package util
+
+import (
+	"fmt"
+	"github.com/matthewjackswann/rubiks/cube"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiskThreshold is the free space to keep on the database's disk, either in bytes or as a
+// percentage of the disk
+type DiskThreshold struct {
+	Bytes   uint64
+	Percent float64
+}
+
+// DefaultDiskThreshold keeps 0.1% of the disk free
+var DefaultDiskThreshold = DiskThreshold{Percent: 0.1}
+
+// ParseDiskThreshold reads thresholds like "5%" or "10GB"
+func ParseDiskThreshold(s string) (DiskThreshold, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return DiskThreshold{}, fmt.Errorf("invalid percentage %q", s)
+		}
+		return DiskThreshold{Percent: percent}, nil
+	}
+	bytes, err := ParseByteSize(s)
+	if err != nil {
+		return DiskThreshold{}, err
+	}
+	return DiskThreshold{Bytes: uint64(bytes)}, nil
+}
+
+func (t DiskThreshold) String() string {
+	if t.Percent > 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return humanBytes(int64(t.Bytes))
+}
+
+// keep is the number of bytes to keep free on a disk of total bytes
+func (t DiskThreshold) keep(total uint64) uint64 {
+	if t.Percent > 0 {
+		return uint64(float64(total) * t.Percent / 100)
+	}
+	return t.Bytes
+}
+
+// DiskGuardConfig is what the generator does as the database's disk fills up
+type DiskGuardConfig struct {
+	Threshold    DiskThreshold
+	ThresholdSet bool          // use Threshold even when it's zero, so "0%" turns the guard off
+	Pause        bool          // wait for space to be freed rather than stopping
+	PollInterval time.Duration // how often to check for space while paused
+}
+
+// diskGuard checks the free space on the disk of a store saved to disk
+type diskGuard struct {
+	path   string
+	config DiskGuardConfig
+	statfs func(dir string) (free, total uint64, err error)
+
+	// the database size when the last depth finished, to see how much each depth grows it
+	depthStartBytes int64
+	lastGrowth      int64
+}
+
+// newDiskGuard is nil for stores that aren't saved to disk, which is always fine to check
+func newDiskGuard(store SolutionStore, config DiskGuardConfig) *diskGuard {
+	disk, onDisk := asDiskStore(store)
+	if !onDisk {
+		return nil
+	}
+	if !config.ThresholdSet {
+		config.Threshold = DefaultDiskThreshold
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 30 * time.Second
+	}
+	g := &diskGuard{path: disk.Path(), config: config, statfs: statfs}
+	g.depthStartBytes = g.databaseBytes()
+	return g
+}
+
+func statfs(dir string) (uint64, uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
+
+// databaseBytes includes sqlite's write ahead log, which holds recent writes until a checkpoint
+func (g *diskGuard) databaseBytes() int64 {
+	size := int64(0)
+	for _, path := range []string{g.path, g.path + "-wal"} {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// check is whether generation can carry on. When the disk is below the threshold it either
+// stops, or waits until there's space again or stop is closed
+func (g *diskGuard) check(progress *ProgressReporter, stop <-chan struct{}) bool {
+	if g == nil {
+		return true
+	}
+	free, total, err := g.statfs(filepath.Dir(g.path))
+	if err != nil {
+		progress.Message("Couldn't check the free disk space: %s", err)
+		return true
+	}
+	keep := g.config.Threshold.keep(total)
+	if free >= keep {
+		return true
+	}
+	if !g.config.Pause {
+		progress.Message("Only %s free on disk, below the %s threshold. Stopping", humanBytes(int64(free)), g.config.Threshold)
+		return false
+	}
+
+	progress.Message("Only %s free on disk, below the %s threshold. Paused until space is freed", humanBytes(int64(free)), g.config.Threshold)
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(g.config.PollInterval):
+		}
+		free, total, err = g.statfs(filepath.Dir(g.path))
+		if err == nil && free >= g.config.Threshold.keep(total) {
+			progress.Message("%s free on disk, carrying on", humanBytes(int64(free)))
+			return true
+		}
+	}
+}
+
+// depthDone reports how much depth grew the database and how many more depths should fit
+func (g *diskGuard) depthDone(depth int, metric cube.Metric, progress *ProgressReporter) {
+	if g == nil {
+		return
+	}
+	size := g.databaseBytes()
+	growth := size - g.depthStartBytes
+	g.depthStartBytes = size
+	free, total, err := g.statfs(filepath.Dir(g.path))
+	if err != nil || growth <= 0 {
+		return
+	}
+	available := int64(free) - int64(g.config.Threshold.keep(total))
+	fit := projectDepths(growth, g.lastGrowth, depth, metric, available)
+	g.lastGrowth = growth
+	progress.Message("Depth %d added %s to the database, about %d more depths fit in the %s free",
+		depth, humanBytes(growth), fit, humanBytes(available))
+}
+
+// projectDepths is how many depths after depth fit in available bytes when depth grew the
+// database by growth. Each depth is assumed to grow it by as much more as the known number of
+// positions grows, or as the last two depths did once that isn't known
+func projectDepths(growth, lastGrowth int64, depth int, metric cube.Metric, available int64) int {
+	ratio := 0.0
+	if lastGrowth > 0 {
+		ratio = float64(growth) / float64(lastGrowth)
+	}
+	next := float64(growth)
+	used := 0.0
+	fit := 0
+	for ; fit < 30; fit++ {
+		d := depth + fit + 1
+		if expected, known := expectedDepthPositions(metric, d); known {
+			previous, _ := expectedDepthPositions(metric, d-1)
+			ratio = expected / previous
+		}
+		if ratio <= 0 {
+			ratio = 1
+		}
+		next *= ratio
+		used += next
+		if used > float64(available) {
+			break
+		}
+	}
+	return fit
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDiskThreshold(t *testing.T) {
+	for s, expected := range map[string]DiskThreshold{"5%": {Percent: 5}, "0.1%": {Percent: 0.1}, "10GB": {Bytes: 10 << 30}, "4096": {Bytes: 4096}} {
+		if threshold, err := ParseDiskThreshold(s); err != nil || threshold != expected {
+			t.Errorf("%q should be %v but got %v %v", s, expected, threshold, err)
+		}
+	}
+	for _, s := range []string{"101%", "-1%", "%", "lots"} {
+		if _, err := ParseDiskThreshold(s); err == nil {
+			t.Errorf("%q shouldn't be a valid threshold", s)
+		}
+	}
+}
+
+// testDiskGuard has a disk of 1000 bytes with the free space given by each call to free
+func testDiskGuard(config DiskGuardConfig, free func() uint64) *diskGuard {
+	return &diskGuard{
+		path:   filepath.Join("dir", "cubes.db"),
+		config: config,
+		statfs: func(string) (uint64, uint64, error) { return free(), 1000, nil },
+	}
+}
+
+func TestDiskGuard_Check(t *testing.T) {
+	progress := NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")
+	stop := make(chan struct{})
+
+	var nilGuard *diskGuard
+	if !nilGuard.check(progress, stop) {
+		t.Errorf("A store that isn't on disk should never be stopped")
+	}
+	if newDiskGuard(NewMemoryStore(), DiskGuardConfig{}) != nil {
+		t.Errorf("A memory store shouldn't have a disk guard")
+	}
+
+	free := uint64(100)
+	guard := testDiskGuard(DiskGuardConfig{Threshold: DiskThreshold{Percent: 5}}, func() uint64 { return free })
+	if !guard.check(progress, stop) {
+		t.Errorf("100 bytes free is above 5%% of the disk")
+	}
+	free = 40
+	if guard.check(progress, stop) {
+		t.Errorf("40 bytes free is below 5%% of the disk")
+	}
+	guard.config.Threshold = DiskThreshold{Bytes: 30}
+	if !guard.check(progress, stop) {
+		t.Errorf("40 bytes free is above 30 bytes")
+	}
+
+	// pausing until there's space again
+	calls := 0
+	paused := testDiskGuard(DiskGuardConfig{Threshold: DiskThreshold{Bytes: 100}, Pause: true, PollInterval: time.Millisecond}, func() uint64 {
+		calls += 1
+		if calls > 3 {
+			return 500
+		}
+		return 10
+	})
+	if !paused.check(progress, stop) || calls != 4 {
+		t.Errorf("The guard should carry on once space is freed, checked %d times", calls)
+	}
+
+	// being asked to stop while paused
+	close(stop)
+	full := testDiskGuard(DiskGuardConfig{Threshold: DiskThreshold{Bytes: 100}, Pause: true, PollInterval: time.Millisecond}, func() uint64 { return 10 })
+	if full.check(progress, stop) {
+		t.Errorf("The guard should stop while paused when asked to")
+	}
+}
+
+type testDiskStore struct {
+	*MemoryStore
+}
+
+func (store testDiskStore) Path() string {
+	return filepath.Join("dir", "cubes.db")
+}
+
+func TestNewDiskGuard_Threshold(t *testing.T) {
+	store := testDiskStore{NewMemoryStore()}
+	if guard := newDiskGuard(store, DiskGuardConfig{}); guard.config.Threshold != DefaultDiskThreshold {
+		t.Errorf("An unset threshold should be the default, got %s", guard.config.Threshold)
+	}
+	off, err := ParseDiskThreshold("0%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := newDiskGuard(store, DiskGuardConfig{Threshold: off, ThresholdSet: true})
+	guard.statfs = func(string) (uint64, uint64, error) { return 0, 1000, nil }
+	progress := NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")
+	if !guard.check(progress, make(chan struct{})) {
+		t.Errorf("A threshold of 0%% should never stop the generator")
+	}
+}
+
+func TestNewDiskGuard_Filtered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cubes.db")
+	db := CreateDBConnection(path)
+	store, err := OpenGeneratorFilter(db, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	guard := newDiskGuard(store, DiskGuardConfig{})
+	if guard == nil || guard.path != path {
+		t.Errorf("A database behind a bloom filter should still have its disk guarded")
+	}
+	if progress := progressReporter(nil, store, cube.QuarterTurnMetric); progress.dbPath != path {
+		t.Errorf("Progress should report the size of the database behind a bloom filter, got %q", progress.dbPath)
+	}
+}
+
+func TestProjectDepths(t *testing.T) {
+	// QTM depth 6 has 878880 positions and depth 7 has 8221632, about 9.35 times as many
+	if fit := projectDepths(1000, 0, 6, cube.QuarterTurnMetric, 9000); fit != 0 {
+		t.Errorf("Depth 7 needs about 9350 bytes so shouldn't fit, got %d", fit)
+	}
+	if fit := projectDepths(1000, 0, 6, cube.QuarterTurnMetric, 10000); fit != 1 {
+		t.Errorf("Only depth 7 should fit, got %d", fit)
+	}
+	// past the known distribution the growth of the last depth is used
+	if fit := projectDepths(1000, 500, 30, cube.QuarterTurnMetric, 2000+4000+8000); fit != 3 {
+		t.Errorf("Doubling from 1000 bytes, 3 more depths should fit, got %d", fit)
+	}
+}
@@ -0,0 +1,450 @@
+package util
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The frontier generator builds the table one depth at a time. Every cube at depth d+1 is a
+// move away from a cube at depth d, so only the unique cubes of the last depth are expanded
+// rather than replaying every move string from solved. Each depth is kept on disk as a table
+// file sorted by id, so children can be checked against the two depths before them, which
+// are the only places a cube seen before can be found
+
+// FrontierConfig holds the settings for generating the solutions table by frontiers
+type FrontierConfig struct {
+	MaximumDepth int
+	Metric       cube.Metric
+	Backend      cube.Backend // representation used to apply each move
+	Dir          string       // where the frontier of each depth is kept while generating
+	ChunkSize    int          // number of cubes each worker holds before sorting them into a run on disk
+	Workers      int          // number of goroutines expanding the frontier, at least one is used
+	// the limits are checked once a depth is finished, a signal stops part way through a depth
+	// and it's started again when carrying on
+	MaxDuration time.Duration
+	MaxStates   int
+	Progress    *ProgressReporter // defaults to reporting to stdout
+	Disk        DiskGuardConfig
+}
+
+var errFrontierStopped = errors.New("stopped part way through a depth")
+
+const defaultFrontierChunkSize = 1 << 20
+const frontierSaveBatchSize = 10000
+
+type frontierEntry struct {
+	l, h     int64
+	solution uint64
+}
+
+// less orders entries by id, then by solution so the same cube is always kept whatever the number of workers
+func (e frontierEntry) less(other frontierEntry) bool {
+	if e.l != other.l || e.h != other.h {
+		return e.l < other.l || (e.l == other.l && e.h < other.h)
+	}
+	return e.solution < other.solution
+}
+
+func (e frontierEntry) id() uint128.Uint128 {
+	return uint128.Uint128{H: uint64(e.h), L: uint64(e.l)}
+}
+
+func frontierPath(dir string, depth int) string {
+	return filepath.Join(dir, fmt.Sprintf("frontier_%02d.table", depth))
+}
+
+func frontierRunPath(dir string, depth, run int) string {
+	return filepath.Join(dir, fmt.Sprintf("run_%02d_%04d.bin", depth, run))
+}
+
+// frontierCheckpoint is the checkpoint once depth has been generated, the stack generator
+// would be starting the next depth with a stack of zeros, so either generator can carry on
+func frontierCheckpoint(depth int) Checkpoint {
+	return Checkpoint{NextNum: 0, EncodedStack: strings.TrimSuffix(strings.Repeat("0,", depth+1), ",")}
+}
+
+// completedFrontierDepth is the depth finished at checkpoint, or -1 if a depth was part done
+func completedFrontierDepth(checkpoint Checkpoint) int {
+	stack := strings.Split(checkpoint.EncodedStack, ",")
+	for _, s := range stack {
+		if s != "0" {
+			return -1
+		}
+	}
+	return len(stack) - 1
+}
+
+// StartFrontierGenerator fills the store with every cube up to config.MaximumDepth from solved.
+// It carries on from the store's checkpoint if the frontiers it needs are still in config.Dir
+func StartFrontierGenerator(store SolutionStore, config FrontierConfig) error {
+	defer store.Close()
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultFrontierChunkSize
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return err
+	}
+	moves := metricMoves(config.Metric)
+	progress := progressReporter(config.Progress, store, config.Metric)
+	stop, stopWatching := watchForStop(progress)
+	guard := newDiskGuard(store, config.Disk)
+	defer stopWatching()
+	start := time.Now()
+	states := 0
+
+	depth := completedFrontierDepth(store.GetCheckpoint())
+	if !frontiersExist(config.Dir, depth) {
+		depth = 0
+		solved, err := createTableFile(frontierPath(config.Dir, 0), config.Metric, store.GetEncoding(), initialCheckpoint, 1, 8)
+		if err != nil {
+			return err
+		}
+		solvedEntry := frontierEntry{l: int64(cube.SolvedCubeId.L), h: int64(cube.SolvedCubeId.H)}
+		if err := solved.add(solvedEntry.l, solvedEntry.h, 0); err != nil {
+			solved.finish()
+			return err
+		}
+		if err := solved.finish(); err != nil {
+			return err
+		}
+	} else {
+		progress.Message("Carrying on from depth %d", depth)
+	}
+
+	for depth < config.MaximumDepth {
+		if !guard.check(progress, stop) {
+			return nil
+		}
+		count, err := expandFrontier(store, config, moves, depth, stop, progress, guard)
+		if err == errFrontierStopped {
+			progress.Message("Stopped during depth %d, carrying on will start it again", depth+1)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		states += count
+		depth += 1
+		progress.DepthDone(depth, count)
+		guard.depthDone(depth, config.Metric, progress)
+		if !store.SetCheckpoint(frontierCheckpoint(depth)) {
+			return fmt.Errorf("couldn't save the checkpoint for depth %d", depth)
+		}
+		// the frontier before the last isn't needed to check the next depth
+		if depth >= 2 {
+			os.Remove(frontierPath(config.Dir, depth-2))
+		}
+		if count == 0 {
+			break
+		}
+		if reason := limitReached(config.MaxDuration, config.MaxStates, time.Since(start), states); reason != "" && depth < config.MaximumDepth {
+			progress.Message("Stopping after %s", reason)
+			break
+		}
+	}
+	return nil
+}
+
+func frontiersExist(dir string, depth int) bool {
+	if depth < 0 {
+		return false
+	}
+	for d := depth; d >= 0 && d >= depth-1; d-- {
+		if _, err := os.Stat(frontierPath(dir, d)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// expandFrontier writes the frontier of depth+1 and saves its cubes to the store
+func expandFrontier(store SolutionStore, config FrontierConfig, moves []string, depth int, stop <-chan struct{}, progress *ProgressReporter, guard *diskGuard) (int, error) {
+	current, err := OpenTableFile(frontierPath(config.Dir, depth))
+	if err != nil {
+		return 0, err
+	}
+	defer current.Close()
+	var previous *TableFile
+	if depth > 0 {
+		previous, err = OpenTableFile(frontierPath(config.Dir, depth-1))
+		if err != nil {
+			return 0, err
+		}
+		defer previous.Close()
+	}
+
+	// runs left behind by a depth that was stopped part way through
+	if stale, err := filepath.Glob(filepath.Join(config.Dir, fmt.Sprintf("run_%02d_*.bin", depth+1))); err == nil {
+		for _, run := range stale {
+			os.Remove(run)
+		}
+	}
+
+	progress.Message("Expanding the %d cubes at depth %d", current.Count(), depth)
+	runs, err := expandIntoRuns(config, store.GetEncoding(), moves, depth, current, previous, stop)
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+	if err != nil {
+		return 0, err
+	}
+	return mergeRuns(store, runs, frontierPath(config.Dir, depth+1), config.Metric, depth, stop, progress, guard)
+}
+
+// expandIntoRuns applies every move to each cube in the frontier, writing the new cubes into
+// sorted runs. Children seen at the last two depths are dropped straight away
+func expandIntoRuns(config FrontierConfig, encoding cube.Encoding, moves []string, depth int, current, previous *TableFile, stop <-chan struct{}) ([]string, error) {
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var lock sync.Mutex
+	var runs []string
+	var firstErr error
+	writeRun := func(entries []frontierEntry) {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].less(entries[j]) })
+		lock.Lock()
+		path := frontierRunPath(config.Dir, depth+1, len(runs))
+		runs = append(runs, path)
+		lock.Unlock()
+		if err := writeRunFile(path, entries); err != nil {
+			lock.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			lock.Unlock()
+		}
+	}
+
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			entries := make([]frontierEntry, 0, config.ChunkSize)
+			for i := w; i < current.Count(); i += workers {
+				if stopped(stop) {
+					return
+				}
+				l, h, encodedSolution := current.entry(i)
+				parent, err := config.Backend.FromLayout(cube.DecodeCube(uint128.Uint128{H: uint64(h), L: uint64(l)}).Layout)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+					return
+				}
+				parentSolution := decodeTransform(encodedSolution)
+				for _, move := range moves {
+					child := parent.Copy()
+					child.Transform(move)
+					id, rotation := encoding.Encode(child)
+					if _, seen := current.Lookup(id); seen {
+						continue
+					}
+					if previous != nil {
+						if _, seen := previous.Lookup(id); seen {
+							continue
+						}
+					}
+					solution := cube.RotateTransform(cube.ReverseTransform(rotation), cube.ReverseTransform(move)+parentSolution)
+					entries = append(entries, frontierEntry{l: int64(id.L), h: int64(id.H), solution: encodeTransform(solution)})
+					if len(entries) == config.ChunkSize {
+						writeRun(entries)
+						entries = make([]frontierEntry, 0, config.ChunkSize)
+					}
+				}
+			}
+			if len(entries) > 0 {
+				writeRun(entries)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if firstErr == nil && stopped(stop) {
+		return runs, errFrontierStopped
+	}
+	return runs, firstErr
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeRunFile(path string, entries []frontierEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	writer := bufio.NewWriterSize(f, 1<<20)
+	buf := make([]byte, tableEntrySize)
+	for _, e := range entries {
+		binary.LittleEndian.PutUint64(buf[0:8], uint64(e.l))
+		binary.LittleEndian.PutUint64(buf[8:16], uint64(e.h))
+		binary.LittleEndian.PutUint64(buf[16:24], e.solution)
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+type runReader struct {
+	f      *os.File
+	reader *bufio.Reader
+	buf    []byte
+	head   frontierEntry
+}
+
+// next moves on to the next entry of the run, returning false at the end
+func (r *runReader) next() (bool, error) {
+	if _, err := io.ReadFull(r.reader, r.buf); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	r.head = frontierEntry{
+		l:        int64(binary.LittleEndian.Uint64(r.buf[0:8])),
+		h:        int64(binary.LittleEndian.Uint64(r.buf[8:16])),
+		solution: binary.LittleEndian.Uint64(r.buf[16:24]),
+	}
+	return true, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].head.less(h[j].head) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted runs into the next frontier, keeping one of each cube,
+// and saves the cubes to the store as it goes
+func mergeRuns(store SolutionStore, runs []string, path string, metric cube.Metric, depth int, stop <-chan struct{}, progress *ProgressReporter, guard *diskGuard) (int, error) {
+	readers := &runHeap{}
+	defer func() {
+		for _, r := range *readers {
+			r.f.Close()
+		}
+	}()
+	total := 0
+	for _, run := range runs {
+		f, err := os.Open(run)
+		if err != nil {
+			return 0, err
+		}
+		if info, err := f.Stat(); err == nil {
+			total += int(info.Size() / tableEntrySize)
+		}
+		r := &runReader{f: f, reader: bufio.NewReaderSize(f, 1<<16), buf: make([]byte, tableEntrySize)}
+		ok, err := r.next()
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		if !ok {
+			f.Close()
+			continue
+		}
+		*readers = append(*readers, r)
+	}
+	heap.Init(readers)
+
+	next, err := createTableFile(path, metric, store.GetEncoding(), initialCheckpoint, uint64(total), 8)
+	if err != nil {
+		return 0, err
+	}
+	checkpoint := frontierCheckpoint(depth)
+	batch := make(map[uint128.Uint128]uint64, frontierSaveBatchSize)
+	count, merged := 0, 0
+	reportedCount, reportedMerged := 0, 0
+	report := func() {
+		progress.Batch(BatchProgress{
+			Depth:      depth + 1,
+			States:     merged - reportedMerged,
+			Unique:     count - reportedCount,
+			Progress:   float64(merged) / float64(total),
+			Checkpoint: checkpoint,
+		})
+		reportedCount, reportedMerged = count, merged
+	}
+	var last frontierEntry
+	for readers.Len() > 0 {
+		r := (*readers)[0]
+		e := r.head
+		merged += 1
+		if count == 0 || e.l != last.l || e.h != last.h {
+			if err := next.add(e.l, e.h, e.solution); err != nil {
+				next.finish()
+				return 0, err
+			}
+			batch[e.id()] = e.solution
+			count += 1
+			last = e
+			if len(batch) == frontierSaveBatchSize {
+				if stopped(stop) {
+					next.finish()
+					return 0, errFrontierStopped
+				}
+				if !store.Save(batch, checkpoint) {
+					next.finish()
+					return 0, fmt.Errorf("couldn't save cubes at depth %d", depth+1)
+				}
+				report()
+				batch = make(map[uint128.Uint128]uint64, frontierSaveBatchSize)
+				if !guard.check(progress, stop) {
+					next.finish()
+					return 0, errFrontierStopped
+				}
+			}
+		}
+		ok, err := r.next()
+		if err != nil {
+			next.finish()
+			return 0, err
+		}
+		if ok {
+			heap.Fix(readers, 0)
+		} else {
+			r.f.Close()
+			heap.Pop(readers)
+		}
+	}
+	if len(batch) > 0 && !store.Save(batch, checkpoint) {
+		next.finish()
+		return 0, fmt.Errorf("couldn't save cubes at depth %d", depth+1)
+	}
+	if total > 0 {
+		report()
+	}
+	return count, next.finish()
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"testing"
+)
+
+func TestStartFrontierGenerator(t *testing.T) {
+	depth := 4
+	expected := generateMemoryStore(t, depth)
+	store := NewMemoryStore()
+	err := StartFrontierGenerator(store, FrontierConfig{
+		MaximumDepth: depth,
+		Metric:       cube.QuarterTurnMetric,
+		Dir:          t.TempDir(),
+		ChunkSize:    100, // small so the runs are merged
+		Progress:     NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Count() != expected.Count() {
+		t.Errorf("The frontier generator found %d cubes rather than %d", store.Count(), expected.Count())
+	}
+	if checkpoint := store.GetCheckpoint(); completedFrontierDepth(checkpoint) != depth {
+		t.Errorf("The checkpoint %v should be after depth %d", checkpoint, depth)
+	}
+
+	for id, encodedSolution := range store.solutions {
+		expectedSolution, found := expected.Lookup(id)
+		if !found {
+			t.Errorf("Cube %v isn't within %d moves", id, depth)
+			continue
+		}
+		solution := decodeTransform(encodedSolution)
+		if len(solution) != len(expectedSolution) {
+			t.Errorf("Solution %s should be the same length as %s", solution, expectedSolution)
+		}
+		c := cube.DecodeCube(id)
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve cube %v", solution, id)
+		}
+	}
+}
+
+func TestStartFrontierGenerator_Cubies(t *testing.T) {
+	expected := generateMemoryStore(t, 3)
+	store := NewMemoryStore()
+	err := StartFrontierGenerator(store, FrontierConfig{MaximumDepth: 3, Metric: cube.QuarterTurnMetric, Backend: cube.CubieBackend,
+		Dir: t.TempDir(), Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Count() != expected.Count() {
+		t.Errorf("The frontier generator found %d cubes with cubies rather than %d", store.Count(), expected.Count())
+	}
+	for id, encodedSolution := range store.solutions {
+		c := cube.DecodeCube(id)
+		c.Transform(decodeTransform(encodedSolution))
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve cube %v", decodeTransform(encodedSolution), id)
+		}
+	}
+}
+
+func TestStartFrontierGenerator_CarriesOn(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryStore()
+	config := FrontierConfig{MaximumDepth: 2, Metric: cube.HalfTurnMetric, Dir: dir, Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.HalfTurnMetric, cube.RotationEncoding, "")}
+	if err := StartFrontierGenerator(store, config); err != nil {
+		t.Fatal(err)
+	}
+	config.MaximumDepth = 3
+	if err := StartFrontierGenerator(store, config); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := NewMemoryStore()
+	if err := StartFrontierGenerator(fresh, FrontierConfig{MaximumDepth: 3, Metric: cube.HalfTurnMetric, Dir: t.TempDir(), Workers: 3,
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.HalfTurnMetric, cube.RotationEncoding, "")}); err != nil {
+		t.Fatal(err)
+	}
+	if store.Count() != fresh.Count() {
+		t.Errorf("Carrying on found %d cubes rather than %d", store.Count(), fresh.Count())
+	}
+	for id, solution := range fresh.solutions {
+		if store.solutions[id] != solution {
+			t.Errorf("Cube %v was saved with %s rather than %s", id, decodeTransform(store.solutions[id]), decodeTransform(solution))
+		}
+	}
+}
+
+func TestStartFrontierGenerator_MaxStates(t *testing.T) {
+	store := NewMemoryStore()
+	config := FrontierConfig{MaximumDepth: 4, Metric: cube.QuarterTurnMetric, Dir: t.TempDir(), MaxStates: 50,
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")}
+	if err := StartFrontierGenerator(store, config); err != nil {
+		t.Fatal(err)
+	}
+	// depths 1 to 3 have 60 cubes, so it stops once depth 3 is done
+	if depth := completedFrontierDepth(store.GetCheckpoint()); depth != 3 {
+		t.Errorf("The generator should stop after depth 3 but stopped after %d", depth)
+	}
+	if err := StartFrontierGenerator(store, config); err != nil {
+		t.Fatal(err)
+	}
+	if depth := completedFrontierDepth(store.GetCheckpoint()); depth != 4 {
+		t.Errorf("Carrying on should finish depth 4 but stopped after %d", depth)
+	}
+}
+
+func TestStartFrontierGenerator_Reflections(t *testing.T) {
+	expected := NewMemoryStore()
+	expected.SetEncoding(cube.ReflectionEncoding)
+	generateWithWorkers(expected, 4, 1)
+	store := NewMemoryStore()
+	store.SetEncoding(cube.ReflectionEncoding)
+	if err := StartFrontierGenerator(store, FrontierConfig{MaximumDepth: 4, Metric: cube.QuarterTurnMetric, Dir: t.TempDir(),
+		Progress: NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, "")}); err != nil {
+		t.Fatal(err)
+	}
+	if store.Count() != expected.Count() {
+		t.Errorf("The frontier generator found %d cubes rather than %d", store.Count(), expected.Count())
+	}
+	for id := range expected.solutions {
+		if _, found := store.solutions[id]; !found {
+			t.Fatalf("Cube %v is missing", id)
+		}
+	}
+}
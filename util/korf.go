@@ -0,0 +1,127 @@
+package util
+
+import (
+	"errors"
+	"github.com/matthewjackswann/rubiks/cube"
+	"strings"
+	"time"
+)
+
+// ErrKorfTimeout is returned when the search doesn't finish before its deadline
+var ErrKorfTimeout = errors.New("optimal search timed out")
+
+// graphNode is a node of a generator graph with the moves numbered, so the search
+// can follow the graph's move pruning rules without looking up strings
+type graphNode struct {
+	moves []int
+	next  []int
+}
+
+// KorfSolver finds optimal solutions with an IDA* search, using the largest distance
+// from its pattern databases as the lower bound on the moves left
+type KorfSolver struct {
+	Metric    cube.Metric
+	moveNames []string
+	moves     []pieceMove
+	graph     []graphNode
+	databases []*PatternDatabase
+}
+
+// NewKorfSolver searches in the metric the pattern databases were generated with
+func NewKorfSolver(metric cube.Metric, databases []*PatternDatabase) *KorfSolver {
+	solver := &KorfSolver{Metric: metric, moveNames: metricMoves(metric), databases: databases}
+	moveIndex := make(map[string]int)
+	for i, move := range solver.moveNames {
+		solver.moves = append(solver.moves, newPieceMove(move))
+		moveIndex[move] = i
+	}
+
+	start := cube.LoadGraph(metric.TransformGraph())
+	nodeIndex := map[*cube.Node]int{start: 0}
+	nodes := []*cube.Node{start}
+	for i := 0; i < len(nodes); i++ {
+		var node graphNode
+		for _, move := range nodes[i].Moves() {
+			next := nodes[i].Next(move)
+			if _, seen := nodeIndex[next]; !seen {
+				nodeIndex[next] = len(nodes)
+				nodes = append(nodes, next)
+			}
+			node.moves = append(node.moves, moveIndex[move])
+			node.next = append(node.next, nodeIndex[next])
+		}
+		solver.graph = append(solver.graph, node)
+	}
+	return solver
+}
+
+func (solver *KorfSolver) heuristic(state *pieceState) int {
+	distance := 0
+	for _, pdb := range solver.databases {
+		if d := pdb.distance(state); d > distance {
+			distance = d
+		}
+	}
+	return distance
+}
+
+type korfSearch struct {
+	solver   *KorfSolver
+	path     []int
+	deadline time.Time
+	nodes    int
+}
+
+func (search *korfSearch) timedOut() bool {
+	search.nodes += 1
+	return search.nodes%4096 == 0 && time.Now().After(search.deadline)
+}
+
+func (search *korfSearch) search(state pieceState, node, togo int) (bool, error) {
+	if togo == 0 {
+		return state.isSolved(), nil
+	}
+	if search.timedOut() {
+		return false, ErrKorfTimeout
+	}
+	solver := search.solver
+	for i, m := range solver.graph[node].moves {
+		next := state.apply(&solver.moves[m])
+		if solver.heuristic(&next) > togo-1 {
+			continue
+		}
+		search.path = append(search.path, m)
+		found, err := search.search(next, solver.graph[node].next[i], togo-1)
+		if found || err != nil {
+			return found, err
+		}
+		search.path = search.path[:len(search.path)-1]
+	}
+	return false, nil
+}
+
+// Solve finds an optimal solution of at most maxDepth moves. Random cubes need up to 20
+// half turns, which can take a long time to search even with Korf's pattern databases,
+// so it gives up with ErrKorfTimeout when the timeout is reached
+func (solver *KorfSolver) Solve(state cube.State, maxDepth int, timeout time.Duration) (string, bool, error) {
+	c, ok := toCubieCube(state)
+	if !ok {
+		return "", false, nil
+	}
+	start := newPieceState(c)
+	search := &korfSearch{solver: solver, deadline: time.Now().Add(timeout)}
+	for depth := solver.heuristic(&start); depth <= maxDepth; depth++ {
+		found, err := search.search(start, 0, depth)
+		if err != nil {
+			return "", false, err
+		}
+		if found {
+			solution := strings.Builder{}
+			for _, m := range search.path {
+				solution.WriteString(solver.moveNames[m])
+			}
+			return solution.String(), true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,150 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPieceSubsetIndex(t *testing.T) {
+	subsets := []pieceSubset{
+		newPieceSubset([]int{0, 1, 2, 3, 4, 5, 6, 7}, 8, 3),
+		newPieceSubset([]int{2, 5, 7}, 8, 3),
+		newPieceSubset([]int{0, 3, 6, 9}, 12, 2),
+		newPieceSubset(nil, 12, 2),
+	}
+	r := rand.New(rand.NewSource(0))
+	for _, s := range subsets {
+		slots := make([]uint8, len(s.pieces))
+		for i := 0; i < 1000; i++ {
+			index := r.Intn(s.size())
+			s.decode(index, slots)
+			if decoded := s.index(slots); decoded != index {
+				t.Errorf("Index %d of pieces %v decoded to %v which has index %d", index, s.pieces, slots, decoded)
+			}
+		}
+	}
+}
+
+var testPatternSets = []PatternSet{
+	{Corners: []int{0, 1, 2, 3}},
+	{Corners: []int{4, 5, 6, 7}},
+	{Edges: []int{0, 1, 2, 3}},
+	{Edges: []int{4, 5, 6, 7}},
+	{Edges: []int{8, 9, 10, 11}},
+}
+
+func TestGeneratePatternDatabase(t *testing.T) {
+	pdb := GeneratePatternDatabase(PatternSet{Corners: []int{0, 1, 2}, Edges: []int{0}}, cube.HalfTurnMetric)
+	for i := 0; i < pdb.size(); i++ {
+		if pdb.get(i) == 0xF {
+			t.Fatalf("Entry %d of the pattern database wasn't reached", i)
+		}
+	}
+
+	r := rand.New(rand.NewSource(0))
+	moves := []string{"F", "f", "L", "l", "R", "r", "B", "b", "U", "u", "D", "d"}
+	for i := 0; i < 100; i++ {
+		c := cube.NewSolvedCubieCube()
+		length := r.Intn(8)
+		for j := 0; j < length; j++ {
+			c.Transform(moves[r.Intn(len(moves))])
+		}
+		state := newPieceState(c)
+		if distance := pdb.distance(&state); distance > length {
+			t.Errorf("Distance %d is more than the %d moves used to scramble the cube", distance, length)
+		}
+	}
+}
+
+func TestKorfSolver(t *testing.T) {
+	for _, metric := range []cube.Metric{cube.QuarterTurnMetric, cube.HalfTurnMetric} {
+		var databases []*PatternDatabase
+		for _, set := range testPatternSets {
+			databases = append(databases, GeneratePatternDatabase(set, metric))
+		}
+		solver := NewKorfSolver(metric, databases)
+
+		tests := []struct {
+			scramble string
+			qtm      int
+			htm      int
+		}{
+			{"", 0, 0},
+			{"R", 1, 1},
+			{"FFRR", 4, 2},
+			{"RUru", 4, 4},
+			{"FRUBLD", 6, 6},
+		}
+		for _, test := range tests {
+			c := cube.NewSolvedCube()
+			c.Transform(test.scramble)
+			solution, found, err := solver.Solve(c, 10, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found {
+				t.Errorf("No %s solution found for %s", metric, test.scramble)
+				continue
+			}
+			expected := test.qtm
+			if metric == cube.HalfTurnMetric {
+				expected = test.htm
+			}
+			if length := metric.Length(solution); length != expected {
+				t.Errorf("The %s solution %s for %s should be %d moves", metric, solution, test.scramble, expected)
+			}
+			c.Transform(solution)
+			if !c.IsSolved() {
+				t.Errorf("Solution %s doesn't solve %s", solution, test.scramble)
+			}
+		}
+	}
+}
+
+func TestKorfSolver_NoLongerThanScramble(t *testing.T) {
+	var databases []*PatternDatabase
+	for _, set := range testPatternSets {
+		databases = append(databases, GeneratePatternDatabase(set, cube.HalfTurnMetric))
+	}
+	solver := NewKorfSolver(cube.HalfTurnMetric, databases)
+	r := rand.New(rand.NewSource(1))
+	moves := []string{"F", "f", "FF", "L", "l", "LL", "U", "u", "UU"}
+	for i := 0; i < 10; i++ {
+		scramble := ""
+		for j := 0; j < 6; j++ {
+			scramble += moves[r.Intn(len(moves))]
+		}
+		c := cube.NewSolvedCubieCube()
+		c.Transform(scramble)
+		solution, found, err := solver.Solve(c, 6, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Errorf("No solution found for %s within its own length", scramble)
+			continue
+		}
+		if cube.HalfTurnMetric.Length(solution) > cube.HalfTurnMetric.Length(scramble) {
+			t.Errorf("Solution %s is longer than scramble %s", solution, scramble)
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve %s", solution, scramble)
+		}
+	}
+}
+
+func TestKorfSolver_Timeout(t *testing.T) {
+	var databases []*PatternDatabase
+	for _, set := range testPatternSets {
+		databases = append(databases, GeneratePatternDatabase(set, cube.HalfTurnMetric))
+	}
+	solver := NewKorfSolver(cube.HalfTurnMetric, databases)
+	c := cube.NewSolvedCubieCube()
+	c.Transform("RUfLLDbrFUdlBBRfDuL")
+	if _, _, err := solver.Solve(c, 20, time.Millisecond); err != ErrKorfTimeout {
+		t.Errorf("Expected the search to time out, got %v", err)
+	}
+}
@@ -0,0 +1,344 @@
+package util
+
+import (
+	"fmt"
+	"github.com/matthewjackswann/rubiks/cube"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PatternSet chooses which corners and edges a pattern database tracks, using the
+// cubie numbering from cube.CubieCube. Every other piece is ignored so the distance
+// stored is a lower bound on the moves needed to solve the whole cube
+type PatternSet struct {
+	Corners []int
+	Edges   []int
+}
+
+// KorfPatternSets are the corners and two halves of the edges, as used by Korf
+var KorfPatternSets = []PatternSet{
+	{Corners: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	{Edges: []int{0, 1, 2, 3, 4, 5}},
+	{Edges: []int{6, 7, 8, 9, 10, 11}},
+}
+
+func (set PatternSet) String() string {
+	pieces := func(p []int) string {
+		s := make([]string, len(p))
+		for i, piece := range p {
+			s[i] = strconv.Itoa(piece)
+		}
+		return strings.Join(s, "-")
+	}
+	return fmt.Sprintf("c%s_e%s", pieces(set.Corners), pieces(set.Edges))
+}
+
+// pieceState stores position*orientations + orientation for every corner and edge cubie,
+// so moves only need to look at the pieces a pattern database tracks
+type pieceState struct {
+	corners [8]uint8
+	edges   [12]uint8
+}
+
+// pieceMove maps where each corner and edge slot is sent by a move
+type pieceMove struct {
+	corners [24]uint8
+	edges   [24]uint8
+}
+
+func newPieceMove(transform string) pieceMove {
+	move := cube.NewSolvedCubieCube()
+	move.Transform(transform)
+	var m pieceMove
+	for i := 0; i < 8; i++ {
+		for o := 0; o < 3; o++ {
+			m.corners[move.CP[i]*3+o] = uint8(i*3 + (o+move.CO[i])%3)
+		}
+	}
+	for i := 0; i < 12; i++ {
+		for o := 0; o < 2; o++ {
+			m.edges[move.EP[i]*2+o] = uint8(i*2 + (o+move.EO[i])%2)
+		}
+	}
+	return m
+}
+
+func newPieceState(c *cube.CubieCube) pieceState {
+	var state pieceState
+	for i := 0; i < 8; i++ {
+		state.corners[c.CP[i]] = uint8(i*3 + c.CO[i])
+	}
+	for i := 0; i < 12; i++ {
+		state.edges[c.EP[i]] = uint8(i*2 + c.EO[i])
+	}
+	return state
+}
+
+func (state pieceState) apply(m *pieceMove) pieceState {
+	for i, slot := range state.corners {
+		state.corners[i] = m.corners[slot]
+	}
+	for i, slot := range state.edges {
+		state.edges[i] = m.edges[slot]
+	}
+	return state
+}
+
+func (state pieceState) isSolved() bool {
+	for i, slot := range state.corners {
+		if int(slot) != i*3 {
+			return false
+		}
+	}
+	for i, slot := range state.edges {
+		if int(slot) != i*2 {
+			return false
+		}
+	}
+	return true
+}
+
+// pieceSubset numbers the arrangements of some of the corners or edges. The positions
+// are ranked as a partial permutation and the orientations appended, leaving out the
+// last orientation when every piece is tracked as it's fixed by the others
+type pieceSubset struct {
+	pieces       []int
+	positions    int
+	orientations int
+	orientDigits int
+	permSize     int
+	orientSize   int
+	slotPosition [24]int // avoids dividing by orientations for every slot
+	slotOrient   [24]int
+}
+
+func newPieceSubset(pieces []int, positions, orientations int) pieceSubset {
+	s := pieceSubset{pieces: pieces, positions: positions, orientations: orientations, orientDigits: len(pieces)}
+	if len(pieces) == positions {
+		s.orientDigits -= 1
+	}
+	s.permSize, s.orientSize = 1, 1
+	for i := 0; i < len(pieces); i++ {
+		s.permSize *= positions - i
+	}
+	for i := 0; i < s.orientDigits; i++ {
+		s.orientSize *= orientations
+	}
+	for slot := 0; slot < positions*orientations; slot++ {
+		s.slotPosition[slot], s.slotOrient[slot] = slot/orientations, slot%orientations
+	}
+	return s
+}
+
+func (s *pieceSubset) size() int {
+	return s.permSize * s.orientSize
+}
+
+// index ranks the slots of the tracked pieces, given in the same order as s.pieces
+func (s *pieceSubset) index(slots []uint8) int {
+	perm, orient := 0, 0
+	used := uint32(0)
+	for i, slot := range slots {
+		position := s.slotPosition[slot]
+		smallerUsed := bits.OnesCount32(used & (1<<position - 1))
+		perm = perm*(s.positions-i) + position - smallerUsed
+		used |= 1 << position
+		if i < s.orientDigits {
+			orient = orient*s.orientations + s.slotOrient[slot]
+		}
+	}
+	return perm*s.orientSize + orient
+}
+
+// decode is the inverse of index, filling slots for each of the tracked pieces
+func (s *pieceSubset) decode(index int, slots []uint8) {
+	perm, orient := index/s.orientSize, index%s.orientSize
+	k := len(s.pieces)
+	var digits [12]int
+	for i := k - 1; i >= 0; i-- {
+		digits[i] = perm % (s.positions - i)
+		perm /= s.positions - i
+	}
+	var orientations [12]int
+	total := 0
+	for i := s.orientDigits - 1; i >= 0; i-- {
+		orientations[i] = orient % s.orientations
+		orient /= s.orientations
+		total += orientations[i]
+	}
+	if s.orientDigits < k {
+		orientations[k-1] = (s.orientations - total%s.orientations) % s.orientations
+	}
+	used := uint32(0)
+	for i := 0; i < k; i++ {
+		position := 0
+		for skip := digits[i]; ; position++ {
+			if used&(1<<position) != 0 {
+				continue
+			}
+			if skip == 0 {
+				break
+			}
+			skip -= 1
+		}
+		used |= 1 << position
+		slots[i] = uint8(position*s.orientations + orientations[i])
+	}
+}
+
+// PatternDatabase stores the number of moves needed to solve the pieces in a PatternSet
+// for every arrangement of them, two distances to a byte
+type PatternDatabase struct {
+	Set     PatternSet
+	corners pieceSubset
+	edges   pieceSubset
+	table   []byte
+}
+
+func newPatternDatabase(set PatternSet) *PatternDatabase {
+	pdb := &PatternDatabase{
+		Set:     set,
+		corners: newPieceSubset(set.Corners, 8, 3),
+		edges:   newPieceSubset(set.Edges, 12, 2),
+	}
+	pdb.table = make([]byte, (pdb.size()+1)/2)
+	return pdb
+}
+
+func (pdb *PatternDatabase) size() int {
+	return pdb.corners.size() * pdb.edges.size()
+}
+
+func (pdb *PatternDatabase) get(index int) int {
+	return int(pdb.table[index/2]>>(4*(index%2))) & 0xF
+}
+
+func (pdb *PatternDatabase) set(index int, distance int) {
+	shift := 4 * (index % 2)
+	pdb.table[index/2] = pdb.table[index/2]&^(0xF<<shift) | byte(distance)<<shift
+}
+
+func (pdb *PatternDatabase) index(state *pieceState) int {
+	var cornerSlots [8]uint8
+	var edgeSlots [12]uint8
+	for i, piece := range pdb.Set.Corners {
+		cornerSlots[i] = state.corners[piece]
+	}
+	for i, piece := range pdb.Set.Edges {
+		edgeSlots[i] = state.edges[piece]
+	}
+	return pdb.corners.index(cornerSlots[:len(pdb.Set.Corners)])*pdb.edges.size() + pdb.edges.index(edgeSlots[:len(pdb.Set.Edges)])
+}
+
+// distance is a lower bound on the moves needed to solve the state
+func (pdb *PatternDatabase) distance(state *pieceState) int {
+	return pdb.get(pdb.index(state))
+}
+
+// GeneratePatternDatabase fills in the distances by searching outwards from the solved cube
+// one layer at a time, using the moves of the metric
+func GeneratePatternDatabase(set PatternSet, metric cube.Metric) *PatternDatabase {
+	pdb := newPatternDatabase(set)
+	for i := range pdb.table {
+		pdb.table[i] = 0xFF
+	}
+	var moves []pieceMove
+	for _, move := range metricMoves(metric) {
+		moves = append(moves, newPieceMove(move))
+	}
+
+	cornerSlots := make([]uint8, len(set.Corners))
+	edgeSlots := make([]uint8, len(set.Edges))
+	nextCornerSlots := make([]uint8, len(set.Corners))
+	nextEdgeSlots := make([]uint8, len(set.Edges))
+	edgeSize := pdb.edges.size()
+
+	solved := newPieceState(cube.NewSolvedCubieCube())
+	pdb.set(pdb.index(&solved), 0)
+	for depth, found := 0, 1; found > 0; depth++ {
+		found = 0
+		for index := 0; index < pdb.size(); index++ {
+			if pdb.get(index) != depth {
+				continue
+			}
+			pdb.corners.decode(index/edgeSize, cornerSlots)
+			pdb.edges.decode(index%edgeSize, edgeSlots)
+			for m := range moves {
+				for i, slot := range cornerSlots {
+					nextCornerSlots[i] = moves[m].corners[slot]
+				}
+				for i, slot := range edgeSlots {
+					nextEdgeSlots[i] = moves[m].edges[slot]
+				}
+				next := pdb.corners.index(nextCornerSlots)*edgeSize + pdb.edges.index(nextEdgeSlots)
+				if pdb.get(next) == 0xF {
+					pdb.set(next, depth+1)
+					found += 1
+				}
+			}
+		}
+	}
+	return pdb
+}
+
+// metricMoves are the single moves of the metric, the labels used by its generator graph
+func metricMoves(metric cube.Metric) []string {
+	var moves []string
+	seen := make(map[string]bool)
+	nodes := []*cube.Node{cube.LoadGraph(metric.TransformGraph())}
+	visited := map[*cube.Node]bool{nodes[0]: true}
+	for len(nodes) > 0 {
+		node := nodes[0]
+		nodes = nodes[1:]
+		for _, move := range node.Moves() {
+			if !seen[move] {
+				seen[move] = true
+				moves = append(moves, move)
+			}
+			if next := node.Next(move); !visited[next] {
+				visited[next] = true
+				nodes = append(nodes, next)
+			}
+		}
+	}
+	return moves
+}
+
+func patternDatabasePath(dir string, set PatternSet, metric cube.Metric) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.pdb", metric, set))
+}
+
+// LoadPatternDatabases reads the pattern databases for the metric from dir, generating
+// and saving any that don't exist yet. Generating Korf's databases takes a few minutes
+func LoadPatternDatabases(dir string, metric cube.Metric, sets []PatternSet) ([]*PatternDatabase, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	databases := make([]*PatternDatabase, len(sets))
+	for i, set := range sets {
+		path := patternDatabasePath(dir, set, metric)
+		table, err := os.ReadFile(path)
+		if err == nil {
+			pdb := newPatternDatabase(set)
+			if len(table) != len(pdb.table) {
+				return nil, fmt.Errorf("pattern database %s has %d bytes rather than %d", path, len(table), len(pdb.table))
+			}
+			pdb.table = table
+			databases[i] = pdb
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		fmt.Printf("Generating pattern database %s\n", path)
+		pdb := GeneratePatternDatabase(set, metric)
+		if err := os.WriteFile(path, pdb.table, 0644); err != nil {
+			return nil, err
+		}
+		databases[i] = pdb
+	}
+	return databases, nil
+}
@@ -0,0 +1,378 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/matthewjackswann/rubiks/cube"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressFormat is how generation progress is written
+type ProgressFormat int
+
+const (
+	// ProgressAuto is ProgressTTY when writing to a terminal and ProgressJSON otherwise
+	ProgressAuto ProgressFormat = iota
+	// ProgressTTY keeps a single status line up to date
+	ProgressTTY
+	// ProgressJSON writes a JSON object per line for log collectors
+	ProgressJSON
+)
+
+func ParseProgressFormat(s string) (ProgressFormat, error) {
+	switch strings.ToLower(s) {
+	case "auto":
+		return ProgressAuto, nil
+	case "tty":
+		return ProgressTTY, nil
+	case "json":
+		return ProgressJSON, nil
+	}
+	return ProgressAuto, fmt.Errorf("unknown progress format %q, expected auto, tty or json", s)
+}
+
+// the number of positions at each distance from solved, from the work on God's number.
+// HTM is known exactly up to 15 moves and QTM up to 16
+var htmDistanceCounts = []float64{1, 18, 243, 3240, 43239, 574908, 7618438, 100803036, 1332343288,
+	17596479795, 232248063316, 3063288809012, 40374425656248, 531653418284628, 6989320578825358, 91365146187124313}
+var qtmDistanceCounts = []float64{1, 12, 114, 1068, 10011, 93840, 878880, 8221632, 76843595, 717789576,
+	6701836858, 62549615248, 583570100997, 5442351625028, 50729620202582, 472495678811004, 4393570406220123}
+
+// expectedDepthCubes estimates the number of cube ids at a depth. Most ids cover as many positions
+// as the encoding has symmetries, and a few symmetric ones cover fewer
+func expectedDepthCubes(metric cube.Metric, encoding cube.Encoding, depth int) (float64, bool) {
+	positions, known := expectedDepthPositions(metric, depth)
+	return positions / float64(encoding.Symmetries()), known
+}
+
+// expectedDepthPositions is the number of positions at a depth, if it's known
+func expectedDepthPositions(metric cube.Metric, depth int) (float64, bool) {
+	counts := qtmDistanceCounts
+	if metric == cube.HalfTurnMetric {
+		counts = htmDistanceCounts
+	}
+	if depth < 0 || depth >= len(counts) {
+		return 0, false
+	}
+	return counts[depth], true
+}
+
+// depthCounter counts the transforms the generator makes at each depth, so how far through a
+// depth the generator's stack is can be given exactly
+type depthCounter struct {
+	root  *cube.Node
+	paths map[*cube.Node]map[int]float64
+}
+
+func newDepthCounter(graph string) *depthCounter {
+	return &depthCounter{root: cube.LoadGraph(graph), paths: make(map[*cube.Node]map[int]float64)}
+}
+
+// count is the number of transforms of length moves starting from node
+func (c *depthCounter) count(node *cube.Node, length int) float64 {
+	if length == 0 {
+		return 1
+	}
+	if counts, ok := c.paths[node]; ok {
+		if n, ok := counts[length]; ok {
+			return n
+		}
+	} else {
+		c.paths[node] = make(map[int]float64)
+	}
+	n := 0.0
+	for _, move := range node.Moves() {
+		n += c.count(node.Next(move), length-1)
+	}
+	c.paths[node][length] = n
+	return n
+}
+
+// progress is the fraction of the stack's depth generated before the stack
+func (c *depthCounter) progress(stack []int) float64 {
+	before := 0.0
+	node := c.root
+	for i, index := range stack {
+		moves := node.Moves()
+		for _, move := range moves[:index] {
+			before += c.count(node.Next(move), len(stack)-i-1)
+		}
+		node = node.Next(moves[index])
+	}
+	return before / c.count(c.root, len(stack))
+}
+
+// ProgressReport is a single line of JSON progress
+type ProgressReport struct {
+	Event              string    `json:"event"` // batch, depth or message
+	Time               time.Time `json:"time"`
+	Message            string    `json:"message,omitempty"`
+	Depth              int       `json:"depth"`
+	States             int       `json:"states"` // states generated since starting
+	StatesPerSecond    float64   `json:"states_per_second"`
+	Unique             int       `json:"unique"` // states that were a new cube in their batch
+	DuplicateRatio     float64   `json:"duplicate_ratio"`
+	DepthCubes         int       `json:"depth_cubes"` // cubes saved at the depth so far
+	ExpectedDepthCubes float64   `json:"expected_depth_cubes,omitempty"`
+	DepthProgress      float64   `json:"depth_progress"` // fraction of the depth generated
+	DatabaseBytes      int64     `json:"database_bytes,omitempty"`
+	ETASeconds         float64   `json:"eta_seconds,omitempty"` // until the depth is finished
+	Checkpoint         string    `json:"checkpoint,omitempty"`
+	BatchSize          int       `json:"batch_size,omitempty"`
+	CommitSeconds      float64   `json:"commit_seconds,omitempty"` // time taken to save the batch
+}
+
+// BatchProgress is a batch of states that's been generated and saved
+type BatchProgress struct {
+	Depth         int
+	States        int
+	Unique        int        // states that were a new cube in the batch
+	Progress      float64    // how far through the depth the generator is
+	Checkpoint    Checkpoint // where the generator would carry on from
+	BatchSize     int
+	CommitLatency time.Duration
+}
+
+// batchSizeStats is the throughput seen while using a batch size
+type batchSizeStats struct {
+	size    int
+	states  int
+	elapsed time.Duration
+	commit  time.Duration
+	batches int
+}
+
+// ProgressReporter writes how generation is going, it's safe to use from many goroutines
+type ProgressReporter struct {
+	lock     sync.Mutex
+	out      io.Writer
+	tty      bool
+	metric   cube.Metric
+	encoding cube.Encoding
+	dbPath   string
+	interval time.Duration // batches are only reported this often, depths and messages always are
+	now      func() time.Time
+
+	start, lastReport, lastBatch time.Time
+	states, unique               int
+	depth, depthCubes            int
+	lineOpen                     bool
+
+	// when and how far through the depth the generator was when it was first seen, for the ETA
+	depthStart         time.Time
+	depthStartProgress float64
+	depthStartKnown    bool
+
+	batchSizes []*batchSizeStats // in the order they were first used
+}
+
+// NewProgressReporter reports to out, dbPath is the database whose size is reported, or "" for none
+func NewProgressReporter(out io.Writer, format ProgressFormat, metric cube.Metric, encoding cube.Encoding, dbPath string) *ProgressReporter {
+	if format == ProgressAuto {
+		format = ProgressJSON
+		if f, ok := out.(*os.File); ok {
+			if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				format = ProgressTTY
+			}
+		}
+	}
+	p := &ProgressReporter{
+		out:      out,
+		tty:      format == ProgressTTY,
+		metric:   metric,
+		encoding: encoding,
+		dbPath:   dbPath,
+		interval: time.Second,
+		now:      time.Now,
+	}
+	p.start = p.now()
+	p.lastBatch = p.start
+	return p
+}
+
+// Batch reports a batch that's been saved
+func (p *ProgressReporter) Batch(b BatchProgress) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.now()
+	if b.Depth != p.depth {
+		p.depth, p.depthCubes = b.Depth, 0
+		p.depthStartKnown = false
+	}
+	if !p.depthStartKnown {
+		// part of the depth may have been generated before carrying on, so the ETA is from here
+		p.depthStart, p.depthStartProgress, p.depthStartKnown = now, b.Progress, true
+	}
+	p.states += b.States
+	p.unique += b.Unique
+	p.depthCubes += b.Unique
+	if b.BatchSize > 0 {
+		stats := p.batchSizeStats(b.BatchSize)
+		stats.states += b.States
+		stats.elapsed += now.Sub(p.lastBatch)
+		stats.commit += b.CommitLatency
+		stats.batches += 1
+	}
+	p.lastBatch = now
+	if now.Sub(p.lastReport) < p.interval {
+		return
+	}
+	p.lastReport = now
+
+	report := p.report("batch", now)
+	report.DepthProgress = b.Progress
+	report.Checkpoint = b.Checkpoint.EncodedStack
+	report.BatchSize = b.BatchSize
+	report.CommitSeconds = b.CommitLatency.Seconds()
+	if done := b.Progress - p.depthStartProgress; done > 0 && b.Progress < 1 {
+		report.ETASeconds = now.Sub(p.depthStart).Seconds() * (1 - b.Progress) / done
+	}
+	p.write(report)
+}
+
+func (p *ProgressReporter) batchSizeStats(size int) *batchSizeStats {
+	for _, stats := range p.batchSizes {
+		if stats.size == size {
+			return stats
+		}
+	}
+	stats := &batchSizeStats{size: size}
+	p.batchSizes = append(p.batchSizes, stats)
+	return stats
+}
+
+// BatchSizeSummary reports the throughput and save time of each batch size used
+func (p *ProgressReporter) BatchSizeSummary() {
+	p.lock.Lock()
+	stats := append([]*batchSizeStats(nil), p.batchSizes...)
+	p.lock.Unlock()
+	for _, s := range stats {
+		rate := 0.0
+		if s.elapsed > 0 {
+			rate = float64(s.states) / s.elapsed.Seconds()
+		}
+		p.Message("Batch size %d: %s states/s over %d batches, %s average save",
+			s.size, humanCount(rate), s.batches, (s.commit / time.Duration(s.batches)).Round(time.Microsecond))
+	}
+}
+
+// DepthDone reports that every state at depth has been generated, cubes is how many were saved
+func (p *ProgressReporter) DepthDone(depth, cubes int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := p.now()
+	p.depth, p.depthCubes = depth, cubes
+	report := p.report("depth", now)
+	report.DepthProgress = 1
+	p.write(report)
+	p.depth, p.depthCubes = depth+1, 0
+	p.depthStart, p.depthStartProgress, p.depthStartKnown = now, 0, true
+}
+
+func (p *ProgressReporter) Message(format string, a ...interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	report := p.report("message", p.now())
+	report.Message = fmt.Sprintf(format, a...)
+	p.write(report)
+}
+
+func (p *ProgressReporter) report(event string, now time.Time) ProgressReport {
+	report := ProgressReport{
+		Event:      event,
+		Time:       now,
+		Depth:      p.depth,
+		States:     p.states,
+		Unique:     p.unique,
+		DepthCubes: p.depthCubes,
+	}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		report.StatesPerSecond = float64(p.states) / elapsed
+	}
+	if p.states > 0 {
+		report.DuplicateRatio = 1 - float64(p.unique)/float64(p.states)
+	}
+	report.ExpectedDepthCubes, _ = expectedDepthCubes(p.metric, p.encoding, p.depth)
+	if p.dbPath != "" {
+		// sqlite keeps recent writes in the write ahead log until a checkpoint
+		for _, path := range []string{p.dbPath, p.dbPath + "-wal"} {
+			if info, err := os.Stat(path); err == nil {
+				report.DatabaseBytes += info.Size()
+			}
+		}
+	}
+	return report
+}
+
+func (p *ProgressReporter) write(report ProgressReport) {
+	if !p.tty {
+		line, err := json.Marshal(report)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(p.out, string(line))
+		return
+	}
+
+	// the batch line is redrawn in place, anything else is left on its own line
+	if p.lineOpen {
+		fmt.Fprint(p.out, "\r\x1b[2K")
+	}
+	p.lineOpen = report.Event == "batch"
+	switch report.Event {
+	case "message":
+		fmt.Fprintln(p.out, report.Message)
+	case "depth":
+		fmt.Fprintf(p.out, "Depth %d done: %s cubes%s, %s states at %s/s\n", report.Depth, humanCount(float64(report.DepthCubes)),
+			expectedText(report.ExpectedDepthCubes), humanCount(float64(report.States)), humanCount(report.StatesPerSecond))
+	default:
+		line := fmt.Sprintf("Depth %d %.1f%% | %s states %s/s | %.1f%% duplicates | %s cubes%s",
+			report.Depth, 100*report.DepthProgress, humanCount(float64(report.States)), humanCount(report.StatesPerSecond),
+			100*report.DuplicateRatio, humanCount(float64(report.DepthCubes)), expectedText(report.ExpectedDepthCubes))
+		if report.BatchSize > 0 {
+			line += fmt.Sprintf(" | batch %d saved in %s", report.BatchSize, time.Duration(report.CommitSeconds*float64(time.Second)).Round(time.Millisecond))
+		}
+		if report.DatabaseBytes > 0 {
+			line += " | db " + humanBytes(report.DatabaseBytes)
+		}
+		if report.ETASeconds > 0 {
+			line += " | ETA " + (time.Duration(report.ETASeconds) * time.Second).String()
+		}
+		fmt.Fprint(p.out, line)
+	}
+}
+
+func expectedText(expected float64) string {
+	if expected == 0 {
+		return ""
+	}
+	return " of ~" + humanCount(expected)
+}
+
+func humanCount(n float64) string {
+	for _, unit := range []string{"", "k", "M", "G", "T"} {
+		if n < 1000 {
+			if unit == "" {
+				return fmt.Sprintf("%.0f", n)
+			}
+			return fmt.Sprintf("%.1f%s", n, unit)
+		}
+		n /= 1000
+	}
+	return fmt.Sprintf("%.1fP", n)
+}
+
+func humanBytes(n int64) string {
+	size := float64(n)
+	for _, unit := range []string{"B", "KB", "MB", "GB", "TB"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f%s", size, unit)
+		}
+		size /= 1024
+	}
+	return fmt.Sprintf("%.1fPB", size)
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/matthewjackswann/rubiks/cube"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDepthCounter(t *testing.T) {
+	for _, metric := range []cube.Metric{cube.QuarterTurnMetric, cube.HalfTurnMetric} {
+		counter := newDepthCounter(metric.IdTransformGraph())
+		generator := cube.CreateNewGenerator([]int{0, 0, 0}, 0, metric.IdTransformGraph())
+		total := counter.count(counter.root, 3)
+		for i := 0; generator.GetCurrentDepth() == 3; i++ {
+			if progress := counter.progress(generator.TransformStack); math.Abs(progress-float64(i)/total) > 1e-9 {
+				t.Fatalf("Stack %v is transform %d of %.0f but progress was %f", generator.TransformStack, i, total, progress)
+			}
+			generator.Next()
+		}
+		if generated := generator.GetNextTransformNum(); float64(generated) != total {
+			t.Errorf("The %s generator made %d transforms at depth 3 rather than %.0f", metric, generated, total)
+		}
+	}
+}
+
+func TestExpectedDepthCubes(t *testing.T) {
+	// the frontier generator's exact counts are close to a 24th of the positions
+	for depth, cubes := range []float64{1, 2, 8, 50, 433, 3956, 36769, 343058} {
+		if depth == 0 {
+			continue
+		}
+		expected, known := expectedDepthCubes(cube.QuarterTurnMetric, cube.RotationEncoding, depth)
+		if !known || cubes < expected || cubes > 5*expected {
+			t.Errorf("Expected about %f cubes at depth %d but there are %f", expected, depth, cubes)
+		}
+	}
+	if _, known := expectedDepthCubes(cube.HalfTurnMetric, cube.RotationEncoding, 20); known {
+		t.Errorf("The number of positions 20 moves from solved isn't known exactly")
+	}
+}
+
+func testReporter(format ProgressFormat) (*ProgressReporter, *bytes.Buffer, *time.Time) {
+	out := new(bytes.Buffer)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := NewProgressReporter(out, format, cube.QuarterTurnMetric, cube.RotationEncoding, "")
+	p.now = func() time.Time { return now }
+	p.start = now
+	p.interval = 0
+	return p, out, &now
+}
+
+func TestProgressReporter_JSON(t *testing.T) {
+	p, out, now := testReporter(ProgressJSON)
+	p.DepthDone(4, 433)
+	out.Reset()
+	*now = now.Add(10 * time.Second)
+	p.Batch(BatchProgress{Depth: 5, States: 1000, Unique: 250, Progress: 0.25, Checkpoint: Checkpoint{NextNum: 1000, EncodedStack: "0,1,2,3,4"}})
+	*now = now.Add(30 * time.Second)
+	p.DepthDone(5, 3956)
+	p.Message("Stopping after %s", "input")
+
+	var reports []ProgressReport
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var report ProgressReport
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			t.Fatalf("%q isn't a JSON line: %s", line, err)
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("Expected 3 reports but got %d", len(reports))
+	}
+	batch := reports[0]
+	if batch.Event != "batch" || batch.Depth != 5 || batch.StatesPerSecond != 100 || batch.DuplicateRatio != 0.75 ||
+		batch.DepthCubes != 250 || batch.ETASeconds != 30 || batch.Checkpoint != "0,1,2,3,4" {
+		t.Errorf("Unexpected batch report %+v", batch)
+	}
+	if expected, _ := expectedDepthCubes(cube.QuarterTurnMetric, cube.RotationEncoding, 5); batch.ExpectedDepthCubes != expected {
+		t.Errorf("The batch should be compared against %f cubes but was %f", expected, batch.ExpectedDepthCubes)
+	}
+	if depth := reports[1]; depth.Event != "depth" || depth.DepthCubes != 3956 || depth.DepthProgress != 1 {
+		t.Errorf("Unexpected depth report %+v", depth)
+	}
+	if message := reports[2]; message.Event != "message" || message.Message != "Stopping after input" {
+		t.Errorf("Unexpected message %+v", message)
+	}
+}
+
+func TestProgressReporter_TTY(t *testing.T) {
+	p, out, now := testReporter(ProgressTTY)
+	*now = now.Add(time.Second)
+	p.Batch(BatchProgress{Depth: 3, States: 100, Unique: 50, Progress: 0.5})
+	p.Batch(BatchProgress{Depth: 3, States: 100, Unique: 10, Progress: 0.9})
+	p.DepthDone(3, 60)
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 2 || lines[1] != "" {
+		t.Fatalf("The batch lines should be redrawn in place, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], "\r\x1b[2KDepth 3 90.0%") || !strings.HasSuffix(lines[0], "\r\x1b[2KDepth 3 done: 60 cubes of ~44, 200 states at 200/s") {
+		t.Errorf("Unexpected output %q", lines[0])
+	}
+}
@@ -5,91 +5,213 @@ import (
 	"fmt"
 	"github.com/davidminor/uint128"
 	"github.com/matthewjackswann/rubiks/cube"
-	"golang.org/x/sys/unix"
-	"math"
 	"os"
-	"path"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unicode"
 )
 
 type cubeResult struct {
+	index     int // position of the transform in its batch
 	id        uint128.Uint128
 	transform uint64
 }
 
-func StartSolutionGenerator(db DBConnection, init []int, i, maximumDepth int) {
-	// setup generator
-	generator := cube.CreateNewGenerator(init, i, cube.ID_TRANSFORM_GRAPH)
+// channelBufferSize is the buffer of the channels to and from the cube workers
+const channelBufferSize = 1024
+
+type indexedTransform struct {
+	index     int
+	transform string
+}
+
+// MaxEncodedDepth is the deepest layer whose solutions always fit in the 16 nibbles
+// of the solution encoding. Half turn metric solutions too long for a nibble per quarter
+// turn use the half turn encoding, which fits 14 moves
+func MaxEncodedDepth(metric cube.Metric) int {
+	if metric == cube.HalfTurnMetric {
+		return halfTurnEncodingMoves
+	}
+	return 16
+}
+
+// GeneratorConfig holds the settings for generating the solutions table
+type GeneratorConfig struct {
+	MaximumDepth int
+	Metric       cube.Metric
+	Backend      cube.Backend      // representation used to apply each transform
+	Workers      int               // number of goroutines applying transforms, at least one is used
+	MaxDuration  time.Duration     // stops after the batch running when this much time has passed, 0 for no limit
+	MaxStates    int               // stops after the batch that reaches this many transforms, 0 for no limit
+	Progress     *ProgressReporter // defaults to reporting to stdout
+	BatchSize    int               // transforms in each batch, 0 to adapt it to how long batches take to save
+	MemoryBudget int64             // bytes the batches can use, 0 for no limit
+	Disk         DiskGuardConfig
+}
 
+// progressReporter is the reporter to use for a store, the one given or one writing to stdout
+func progressReporter(progress *ProgressReporter, store SolutionStore, metric cube.Metric) *ProgressReporter {
+	if progress != nil {
+		return progress
+	}
+	dbPath := ""
+	if disk, onDisk := asDiskStore(store); onDisk {
+		dbPath = disk.Path()
+	}
+	return NewProgressReporter(os.Stdout, ProgressAuto, metric, store.GetEncoding(), dbPath)
+}
+
+// watchForStop gives a channel that's closed when the generator is asked to stop, by SIGINT,
+// SIGTERM or a line on stdin. Only the first signal is caught. The returned func stops watching for signals
+func watchForStop(progress *ProgressReporter) (<-chan struct{}, func()) {
 	stop := make(chan struct{})
-	// func for receiving signal to start stopping the generator
+	var once sync.Once
+	stopping := func(reason string) {
+		once.Do(func() {
+			progress.Message("Stopping after %s...", reason)
+			close(stop)
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			// a second signal gets the default handling, so it exits without waiting for a clean stop
+			signal.Stop(signals)
+			stopping(sig.String())
+		case <-done:
+		}
+	}()
 	go func() {
 		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-		fmt.Println("Stopping...")
-		stop <- struct{}{}
+		// stdin being closed, e.g. when running in the background or in tests, isn't a request to stop
+		if !scanner.Scan() {
+			return
+		}
+		stopping("input")
 	}()
+	return stop, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// limitReached is why the generator should stop after generating states for elapsed, or "" to carry on
+func limitReached(maxDuration time.Duration, maxStates int, elapsed time.Duration, states int) string {
+	if maxStates > 0 && states >= maxStates {
+		return fmt.Sprintf("reaching %d states", states)
+	}
+	if maxDuration > 0 && elapsed >= maxDuration {
+		return fmt.Sprintf("running for %s", elapsed.Round(time.Second))
+	}
+	return ""
+}
+
+func StartSolutionGenerator(store SolutionStore, init []int, i int, config GeneratorConfig) {
+	// setup generator
+	generator := cube.CreateNewGenerator(init, i, config.Metric.IdTransformGraph())
+
+	progress := progressReporter(config.Progress, store, config.Metric)
 
-	//batchSize := 1000000
-	batchSize := 1000
+	// the batch being generated is always finished and saved with its checkpoint before stopping
+	stop, stopWatching := watchForStop(progress)
+	defer stopWatching()
+	start := time.Now()
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	sizer := newBatchSizer(config.BatchSize, config.MemoryBudget, workers)
 
 	transformsSent := 0
-	cubeTransforms := make(chan string, batchSize)
 	idsReceived := 0
-	cubeIds := make(chan cubeResult, batchSize)
+	cubeIds := make(chan cubeResult, channelBufferSize)
 
+	// transform i of each batch always goes to worker i % workers, and the result from the
+	// earliest transform is kept for each cube, so the saved solutions don't depend on timing
 	wg := new(sync.WaitGroup)
 	workerStopChannel := make(chan interface{})
-	wg.Add(1)
-	go cubeWorker(cubeTransforms, cubeIds, workerStopChannel, wg)
-
-	fmt.Println("Made cube workers")
+	cubeTransforms := make([]chan indexedTransform, workers)
+	for w := range cubeTransforms {
+		cubeTransforms[w] = make(chan indexedTransform, channelBufferSize)
+		wg.Add(1)
+		go cubeWorker(config.Backend, store.GetEncoding(), cubeTransforms[w], cubeIds, workerStopChannel, wg)
+	}
 
 	dbSaveChan := make(chan batchResults)
-	dbSaveChanResult := make(chan bool, 1)
-	dbSaveChanResult <- true // skips over first save as successful
+	dbSaveChanResult := make(chan saveResult, 1)
+	dbSaveChanResult <- saveResult{success: true} // skips over first save as successful
 	wg.Add(1)
-	go saveWorker(db, dbSaveChan, dbSaveChanResult, wg)
-
-	generatingCubes := true
+	go saveWorker(store, progress, newDepthCounter(config.Metric.IdTransformGraph()), newDiskGuard(store, config.Disk), config.Metric, stop, dbSaveChan, dbSaveChanResult, wg)
 
 	currentDepth := generator.GetCurrentDepth()
 
-	fmt.Println(currentDepth)
+	// carrying on from a checkpoint that already finished the last depth has nothing to do
+	generatingCubes := currentDepth <= config.MaximumDepth
 
 	for generatingCubes { // while generating or ids haven't been processed yet
 
-		for i := 0; i < batchSize && currentDepth == generator.GetCurrentDepth(); i++ {
-			cubeTransforms <- generator.Next()
-			transformsSent += 1
-		}
-		successfulSave := <-dbSaveChanResult
-		if !successfulSave {
-			fmt.Print("Error saving last batch. Quitting\n\n")
-			break
+		batchSize := sizer.size
+		batch := make([]string, 0, batchSize)
+		for len(batch) < batchSize && currentDepth == generator.GetCurrentDepth() {
+			batch = append(batch, generator.Next())
 		}
+		batchStart := transformsSent
+		transformsSent += len(batch)
+		// the batch is handed out while the results come back, so the channels don't need to hold it all
+		go func(batch []string) {
+			for i, transform := range batch {
+				cubeTransforms[i%workers] <- indexedTransform{index: i, transform: transform}
+			}
+		}(batch)
 
-		resultMap := make(map[uint128.Uint128]uint64, batchSize)
+		resultMap := make(map[uint128.Uint128]uint64, len(batch))
+		firstIndex := make(map[uint128.Uint128]int, len(batch))
 
 		for transformsSent != idsReceived {
 			cr := <-cubeIds
 			idsReceived += 1
-			_, keyExists := resultMap[cr.id]
-			if !keyExists {
+			index, keyExists := firstIndex[cr.id]
+			if !keyExists || cr.index < index {
+				firstIndex[cr.id] = cr.index
 				resultMap[cr.id] = cr.transform
 			}
 		}
 
+		saved := <-dbSaveChanResult
+		if !saved.success {
+			progress.Message("Error saving last batch. Quitting")
+			break
+		}
+		if saved.diskFull {
+			break
+		}
+		if saved.states > 0 && sizer.adjust(saved.states, saved.latency) {
+			progress.Message("Batch size %d took %s to save, changing to %d", saved.states, saved.latency.Round(time.Millisecond), sizer.size)
+		}
+
+		// the stack is copied as the generator carries on changing it while the batch is saved
 		dbSaveChan <- batchResults{
 			results:       resultMap,
-			transformNo:   generator.GetCurrentTransformNum(),
-			lastTransform: generator.TransformStack,
+			transformNo:   generator.GetNextTransformNum(),
+			lastTransform: append([]int(nil), generator.TransformStack...),
+			depth:         currentDepth,
+			states:        transformsSent - batchStart,
+			finishedDepth: generator.GetCurrentDepth() != currentDepth,
+			batchSize:     batchSize,
 		}
 
 		currentDepth = generator.GetCurrentDepth()
-		if currentDepth > maximumDepth {
+		if currentDepth > config.MaximumDepth {
 			generatingCubes = false
 		}
 
@@ -98,72 +220,241 @@ func StartSolutionGenerator(db DBConnection, init []int, i, maximumDepth int) {
 			generatingCubes = false
 		default:
 		}
+		if reason := limitReached(config.MaxDuration, config.MaxStates, time.Since(start), transformsSent); generatingCubes && reason != "" {
+			progress.Message("Stopping after %s", reason)
+			generatingCubes = false
+		}
 	}
 
-	fmt.Println("Stopping worker")
-	workerStopChannel <- new(interface{})
-	fmt.Println("Stopping db goroutine and closing db connection")
+	close(workerStopChannel)
 	dbSaveChan <- batchResults{results: nil}
 
 	wg.Wait()
-	fmt.Println("Stopped")
-}
-
-var TransformToInt = map[rune]uint64{ // not transform is represented by a 0
-	'F': 1,
-	'f': 2,
-	'L': 3,
-	'l': 4,
-	'U': 5,
-	'u': 6,
-	'B': 7,
-	'b': 8,
-	'R': 9,
-	'r': 10,
-	'D': 11,
-	'd': 12,
-}
-
-var IntToTransform = map[uint64]rune{
-	1:  'F',
-	2:  'f',
-	3:  'L',
-	4:  'l',
-	5:  'U',
-	6:  'u',
-	7:  'B',
-	8:  'b',
-	9:  'R',
-	10: 'r',
-	11: 'D',
-	12: 'd',
-}
-
-func cubeWorker(generatorResult <-chan string, resultChan chan<- cubeResult, stop <-chan interface{}, wg *sync.WaitGroup) {
+	progress.BatchSizeSummary()
+	progress.Message("Stopped after %d transforms", generator.GetNextTransformNum())
+}
+
+// moves other than the 12 face turns are stored as two nibbles, an escape nibble
+// followed by the code for the move
+const sliceEscape = 13
+const wideEscape = 14
+
+var TransformToInt = map[string]uint64{ // not transform is represented by a 0
+	"F":  1,
+	"f":  2,
+	"L":  3,
+	"l":  4,
+	"U":  5,
+	"u":  6,
+	"B":  7,
+	"b":  8,
+	"R":  9,
+	"r":  10,
+	"D":  11,
+	"d":  12,
+	"M":  sliceEscape | 1<<4,
+	"m":  sliceEscape | 2<<4,
+	"E":  sliceEscape | 3<<4,
+	"e":  sliceEscape | 4<<4,
+	"S":  sliceEscape | 5<<4,
+	"s":  sliceEscape | 6<<4,
+	"X":  sliceEscape | 7<<4,
+	"x":  sliceEscape | 8<<4,
+	"Y":  sliceEscape | 9<<4,
+	"y":  sliceEscape | 10<<4,
+	"Z":  sliceEscape | 11<<4,
+	"z":  sliceEscape | 12<<4,
+	"Fw": wideEscape | 1<<4,
+	"fw": wideEscape | 2<<4,
+	"Lw": wideEscape | 3<<4,
+	"lw": wideEscape | 4<<4,
+	"Uw": wideEscape | 5<<4,
+	"uw": wideEscape | 6<<4,
+	"Bw": wideEscape | 7<<4,
+	"bw": wideEscape | 8<<4,
+	"Rw": wideEscape | 9<<4,
+	"rw": wideEscape | 10<<4,
+	"Dw": wideEscape | 11<<4,
+	"dw": wideEscape | 12<<4,
+}
+
+var IntToTransform = func() map[uint64]string {
+	m := make(map[uint64]string, len(TransformToInt))
+	for k, v := range TransformToInt {
+		m[v] = k
+	}
+	return m
+}()
+
+// encodeTransform packs a transform into 4 bit codes, the first move in the least significant bits.
+// Transforms that don't fit are packed with the half turn encoding, and panic if they can't be
+func encodeTransform(transform string) uint64 {
+	encoded, ok := tryEncodeTransform(transform)
+	if !ok {
+		// packing it anyway would silently lose the first moves
+		panic(fmt.Sprintf("the transform %q is too long to encode", transform))
+	}
+	return encoded
+}
+
+// tryEncodeTransform is encodeTransform, but false for transforms too long to encode
+func tryEncodeTransform(transform string) (uint64, bool) {
+	moves := cube.SplitTransform(transform)
+	nibbles := 0
+	for _, move := range moves {
+		nibbles += 1
+		if TransformToInt[move] > 0xF {
+			nibbles += 1
+		}
+	}
+	if nibbles > 16 {
+		return encodeHalfTurnTransform(moves)
+	}
+	encodedTransform := uint64(0)
+	for i := range moves {
+		code := TransformToInt[moves[len(moves)-1-i]]
+		if code > 0xF {
+			encodedTransform = encodedTransform << 8
+		} else {
+			encodedTransform = encodedTransform << 4
+		}
+		encodedTransform += code
+	}
+	return encodedTransform, true
+}
+
+func decodeTransform(encodedTransform uint64) string {
+	if encodedTransform&0xF == halfTurnEscape {
+		return decodeHalfTurnTransform(encodedTransform >> 4)
+	}
+	solution := ""
+	for i := 0; i < 16; i++ {
+		code := encodedTransform & 0xF // Extract the 4 least significant bits
+		if code == 0 {
+			break
+		}
+		if code == sliceEscape || code == wideEscape {
+			encodedTransform >>= 4
+			i++
+			code |= (encodedTransform & 0xF) << 4
+		}
+		solution += IntToTransform[code]
+		encodedTransform >>= 4
+	}
+	return solution
+}
+
+// The half turn encoding starts with halfTurnEscape and stores face turns only, with turns of the
+// same face combined. The first move is its face and then its turn, 0 for clockwise, 1 for a half
+// turn and 2 for anticlockwise, each plus one. Every later move is on one of the 5 faces other
+// than the one before it, so it's stored in a single nibble as 1 + 3*face + turn with the faces
+// numbered leaving out the last one. This fits half turn metric solutions of up to 14 moves
+
+const halfTurnEscape = 15
+const halfTurnEncodingMoves = 14
+
+// halfTurnFaces are the faces in the order of their codes in TransformToInt
+const halfTurnFaces = "FLUBRD"
+
+// halfTurnMoves combines the face turns of a transform into a face and turn per move
+func halfTurnMoves(moves []string) (faces, turns []int, ok bool) {
+	for _, move := range moves {
+		face := strings.IndexRune(halfTurnFaces, unicode.ToUpper(rune(move[0])))
+		if len(move) != 1 || face < 0 {
+			return nil, nil, false
+		}
+		quarterTurns := 1
+		if unicode.IsLower(rune(move[0])) {
+			quarterTurns = 3
+		}
+		if last := len(faces) - 1; last >= 0 && faces[last] == face {
+			turns[last] = (turns[last] + quarterTurns) % 4
+			if turns[last] == 0 {
+				faces, turns = faces[:last], turns[:last]
+			}
+			continue
+		}
+		faces, turns = append(faces, face), append(turns, quarterTurns)
+	}
+	return faces, turns, true
+}
+
+func encodeHalfTurnTransform(moves []string) (uint64, bool) {
+	faces, turns, ok := halfTurnMoves(moves)
+	if !ok || len(faces) > halfTurnEncodingMoves {
+		return 0, false
+	}
+	var codes []uint64
+	for i, face := range faces {
+		turn := uint64(turns[i] - 1) // quarter turns of 1, 2 and 3 are turns 0, 1 and 2
+		if i == 0 {
+			codes = append(codes, uint64(face+1), turn+1)
+			continue
+		}
+		relativeFace := face
+		if face > faces[i-1] {
+			relativeFace -= 1
+		}
+		codes = append(codes, 1+3*uint64(relativeFace)+turn)
+	}
+	encodedTransform := uint64(0)
+	for i := len(codes) - 1; i >= 0; i-- {
+		encodedTransform = encodedTransform<<4 | codes[i]
+	}
+	return encodedTransform<<4 | halfTurnEscape, true
+}
+
+// decodeHalfTurnTransform decodes the moves after halfTurnEscape, writing half turns as two quarter turns
+func decodeHalfTurnTransform(encodedTransform uint64) string {
+	solution := strings.Builder{}
+	writeMove := func(face int, turn uint64) {
+		move := string(halfTurnFaces[face])
+		switch turn {
+		case 0:
+			solution.WriteString(move)
+		case 1:
+			solution.WriteString(move + move)
+		case 2:
+			solution.WriteString(strings.ToLower(move))
+		}
+	}
+	if encodedTransform == 0 {
+		return ""
+	}
+	lastFace := int(encodedTransform&0xF) - 1
+	writeMove(lastFace, (encodedTransform>>4&0xF)-1)
+	for encodedTransform >>= 8; encodedTransform != 0; encodedTransform >>= 4 {
+		code := encodedTransform&0xF - 1
+		face := int(code / 3)
+		if face >= lastFace {
+			face += 1
+		}
+		writeMove(face, code%3)
+		lastFace = face
+	}
+	return solution.String()
+}
+
+func cubeWorker(backend cube.Backend, encoding cube.Encoding, transforms <-chan indexedTransform, resultChan chan<- cubeResult, stop <-chan interface{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for {
 		select {
 		case <-stop:
 			return
-		case generatorResult := <-generatorResult:
-			c := cube.NewSolvedCube()
+		case next := <-transforms:
+			generatorResult := next.transform
+			c := backend.NewSolved()
 
 			c.Transform(generatorResult)
 
-			id, rotationTransform := c.EncodeCube()
+			id, rotationTransform := encoding.Encode(c)
 
 			// encode the reverse of the transform
 			transform := cube.RotateTransform(cube.ReverseTransform(rotationTransform), cube.ReverseTransform(generatorResult))
-			encodedTransform := uint64(0)
-			for i := range transform {
-				encodedTransform = encodedTransform << 4
-				c := rune(transform[len(transform)-1-i])
-				encodedTransform += TransformToInt[c]
-			}
-
 			resultChan <- cubeResult{
+				index:     next.index,
 				id:        id,
-				transform: encodedTransform,
+				transform: encodeTransform(transform),
 			}
 		}
 	}
@@ -173,69 +464,80 @@ type batchResults struct {
 	results       map[uint128.Uint128]uint64
 	transformNo   int
 	lastTransform []int // it's a waste of time encoding this value, just use "," separated list
+	depth         int   // the depth the batch was generated at
+	states        int
+	finishedDepth bool // whether the batch is the last of its depth
+	batchSize     int  // the batch size when the batch was made, it's cut short at the end of a depth
+}
+
+type saveResult struct {
+	success  bool
+	diskFull bool // the disk is below the free space threshold, the batch was still saved
+	states   int  // size of the batch saved
+	latency  time.Duration
+}
+
+// diskStore is a store saved to disk, which the disk guard stops from filling the disk
+type diskStore interface {
+	Path() string
+}
+
+// asDiskStore is the store, or the store a bloom filter is in front of, if it's saved to disk
+func asDiskStore(store SolutionStore) (diskStore, bool) {
+	if filtered, isFiltered := store.(*FilteredStore); isFiltered {
+		store = filtered.SolutionStore
+	}
+	disk, onDisk := store.(diskStore)
+	return disk, onDisk
 }
 
-// closes db connection when stopping
-func saveWorker(db DBConnection, dbSaveChan chan batchResults, dbSaveChanResult chan bool, wg *sync.WaitGroup) {
+// closes the store when stopping
+func saveWorker(store SolutionStore, progress *ProgressReporter, counter *depthCounter, guard *diskGuard, metric cube.Metric, stop <-chan struct{}, dbSaveChan chan batchResults, dbSaveChanResult chan saveResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	lastStackSize := 0
+	depthCubes := 0
 
 	for {
 		toSave := <-dbSaveChan
 
 		if toSave.results == nil {
-			db.Close()
+			store.Close()
 			return
 		}
 
-		fmt.Print("\033[1A\x1b[2K")
-
 		b := make([]string, len(toSave.lastTransform))
 		for i, stackElement := range toSave.lastTransform {
 			b[i] = strconv.Itoa(stackElement)
 		}
-		encodedStack := strings.Join(b, ",")
+		checkpoint := Checkpoint{NextNum: toSave.transformNo, EncodedStack: strings.Join(b, ",")}
 
-		fmt.Printf("Saving: %d, Stack: %s Estimated Layer:%.2f%%\n", toSave.transformNo, encodedStack, estimateStackPercentage(toSave.lastTransform))
+		saveStart := time.Now()
+		success := store.Save(toSave.results, checkpoint)
+		latency := time.Since(saveStart)
 
-		if len(toSave.lastTransform) != lastStackSize {
-			lastStackSize = len(toSave.lastTransform)
-			fmt.Print("\033[1A\x1b[2K")
-			fmt.Printf("Stack %d: %f%%\n\n", lastStackSize, getDiskUsePercentage(db.path))
+		// cubes repeated in different batches are only saved once, so the depth has at most this many
+		depthCubes += len(toSave.results)
+		depthProgress := 1.0
+		if !toSave.finishedDepth {
+			depthProgress = counter.progress(toSave.lastTransform)
 		}
-
-		success := db.Save(toSave.results, toSave.transformNo, encodedStack)
-		if getDiskUsePercentage(db.path) > 99.9 {
-			fmt.Println("Disk low on space")
-			dbSaveChanResult <- false
-		} else {
-			dbSaveChanResult <- success
+		progress.Batch(BatchProgress{
+			Depth:         toSave.depth,
+			States:        toSave.states,
+			Unique:        len(toSave.results),
+			Progress:      depthProgress,
+			Checkpoint:    checkpoint,
+			BatchSize:     toSave.batchSize,
+			CommitLatency: latency,
+		})
+		if toSave.finishedDepth {
+			progress.DepthDone(toSave.depth, depthCubes)
+			guard.depthDone(toSave.depth, metric, progress)
+			depthCubes = 0
 		}
-	}
-}
 
-func getDiskUsePercentage(dbPath string) float64 {
-	var stat unix.Statfs_t
-	err := unix.Statfs(path.Dir(dbPath), &stat)
-	if err != nil {
-		fmt.Printf("Error getting disk info: %s\n\n", err)
-		return -1
-	} else {
-		return 100 * (1 - float64(stat.Bavail)/float64(stat.Blocks))
+		// generation waits here while the guard is paused
+		diskFull := success && !guard.check(progress, stop)
+		dbSaveChanResult <- saveResult{success: success, diskFull: diskFull, states: toSave.states, latency: latency}
 	}
 }
-
-func estimateStackPercentage(stack []int) float64 {
-	if len(stack) == 0 {
-		return 0
-	}
-
-	res := float64(stack[0]) * 0.5
-
-	for i := 1; i < len(stack); i++ {
-		res += 0.5 * float64(stack[i]) / math.Pow(11, float64(i))
-	}
-
-	return res * 100
-}
@@ -4,9 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"github.com/matthewjackswann/rubiks/cube"
+	"io"
 	"math/rand"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func getLastFullLayer(stack string) int {
@@ -24,7 +28,7 @@ func getDBConnectionStringFromFlags(t *testing.T) string {
 	return *dbConnString
 }
 
-func checkTransformInverseExists(t *testing.T, moves string, db DBConnection, maxLength int) {
+func checkTransformInverseExists(t *testing.T, moves string, db *DBConnection, maxLength int) {
 	c := cube.NewSolvedCube()
 	c.Transform(moves)
 
@@ -48,7 +52,7 @@ func TestOneMoveCubes(t *testing.T) {
 	}
 	db := CreateDBConnection(dbString)
 
-	if getLastFullLayer(db.GetNextTransforms().EncodedStack) < 1 {
+	if getLastFullLayer(db.GetCheckpoint().EncodedStack) < 1 {
 		t.Skip("Layer 1 is not in the database")
 	}
 
@@ -66,7 +70,7 @@ func TestTwoMoveCubes(t *testing.T) {
 	}
 	db := CreateDBConnection(dbString)
 
-	if getLastFullLayer(db.GetNextTransforms().EncodedStack) < 2 {
+	if getLastFullLayer(db.GetCheckpoint().EncodedStack) < 2 {
 		t.Skip("Layer 2 is not in the database")
 	}
 
@@ -86,7 +90,7 @@ func TestThreeMoveCubes(t *testing.T) {
 	}
 	db := CreateDBConnection(dbString)
 
-	if getLastFullLayer(db.GetNextTransforms().EncodedStack) < 3 {
+	if getLastFullLayer(db.GetCheckpoint().EncodedStack) < 3 {
 		t.Skip("Layer 3 is not in the database")
 	}
 
@@ -108,7 +112,7 @@ func TestFourMoveCubes(t *testing.T) {
 	}
 	db := CreateDBConnection(dbString)
 
-	if getLastFullLayer(db.GetNextTransforms().EncodedStack) < 4 {
+	if getLastFullLayer(db.GetCheckpoint().EncodedStack) < 4 {
 		t.Skip("Layer 4 is not in the database")
 	}
 
@@ -132,7 +136,7 @@ func TestFiveMoveCubes(t *testing.T) {
 	}
 	db := CreateDBConnection(dbString)
 
-	if getLastFullLayer(db.GetNextTransforms().EncodedStack) < 5 {
+	if getLastFullLayer(db.GetCheckpoint().EncodedStack) < 5 {
 		t.Skip("Layer 5 is not in the database")
 	}
 
@@ -159,7 +163,7 @@ func TestOneMillionCubes(t *testing.T) {
 	db := CreateDBConnection(dbString)
 	rand.Seed(0)
 
-	stringLength := getLastFullLayer(db.GetNextTransforms().EncodedStack)
+	stringLength := getLastFullLayer(db.GetCheckpoint().EncodedStack)
 
 	for i := 0; i < 1000000; i++ {
 		if i%100000 == 0 {
@@ -181,10 +185,9 @@ func TestOneMillionCubesThreaded(t *testing.T) {
 		return
 	}
 	db := CreateDBConnection(dbString)
-	stringLength := getLastFullLayer(db.GetNextTransforms().EncodedStack)
-	db.Close()
+	stringLength := getLastFullLayer(db.GetCheckpoint().EncodedStack)
 
-	parallelLookup := CreateLookupWorkers(64, 8, db.path)
+	parallelLookup := CreateLookupWorkers(64, 8, db)
 	requestChan := parallelLookup.requestChan
 	resultsChan := parallelLookup.resultsChan
 
@@ -228,6 +231,7 @@ func TestOneMillionCubesThreaded(t *testing.T) {
 	}
 
 	parallelLookup.Stop()
+	db.Close()
 }
 
 func generateRandomCubeWithSolutionLength(stringLength int) (string, *cube.Cube) {
@@ -248,7 +252,7 @@ func TestLayerPlus2(t *testing.T) {
 	db := CreateDBConnection(dbString)
 	rand.Seed(2)
 
-	stringLength := getLastFullLayer(db.GetNextTransforms().EncodedStack) + 2
+	stringLength := getLastFullLayer(db.GetCheckpoint().EncodedStack) + 2
 
 	for i := 0; i < 10000; i++ {
 		cubeSetup := ""
@@ -259,7 +263,7 @@ func TestLayerPlus2(t *testing.T) {
 		c := cube.NewSolvedCube()
 		c.Transform(cubeSetup)
 
-		solution, solFound := db.SolveCubeBySearch(c, 6, 2)
+		solution, solFound := SolveCubeBySearch(db, c, 6, 2)
 		if !solFound {
 			t.Errorf("Cube with setup %s should have a solution within two moves in the DB", cubeSetup)
 		}
@@ -280,7 +284,7 @@ func TestLayerPlus5(t *testing.T) {
 	db := CreateDBConnection(dbString)
 	rand.Seed(3)
 
-	stringLength := getLastFullLayer(db.GetNextTransforms().EncodedStack) + 5
+	stringLength := getLastFullLayer(db.GetCheckpoint().EncodedStack) + 5
 
 	for i := 0; i < 10; i++ {
 		cubeSetup := ""
@@ -291,7 +295,7 @@ func TestLayerPlus5(t *testing.T) {
 		c := cube.NewSolvedCube()
 		c.Transform(cubeSetup)
 
-		solution, solFound := db.SolveCubeBySearch(c, 6, 5)
+		solution, solFound := SolveCubeBySearch(db, c, 6, 5)
 		if !solFound {
 			t.Errorf("Cube with setup %s should have a solution within five moves in the DB", cubeSetup)
 		}
@@ -303,3 +307,183 @@ func TestLayerPlus5(t *testing.T) {
 
 	db.Close()
 }
+
+func TestEncodeTransform(t *testing.T) {
+	tests := []string{"", "F", "fLUdbR", "MRwEu", "XyzSsmM", "FFFFFFFFFFFFFFFF", "lwdwFwbwUwRwRw"}
+	for _, test := range tests {
+		if decoded := decodeTransform(encodeTransform(test)); decoded != test {
+			t.Errorf("%s was decoded as %s", test, decoded)
+		}
+	}
+	if encodeTransform("Fl") != 0x41 {
+		t.Errorf("Face turns should be encoded one nibble per move with the first move least significant")
+	}
+}
+
+func TestEncodeTransform_HalfTurns(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	faces := []string{"F", "L", "U", "B", "R", "D"}
+	for i := 0; i < 200; i++ {
+		// a half turn metric solution, no face is turned twice in a row
+		transform, last := "", -1
+		for move := 0; move < MaxEncodedDepth(cube.HalfTurnMetric); move++ {
+			face := r.Intn(len(faces))
+			for face == last {
+				face = r.Intn(len(faces))
+			}
+			last = face
+			switch r.Intn(3) {
+			case 0:
+				transform += faces[face]
+			case 1:
+				transform += faces[face] + faces[face]
+			case 2:
+				transform += strings.ToLower(faces[face])
+			}
+		}
+		decoded := decodeTransform(encodeTransform(transform))
+		if decoded != transform {
+			t.Errorf("%s was decoded as %s", transform, decoded)
+		}
+		if length := cube.HalfTurnMetric.Length(decoded); length != MaxEncodedDepth(cube.HalfTurnMetric) {
+			t.Errorf("%s should be %d half turn metric moves but was %d", decoded, MaxEncodedDepth(cube.HalfTurnMetric), length)
+		}
+	}
+	// turns of the same face are combined, so a quarter turn metric solution that's too long still decodes to the same cube
+	transform := "FFFRUUUbDDLLLLLlBBfRUUr"
+	c, d := cube.NewSolvedCube(), cube.NewSolvedCube()
+	c.Transform(transform)
+	d.Transform(decodeTransform(encodeTransform(transform)))
+	if c.Layout != d.Layout {
+		t.Errorf("%s was decoded as %s, which gives a different cube", transform, decodeTransform(encodeTransform(transform)))
+	}
+}
+
+func TestEncodeTransform_TooLong(t *testing.T) {
+	// wide moves can't use the half turn encoding, so this doesn't fit in 16 nibbles
+	transform := "FRUBLDFRUBLDFRUlw"
+	if _, ok := tryEncodeTransform(transform); ok {
+		t.Errorf("%s shouldn't fit in an encoded transform", transform)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Encoding %s should panic rather than drop moves", transform)
+		}
+	}()
+	encodeTransform(transform)
+}
+
+func generateWithWorkers(store *MemoryStore, depth, workers int) {
+	generateWithLimit(store, depth, workers, 0)
+}
+
+func generateWithLimit(store *MemoryStore, depth, workers, maxStates int) {
+	checkpoint := store.GetCheckpoint()
+	var stack []int
+	for _, s := range strings.Split(checkpoint.EncodedStack, ",") {
+		n, _ := strconv.Atoi(s)
+		stack = append(stack, n)
+	}
+	StartSolutionGenerator(store, stack, checkpoint.NextNum, GeneratorConfig{
+		MaximumDepth: depth,
+		Metric:       cube.QuarterTurnMetric,
+		Backend:      cube.StickerBackend,
+		Workers:      workers,
+		MaxStates:    maxStates,
+		Progress:     NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, ""),
+	})
+}
+
+func TestStartSolutionGenerator_Workers(t *testing.T) {
+	single := NewMemoryStore()
+	generateWithWorkers(single, 4, 1)
+
+	parallel := NewMemoryStore()
+	generateWithWorkers(parallel, 4, 4)
+
+	// stopping part way and carrying on with a different number of workers
+	resumed := NewMemoryStore()
+	generateWithWorkers(resumed, 2, 3)
+	generateWithWorkers(resumed, 4, 2)
+
+	// small batches that don't line up with the depths
+	batched := NewMemoryStore()
+	StartSolutionGenerator(batched, []int{0}, 0, GeneratorConfig{
+		MaximumDepth: 4,
+		Metric:       cube.QuarterTurnMetric,
+		Backend:      cube.StickerBackend,
+		Workers:      3,
+		BatchSize:    37,
+		Progress:     NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, ""),
+	})
+
+	for name, store := range map[string]*MemoryStore{"parallel": parallel, "resumed": resumed, "batched": batched} {
+		if store.Count() != single.Count() {
+			t.Errorf("The %s generator saved %d cubes rather than %d", name, store.Count(), single.Count())
+		}
+		for id, solution := range single.solutions {
+			if store.solutions[id] != solution {
+				t.Errorf("The %s generator saved %s for %v rather than %s", name, decodeTransform(store.solutions[id]), id, decodeTransform(solution))
+			}
+		}
+	}
+}
+
+func TestStartSolutionGenerator_MaxStates(t *testing.T) {
+	full := NewMemoryStore()
+	generateWithWorkers(full, 4, 2)
+
+	limited := NewMemoryStore()
+	generateWithLimit(limited, 4, 1, 500)
+	checkpoint := limited.GetCheckpoint()
+	if checkpoint.NextNum < 500 || checkpoint.NextNum >= full.GetCheckpoint().NextNum {
+		t.Fatalf("The generator should have stopped after 500 states but got to %d", checkpoint.NextNum)
+	}
+	for limited.GetCheckpoint().NextNum < full.GetCheckpoint().NextNum {
+		generateWithLimit(limited, 4, 1, 500)
+	}
+	if limited.Count() != full.Count() {
+		t.Errorf("Generating in windows saved %d cubes rather than %d", limited.Count(), full.Count())
+	}
+}
+
+func TestLimitReached(t *testing.T) {
+	if reason := limitReached(0, 0, time.Hour, 1000); reason != "" {
+		t.Errorf("No limits shouldn't stop the generator, got %s", reason)
+	}
+	if reason := limitReached(time.Minute, 0, time.Hour, 1000); reason == "" {
+		t.Errorf("Running past the maximum duration should stop the generator")
+	}
+	if reason := limitReached(time.Minute, 2000, time.Second, 1000); reason != "" {
+		t.Errorf("Being within both limits shouldn't stop the generator, got %s", reason)
+	}
+	if reason := limitReached(0, 1000, time.Second, 1000); reason == "" {
+		t.Errorf("Reaching the maximum states should stop the generator")
+	}
+}
+
+func BenchmarkCubeWorker(b *testing.B) {
+	r := rand.New(rand.NewSource(3))
+	transforms := make([]string, 1024)
+	for i := range transforms {
+		transforms[i] = randomScramble(r, 10)
+	}
+	next := make(chan indexedTransform, channelBufferSize)
+	results := make(chan cubeResult, channelBufferSize)
+	stop := make(chan interface{})
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go cubeWorker(cube.StickerBackend, cube.RotationEncoding, next, results, stop, wg)
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			next <- indexedTransform{index: i, transform: transforms[i%len(transforms)]}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-results
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
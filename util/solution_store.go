@@ -0,0 +1,147 @@
+package util
+
+import (
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"sync"
+)
+
+// Checkpoint is how far the generator got, so it can carry on where it stopped
+type Checkpoint struct {
+	NextNum      int
+	EncodedStack string
+}
+
+var initialCheckpoint = Checkpoint{NextNum: 0, EncodedStack: "0"}
+
+// SolutionStore holds the solutions of cubes by id, along with the generator's progress.
+// Solutions are for the cube in its id orientation, LookupCube rotates them to match a cube
+type SolutionStore interface {
+	Lookup(cubeId uint128.Uint128) (string, bool)
+	// BatchLookup looks up many cubes at once, found[i] is whether cubeIds[i] has a solution
+	BatchLookup(cubeIds []uint128.Uint128) (solutions []string, found []bool)
+	// Save stores the encoded solutions and the checkpoint together, keeping the first solution saved for each cube
+	Save(results map[uint128.Uint128]uint64, checkpoint Checkpoint) bool
+	GetCheckpoint() Checkpoint
+	SetCheckpoint(checkpoint Checkpoint) bool
+	GetMetric() cube.Metric
+	SetMetric(metric cube.Metric) bool
+	// GetEncoding is which symmetries of a cube share its id, cubes must be encoded with it to be looked up
+	GetEncoding() cube.Encoding
+	SetEncoding(encoding cube.Encoding) bool
+	IsEmpty() bool
+	Close()
+}
+
+// LookupCube is used to find the solution for a single cube if it exists in the store
+func LookupCube(store SolutionStore, cubeId uint128.Uint128, rotation string) (string, bool) {
+	if cubeId.Equals(cube.SolvedCubeId) {
+		return "", true
+	}
+	idSolution, success := store.Lookup(cubeId)
+	if !success {
+		return "", false
+	}
+	return cube.RotateTransform(rotation, idSolution), true
+}
+
+// MemoryStore keeps everything in a map, it's useful for tests and small tables
+type MemoryStore struct {
+	lock       sync.RWMutex
+	solutions  map[uint128.Uint128]uint64
+	checkpoint Checkpoint
+	metric     cube.Metric
+	encoding   cube.Encoding
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		solutions:  make(map[uint128.Uint128]uint64),
+		checkpoint: initialCheckpoint,
+	}
+}
+
+func (store *MemoryStore) Lookup(cubeId uint128.Uint128) (string, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	solution, found := store.solutions[cubeId]
+	if !found {
+		return "", false
+	}
+	return decodeTransform(solution), true
+}
+
+func (store *MemoryStore) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	solutions := make([]string, len(cubeIds))
+	found := make([]bool, len(cubeIds))
+	for i, cubeId := range cubeIds {
+		solutions[i], found[i] = store.Lookup(cubeId)
+	}
+	return solutions, found
+}
+
+func (store *MemoryStore) Save(results map[uint128.Uint128]uint64, checkpoint Checkpoint) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for cubeId, transform := range results {
+		if _, exists := store.solutions[cubeId]; !exists {
+			store.solutions[cubeId] = transform
+		}
+	}
+	store.checkpoint = checkpoint
+	return true
+}
+
+func (store *MemoryStore) GetCheckpoint() Checkpoint {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.checkpoint
+}
+
+func (store *MemoryStore) SetCheckpoint(checkpoint Checkpoint) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.checkpoint = checkpoint
+	return true
+}
+
+func (store *MemoryStore) GetMetric() cube.Metric {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.metric
+}
+
+func (store *MemoryStore) GetEncoding() cube.Encoding {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.encoding
+}
+
+func (store *MemoryStore) SetEncoding(encoding cube.Encoding) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.encoding = encoding
+	return true
+}
+
+func (store *MemoryStore) SetMetric(metric cube.Metric) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.metric = metric
+	return true
+}
+
+func (store *MemoryStore) IsEmpty() bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return len(store.solutions) == 0
+}
+
+// Count is the number of cubes in the store
+func (store *MemoryStore) Count() int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return len(store.solutions)
+}
+
+func (store *MemoryStore) Close() {}
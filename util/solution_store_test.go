@@ -0,0 +1,371 @@
+package util
+
+import (
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"github.com/matthewjackswann/rubiks/notation"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	if !store.IsEmpty() || store.GetCheckpoint() != initialCheckpoint {
+		t.Errorf("A new store should be empty and at the start")
+	}
+
+	id := uint128.Uint128{H: 1, L: 2}
+	store.Save(map[uint128.Uint128]uint64{id: encodeTransform("FR")}, Checkpoint{NextNum: 5, EncodedStack: "0,1"})
+	store.Save(map[uint128.Uint128]uint64{id: encodeTransform("LLL")}, Checkpoint{NextNum: 9, EncodedStack: "0,2"})
+	if solution, found := store.Lookup(id); !found || solution != "FR" {
+		t.Errorf("The first solution saved should be kept but got %s", solution)
+	}
+	if checkpoint := store.GetCheckpoint(); checkpoint.NextNum != 9 || checkpoint.EncodedStack != "0,2" {
+		t.Errorf("The checkpoint should be from the last save but was %v", checkpoint)
+	}
+
+	solutions, found := store.BatchLookup([]uint128.Uint128{id, {H: 3, L: 4}})
+	if !found[0] || solutions[0] != "FR" || found[1] {
+		t.Errorf("Batch lookup gave %v %v", solutions, found)
+	}
+}
+
+// generateMemoryStore fills a store with every cube up to depth moves from solved
+func generateMemoryStore(t testing.TB, depth int) *MemoryStore {
+	store := NewMemoryStore()
+	StartSolutionGenerator(store, []int{0}, 0, GeneratorConfig{
+		MaximumDepth: depth,
+		Metric:       cube.QuarterTurnMetric,
+		Backend:      cube.StickerBackend,
+		Progress:     NewProgressReporter(io.Discard, ProgressJSON, cube.QuarterTurnMetric, cube.RotationEncoding, ""),
+	})
+	if store.IsEmpty() {
+		t.Fatal("The generator didn't save any cubes")
+	}
+	return store
+}
+
+func randomScramble(r *rand.Rand, length int) string {
+	moves := []string{"f", "F", "u", "U", "l", "L", "r", "R", "b", "B", "d", "D"}
+	scramble := ""
+	for i := 0; i < length; i++ {
+		scramble += moves[r.Intn(len(moves))]
+	}
+	return scramble
+}
+
+func TestStartSolutionGenerator_MemoryStore(t *testing.T) {
+	store := generateMemoryStore(t, 3)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 200; i++ {
+		scramble := randomScramble(r, r.Intn(4))
+		c := cube.NewSolvedCube()
+		c.Transform(scramble)
+		id, rotation := c.EncodeCube()
+		solution, found := LookupCube(store, id, rotation)
+		if !found {
+			t.Errorf("Cube with setup %s should be in the store", scramble)
+			continue
+		}
+		if len(solution) > len(scramble) {
+			t.Errorf("Solution %s is longer than setup %s", solution, scramble)
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve setup %s", solution, scramble)
+		}
+	}
+}
+
+func TestSolveCubeBySearch_MemoryStore(t *testing.T) {
+	store := generateMemoryStore(t, 3)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		scramble := randomScramble(r, 5)
+		c := cube.NewSolvedCube()
+		c.Transform(scramble)
+		solution, found := SolveCubeBySearch(store, c, 4, 2)
+		if !found {
+			t.Errorf("Cube with setup %s should be solved within two moves of the store", scramble)
+			continue
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve setup %s", solution, scramble)
+		}
+	}
+}
+
+// saveSolution stores solution, which solves c, under the id of c as the generator would
+func saveSolution(store SolutionStore, c *cube.Cube, solution string) {
+	id, rotation := store.GetEncoding().Encode(c)
+	store.Save(map[uint128.Uint128]uint64{id: encodeTransform(cube.RotateTransform(cube.ReverseTransform(rotation), solution))}, initialCheckpoint)
+}
+
+func TestInverseMayHelp(t *testing.T) {
+	store := NewMemoryStore()
+	if !inverseMayHelp(store) {
+		t.Errorf("A store that doesn't know its depth may be missing inverses")
+	}
+	store.SetCheckpoint(Checkpoint{NextNum: 4056, EncodedStack: "0,2,4,7,2"})
+	if !inverseMayHelp(store) {
+		t.Errorf("A store part way through a depth may be missing inverses")
+	}
+	store.SetCheckpoint(frontierCheckpoint(4))
+	if inverseMayHelp(store) {
+		t.Errorf("A store that finished its last depth has the inverse of every cube")
+	}
+
+	// a table file knows the depth of the database it was converted from
+	dir := t.TempDir()
+	db := CreateDBConnection(filepath.Join(dir, "cubes.db"))
+	defer db.Close()
+	db.SetCheckpoint(frontierCheckpoint(4))
+	path := filepath.Join(dir, "cubes.table")
+	if err := ConvertToTableFile(db, path); err != nil {
+		t.Fatal(err)
+	}
+	table, err := OpenTableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	if table.GetCheckpoint() != frontierCheckpoint(4) || inverseMayHelp(table) {
+		t.Errorf("A table converted from a finished depth has the inverse of every cube, got checkpoint %v", table.GetCheckpoint())
+	}
+}
+
+func TestLookupWorkerRequest_Prepare(t *testing.T) {
+	c := cube.NewSolvedCube()
+	c.Transform("FR")
+	request := &lookupWorkerRequest{cube: c, transform: "U", inverse: true}
+	inverse, ok := request.prepare()
+	if !ok {
+		t.Fatalf("The inverse of FRU should be taken")
+	}
+	inverse.Transform("FRU")
+	if !inverse.IsSolved() {
+		t.Errorf("The inverse of FRU should be solved by FRU")
+	}
+
+	// centres that aren't a rotation of solved can't be inverted, so it's a miss without a lookup
+	layout := cube.NewSolvedCube().Layout
+	layout[4], layout[22] = layout[22], layout[4]
+	request = &lookupWorkerRequest{cube: cube.NewCube(layout), inverse: true}
+	if _, ok := request.prepare(); ok {
+		t.Errorf("A cube with swapped centres shouldn't have an inverse to look up")
+	}
+	store := &countingStore{SolutionStore: NewMemoryStore(), probes: make(map[uint128.Uint128]int)}
+	lookup := CreateBatchLookupWorkers(1, 1, store)
+	defer lookup.Stop()
+	lookup.requestChan <- []*lookupWorkerRequest{request}
+	responses := <-lookup.resultsChan
+	if responses[0].success || len(store.probes) != 0 {
+		t.Errorf("A cube without an inverse should be a miss without being looked up, looked up %d ids", len(store.probes))
+	}
+}
+
+func TestSolveCubeBySearch_Inverse(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		// the store only has the inverse of the scramble, so the cube can only be solved through it
+		scramble := randomScramble(r, 6)
+		inverse := cube.NewSolvedCube()
+		inverse.Transform(cube.ReverseTransform(scramble))
+		for _, backend := range []cube.Backend{cube.StickerBackend, cube.CubieBackend} {
+			store := NewMemoryStore()
+			saveSolution(store, inverse, scramble)
+			for _, setup := range []string{"", "Fr"} {
+				c := backend.NewSolved()
+				c.Transform(scramble + setup)
+				solution, found := SolveCubeBySearch(store, c, 2, 2)
+				if !found {
+					t.Errorf("Cube with setup %s should be solved through the inverse of %s", scramble+setup, scramble)
+					continue
+				}
+				c.Transform(solution)
+				if !c.IsSolved() {
+					t.Errorf("Solution %s doesn't solve setup %s", solution, scramble+setup)
+				}
+			}
+		}
+	}
+}
+
+// countingStore counts how many times each id is looked up
+type countingStore struct {
+	SolutionStore
+	lock   sync.Mutex
+	probes map[uint128.Uint128]int
+}
+
+func (store *countingStore) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	store.lock.Lock()
+	for _, cubeId := range cubeIds {
+		store.probes[cubeId] += 1
+	}
+	store.lock.Unlock()
+	return store.SolutionStore.BatchLookup(cubeIds)
+}
+
+func TestSolveCubeBySearch_ProbesOnce(t *testing.T) {
+	store := &countingStore{SolutionStore: NewMemoryStore(), probes: make(map[uint128.Uint128]int)}
+	c := cube.NewSolvedCube()
+	c.Transform("FRUbLdFFrDD")
+	if _, found := SolveCubeBySearch(store, c, 4, 2); found {
+		t.Fatal("An empty store can't solve the cube")
+	}
+	// UD and DU reach the same cube, as do the inverse of U and u, so there are far fewer ids than transforms
+	if len(store.probes) == 0 || len(store.probes) >= 2*(1+12+12*12) {
+		t.Errorf("Expected repeated cubes to be skipped but %d ids were looked up", len(store.probes))
+	}
+	for id, probes := range store.probes {
+		if probes != 1 {
+			t.Errorf("Cube %v was looked up %d times", id, probes)
+		}
+	}
+}
+
+func TestSolveCubeBySearchAll(t *testing.T) {
+	store := generateMemoryStore(t, 3)
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 10; i++ {
+		scramble := randomScramble(r, 5)
+		c := cube.NewSolvedCube()
+		c.Transform(scramble)
+		shortest, _ := SolveCubeBySearch(store, c, 4, 2)
+		solutions, count, err := SolveCubeBySearchAll(store, c, 4, 2, 0, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(solutions) == 0 || count != len(solutions) {
+			t.Errorf("Expected every solution of %s but got %d of %d", scramble, len(solutions), count)
+			continue
+		}
+		seen := make(map[string]bool)
+		lastCost := 0
+		for _, solution := range solutions {
+			d := cube.NewCube(c.Layout)
+			d.Transform(solution)
+			if !d.IsSolved() {
+				t.Errorf("Solution %s doesn't solve setup %s", solution, scramble)
+			}
+			if length := cube.QuarterTurnMetric.Length(solution); length != cube.QuarterTurnMetric.Length(shortest) {
+				t.Errorf("Solution %s of %s should be as short as %s", solution, scramble, shortest)
+			}
+			moves, _ := notation.FromTransform(solution)
+			if cost := notation.ErgonomicCost(moves); cost < lastCost {
+				t.Errorf("Solution %s of %s is easier than the one before it", solution, scramble)
+			} else {
+				lastCost = cost
+			}
+			if seen[solution] {
+				t.Errorf("Solution %s of %s is repeated", solution, scramble)
+			}
+			seen[solution] = true
+		}
+		if limited, limitedCount, _ := SolveCubeBySearchAll(store, c, 4, 2, 1, time.Minute); len(limited) != 1 || limited[0] != solutions[0] || limitedCount != count {
+			t.Errorf("A limit of 1 should give the easiest of the %d solutions but gave %v of %d", count, limited, limitedCount)
+		}
+	}
+}
+
+func TestSolveCubeBySearchAll_Timeout(t *testing.T) {
+	store := NewMemoryStore()
+	c := cube.NewSolvedCube()
+	c.Transform("FRUbLdFFrDD")
+	if _, _, err := SolveCubeBySearchAll(store, c, 2, 6, 0, time.Millisecond); err != ErrSearchTimeout {
+		t.Errorf("Searching an empty store to depth 6 should time out, got %v", err)
+	}
+}
+
+func TestProbeCache_Clear(t *testing.T) {
+	store := &countingStore{SolutionStore: NewMemoryStore(), probes: make(map[uint128.Uint128]int)}
+	cache := newProbeCache(store)
+	ids := []uint128.Uint128{{H: 1, L: 2}, {H: 3, L: 4}, {H: 1, L: 2}}
+	cache.BatchLookup(ids)
+	cache.BatchLookup(ids)
+	if store.probes[ids[0]] != 1 || store.probes[ids[1]] != 1 {
+		t.Errorf("Each id should be looked up once until the cache is cleared, got %v", store.probes)
+	}
+	cache.clear()
+	cache.BatchLookup(ids)
+	if len(cache.results) != 2 || store.probes[ids[0]] != 2 {
+		t.Errorf("A cleared cache should look ids up again, got %v", store.probes)
+	}
+}
+
+func TestSolveCubeBySearchAll_Commuting(t *testing.T) {
+	store := generateMemoryStore(t, 1)
+	tests := []struct {
+		setup     string
+		solutions []string
+	}{
+		// ud and du reach the same cube so are the same solution
+		{"UD", []string{"ud"}},
+		{"UF", []string{"fu"}},
+		// RR and rr are both a half turn of R
+		{"RR", []string{"RR"}},
+		{"RUr", []string{"Rur"}},
+	}
+	for _, test := range tests {
+		c := cube.NewSolvedCube()
+		c.Transform(test.setup)
+		solutions, count, err := SolveCubeBySearchAll(store, c, 2, 3, 0, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(solutions, test.solutions) || count != len(test.solutions) {
+			t.Errorf("Setup %s should have solutions %v but got %v of %d", test.setup, test.solutions, solutions, count)
+		}
+	}
+}
+
+func TestDBConnection_BatchLookup(t *testing.T) {
+	db := CreateDBConnection(filepath.Join(t.TempDir(), "cubes.db"))
+	defer db.Close()
+
+	r := rand.New(rand.NewSource(0))
+	results := make(map[uint128.Uint128]uint64)
+	var ids []uint128.Uint128
+	for i := 0; i < 600; i++ {
+		id := uint128.Uint128{H: r.Uint64(), L: r.Uint64()}
+		// only every other cube is saved, so the batch has misses too
+		if i%2 == 0 {
+			results[id] = encodeTransform(randomScramble(r, 1+r.Intn(8)))
+		}
+		ids = append(ids, id)
+	}
+	ids = append(ids, ids[0], ids[1], ids[0])
+	db.Save(results, initialCheckpoint)
+
+	solutions, found := db.BatchLookup(ids)
+	for i, id := range ids {
+		solution, exists := db.Lookup(id)
+		if found[i] != exists || solutions[i] != solution {
+			t.Errorf("Batch lookup of %v gave %s %t rather than %s %t", id, solutions[i], found[i], solution, exists)
+		}
+	}
+}
+
+func BenchmarkSolveCubeBySearch(b *testing.B) {
+	store := generateMemoryStore(b, 3)
+	r := rand.New(rand.NewSource(2))
+	cubes := make([]*cube.Cube, 16)
+	for i := range cubes {
+		cubes[i] = cube.NewSolvedCube()
+		cubes[i].Transform(randomScramble(r, 5))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := cubes[i%len(cubes)]
+		if _, found := SolveCubeBySearch(store, cube.NewCube(c.Layout), 1, 2); !found {
+			b.Fatal("Every cube should be solved within two moves of the store")
+		}
+	}
+}
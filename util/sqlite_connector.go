@@ -10,19 +10,22 @@ import (
 	"strings"
 )
 
+// DBConnection is the sqlite SolutionStore. One connection can be shared between
+// goroutines, database/sql gives each of them its own sqlite connection as needed
 type DBConnection struct {
-	db        *sql.DB
-	path      string
-	connected bool
+	db         *sql.DB
+	path       string
+	connected  bool
+	lookupStmt *sql.Stmt
 }
 
-func CreateDBConnection(path string) DBConnection {
+func CreateDBConnection(path string) *DBConnection {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		panic(err)
 	}
 
-	dbConnection := DBConnection{
+	dbConnection := &DBConnection{
 		db:        db,
 		path:      path,
 		connected: true,
@@ -45,22 +48,196 @@ func CreateDBConnection(path string) DBConnection {
 		fmt.Println("Error creating next_transform table")
 		panic(err)
 	}
+	dbConnection.lookupStmt, err = db.Prepare("SELECT solution FROM cubes WHERE cube_id_l = ? AND cube_id_h = ?;")
+	if err != nil {
+		fmt.Println("Error creating prepared statement")
+		panic(err)
+	}
 
 	return dbConnection
 }
 
+// Path is where the database is stored, used to check the disk isn't full
+func (dbConnection *DBConnection) Path() string {
+	return dbConnection.path
+}
+
+func (dbConnection *DBConnection) Lookup(cubeId uint128.Uint128) (string, bool) {
+	return loadSolution(cubeId, dbConnection.lookupStmt)
+}
+
+// lookupQueryIds is how many cubes are looked up by each query, two parameters each
+// keeps well under sqlite's limit on the number of parameters
+const lookupQueryIds = 250
+
+// BatchLookup finds many cubes with one query per lookupQueryIds cubes, rather than one each
+func (dbConnection *DBConnection) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	solutions := make([]string, len(cubeIds))
+	found := make([]bool, len(cubeIds))
+	for start := 0; start < len(cubeIds); start += lookupQueryIds {
+		end := start + lookupQueryIds
+		if end > len(cubeIds) {
+			end = len(cubeIds)
+		}
+		if !dbConnection.batchLookup(cubeIds[start:end], solutions[start:end], found[start:end]) {
+			// fall back to looking up each cube so one bad query doesn't lose the whole batch
+			for i := start; i < end; i++ {
+				solutions[i], found[i] = dbConnection.Lookup(cubeIds[i])
+			}
+		}
+	}
+	return solutions, found
+}
+
+func (dbConnection *DBConnection) batchLookup(cubeIds []uint128.Uint128, solutions []string, found []bool) bool {
+	// the same cube can be asked for more than once, e.g. from two rotations of a symmetrical cube
+	positions := make(map[uint128.Uint128][]int, len(cubeIds))
+	query := strings.Builder{}
+	query.WriteString("SELECT cube_id_l, cube_id_h, solution FROM cubes WHERE (cube_id_l, cube_id_h) IN (VALUES ")
+	args := make([]interface{}, 0, 2*len(cubeIds))
+	for i, cubeId := range cubeIds {
+		if _, seen := positions[cubeId]; !seen {
+			if len(args) > 0 {
+				query.WriteString(",")
+			}
+			query.WriteString("(?,?)")
+			args = append(args, int64(cubeId.L), int64(cubeId.H))
+		}
+		positions[cubeId] = append(positions[cubeId], i)
+	}
+	query.WriteString(");")
+
+	rows, err := dbConnection.db.Query(query.String(), args...)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l, h int64
+		var encodedSolution uint64
+		if err := rows.Scan(&l, &h, &encodedSolution); err != nil {
+			fmt.Println(err)
+			return false
+		}
+		solution := decodeTransform(encodedSolution)
+		for _, i := range positions[uint128.Uint128{H: uint64(h), L: uint64(l)}] {
+			solutions[i], found[i] = solution, true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// the settings table is created when first written to, so older databases can still be opened read only
+func (dbConnection *DBConnection) createSettingsTable() error {
+	_, err := dbConnection.db.Exec("CREATE TABLE IF NOT EXISTS `settings` (" +
+		"`name` TEXT NOT NULL PRIMARY KEY, " +
+		"`value` TEXT NOT NULL);")
+	return err
+}
+
+func (dbConnection *DBConnection) getSetting(name string) (string, bool) {
+	var value string
+	err := dbConnection.db.QueryRow("SELECT value FROM settings WHERE name = ?;", name).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows && !strings.Contains(err.Error(), "no such table") {
+			fmt.Printf("Error loading setting %s: %s\n", name, err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (dbConnection *DBConnection) setSetting(name, value string) bool {
+	err := dbConnection.createSettingsTable()
+	if err != nil {
+		fmt.Println("Error creating settings table")
+		fmt.Println(err)
+		return false
+	}
+	_, err = dbConnection.db.Exec("INSERT OR REPLACE INTO settings (name, value) VALUES (?, ?);", name, value)
+	if err != nil {
+		fmt.Printf("Couldn't save setting %s\n", name)
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// GetMetric returns the metric the cubes table was generated with. Databases from
+// before the metric was recorded were always generated in the quarter turn metric
+func (dbConnection *DBConnection) GetMetric() cube.Metric {
+	value, found := dbConnection.getSetting("metric")
+	if !found {
+		return cube.QuarterTurnMetric
+	}
+	metric, err := cube.ParseMetric(value)
+	if err != nil {
+		fmt.Printf("Error loading metric: %s\n", err)
+	}
+	return metric
+}
+
+func (dbConnection *DBConnection) SetMetric(metric cube.Metric) bool {
+	return dbConnection.setSetting("metric", metric.String())
+}
+
+// GetEncoding returns the encoding of the ids in the cubes table. Databases from before the
+// encoding was recorded were always encoded with rotations
+func (dbConnection *DBConnection) GetEncoding() cube.Encoding {
+	value, found := dbConnection.getSetting("encoding")
+	if !found {
+		return cube.RotationEncoding
+	}
+	encoding, err := cube.ParseEncoding(value)
+	if err != nil {
+		fmt.Printf("Error loading encoding: %s\n", err)
+	}
+	return encoding
+}
+
+func (dbConnection *DBConnection) SetEncoding(encoding cube.Encoding) bool {
+	return dbConnection.setSetting("encoding", encoding.String())
+}
+
+// IsEmpty is true when no cubes have been saved yet
+func (dbConnection *DBConnection) IsEmpty() bool {
+	var exists bool
+	err := dbConnection.db.QueryRow("SELECT EXISTS (SELECT 1 FROM cubes);").Scan(&exists)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return !exists
+}
+
+// countCubes is the number of cubes saved, which scans the whole table
+func (dbConnection *DBConnection) countCubes() (uint64, error) {
+	var count uint64
+	err := dbConnection.db.QueryRow("SELECT COUNT(*) FROM cubes;").Scan(&count)
+	return count, err
+}
+
 func (dbConnection *DBConnection) Close() {
 	if !dbConnection.connected {
 		panic("close called on disconnected DBConnection")
 	}
-	err := dbConnection.db.Close()
+	err := dbConnection.lookupStmt.Close()
+	if err != nil {
+		panic(err)
+	}
+	err = dbConnection.db.Close()
 	if err != nil {
 		panic(err)
 	}
 	dbConnection.connected = false
 }
 
-func (dbConnection *DBConnection) Save(results map[uint128.Uint128]uint64, transformNo int, stack string) bool {
+func (dbConnection *DBConnection) Save(results map[uint128.Uint128]uint64, checkpoint Checkpoint) bool {
 	transaction, err := dbConnection.db.Begin()
 	if err != nil {
 		fmt.Println(err)
@@ -86,7 +263,7 @@ func (dbConnection *DBConnection) Save(results map[uint128.Uint128]uint64, trans
 		fmt.Println(err)
 		return false
 	}
-	_, err = stmt.Exec(transformNo, stack)
+	_, err = stmt.Exec(checkpoint.NextNum, checkpoint.EncodedStack)
 	if err != nil {
 		fmt.Println("Couldn't update next transform")
 		fmt.Println(err)
@@ -102,12 +279,17 @@ func (dbConnection *DBConnection) Save(results map[uint128.Uint128]uint64, trans
 	return true
 }
 
-type QueryResult struct {
-	NextNum      int
-	EncodedStack string
+func (dbConnection *DBConnection) SetCheckpoint(checkpoint Checkpoint) bool {
+	_, err := dbConnection.db.Exec("INSERT OR REPLACE INTO next_transform (id, transform_no, stack) VALUES (1, ?, ?);", checkpoint.NextNum, checkpoint.EncodedStack)
+	if err != nil {
+		fmt.Println("Couldn't update next transform")
+		fmt.Println(err)
+		return false
+	}
+	return true
 }
 
-func (dbConnection *DBConnection) GetNextTransforms() QueryResult {
+func (dbConnection *DBConnection) GetCheckpoint() Checkpoint {
 	rows, err := dbConnection.db.Query("SELECT transform_no, stack FROM next_transform;")
 	if err != nil {
 		log.Fatalln(err)
@@ -124,7 +306,7 @@ func (dbConnection *DBConnection) GetNextTransforms() QueryResult {
 		if rowErr != nil {
 			fmt.Printf("Error closing the DB: %s\n", rowErr)
 		}
-		return QueryResult{
+		return Checkpoint{
 			NextNum:      nextNum,
 			EncodedStack: stack,
 		}
@@ -133,19 +315,36 @@ func (dbConnection *DBConnection) GetNextTransforms() QueryResult {
 	if rowErr != nil {
 		fmt.Printf("Error closing the DB: %s\n", rowErr)
 	}
-	return QueryResult{
-		NextNum:      0,
-		EncodedStack: "0",
-	}
+	return initialCheckpoint
 }
 
 type lookupWorkerRequest struct {
-	cube *cube.Cube
-	data interface{}
+	cube      cube.State
+	transform string // applied to a copy of cube before looking it up, if set
+	inverse   bool   // look up the inverse of the transformed cube instead, it must be a valid cube
+	data      interface{}
+}
+
+// prepare returns the cube to look up, applying the request's transform. It's false when the
+// inverse was asked for but can't be taken, which is a miss rather than a lookup
+func (request *lookupWorkerRequest) prepare() (cube.State, bool) {
+	c := request.cube
+	if request.transform != "" {
+		c = c.Copy()
+		c.Transform(request.transform)
+	}
+	if request.inverse {
+		inverse, ok := inverseOf(c)
+		if !ok {
+			return c, false
+		}
+		return inverse, true
+	}
+	return c, true
 }
 
 type lookupWorkerResponse struct {
-	cube     *cube.Cube
+	cube     cube.State
 	success  bool
 	solution string
 	data     interface{}
@@ -182,46 +381,108 @@ func (p ParallelDatabaseLookup) StopForcefully() {
 	}
 }
 
-func CreateLookupWorkers(bufferSize, workerCount int, dbPath string) ParallelDatabaseLookup {
+// CreateLookupWorkers starts workers that encode cubes and look them up in the store
+func CreateLookupWorkers(bufferSize, workerCount int, store SolutionStore) ParallelDatabaseLookup {
+	encoding := store.GetEncoding()
 	requestChan := make(chan *lookupWorkerRequest, bufferSize)
 	resultsChan := make(chan *lookupWorkerResponse, bufferSize)
 	for worker := 0; worker < workerCount; worker++ {
 		go func() {
-			trimmedDbPath, _, _ := strings.Cut(dbPath, "?")
-			dbConnection := CreateDBConnection(trimmedDbPath + "?cache=shared&mode=ro")
-			stmt, err := dbConnection.db.Prepare("SELECT solution FROM cubes WHERE cube_id_l = ? AND cube_id_h = ?;")
-			if err != nil {
-				fmt.Printf("Error creating prepared statement\n")
-				panic(err)
-			}
-			defer dbConnection.Close()
 			for {
 				job := <-requestChan
 				if job == nil {
 					resultsChan <- nil
 					return
 				}
-				cid, rot := job.cube.EncodeCube()
-				if cid == cube.SolvedCubeId {
-					resultsChan <- &lookupWorkerResponse{
-						cube:     job.cube,
-						success:  true,
-						solution: "",
-						data:     job.data,
+				c, ok := job.prepare()
+				if !ok {
+					resultsChan <- &lookupWorkerResponse{cube: c, data: job.data}
+					continue
+				}
+				id, rotation := encoding.Encode(c)
+				solution, success := LookupCube(store, id, rotation)
+				resultsChan <- &lookupWorkerResponse{
+					cube:     c,
+					success:  success,
+					solution: solution,
+					data:     job.data,
+				}
+			}
+		}()
+	}
+	return ParallelDatabaseLookup{
+		requestChan: requestChan,
+		resultsChan: resultsChan,
+		workerCount: workerCount,
+	}
+}
+
+// BatchedDatabaseLookup looks up whole batches of cubes at a time using the store's
+// BatchLookup. Responses are in the same order as the requests in each batch
+type BatchedDatabaseLookup struct {
+	requestChan chan []*lookupWorkerRequest
+	resultsChan chan []*lookupWorkerResponse
+	workerCount int
+}
+
+func (p BatchedDatabaseLookup) Stop() {
+	for i := 0; i < p.workerCount; i++ {
+		p.requestChan <- nil
+	}
+	for i := 0; i < p.workerCount; i++ {
+		if r := <-p.resultsChan; r != nil {
+			i -= 1 // a batch was still being looked up, wait an extra iteration
+		}
+	}
+}
+
+// CreateBatchLookupWorkers starts workers that encode a batch of cubes and look them all up together
+func CreateBatchLookupWorkers(bufferSize, workerCount int, store SolutionStore) BatchedDatabaseLookup {
+	encoding := store.GetEncoding()
+	requestChan := make(chan []*lookupWorkerRequest, bufferSize)
+	resultsChan := make(chan []*lookupWorkerResponse, bufferSize)
+	for worker := 0; worker < workerCount; worker++ {
+		go func() {
+			for {
+				batch := <-requestChan
+				if batch == nil {
+					resultsChan <- nil
+					return
+				}
+				cubes := make([]cube.State, len(batch))
+				prepared := make([]bool, len(batch))
+				ids := make([]uint128.Uint128, 0, len(batch))
+				rotations := make([]string, len(batch))
+				for i, job := range batch {
+					cubes[i], prepared[i] = job.prepare()
+					if prepared[i] {
+						var id uint128.Uint128
+						id, rotations[i] = encoding.Encode(cubes[i])
+						ids = append(ids, id)
 					}
-				} else {
-					solution, success := dbConnection.lookupCube(cid, rot, stmt)
-					resultsChan <- &lookupWorkerResponse{
-						cube:     job.cube,
-						success:  success,
-						solution: solution,
-						data:     job.data,
+				}
+				solutions, found := store.BatchLookup(ids)
+				responses := make([]*lookupWorkerResponse, len(batch))
+				next := 0
+				for i, job := range batch {
+					response := &lookupWorkerResponse{cube: cubes[i], data: job.data}
+					responses[i] = response
+					if !prepared[i] {
+						continue
+					}
+					if ids[next].Equals(cube.SolvedCubeId) {
+						response.success = true
+					} else if found[next] {
+						response.success = true
+						response.solution = cube.RotateTransform(rotations[i], solutions[next])
 					}
+					next += 1
 				}
+				resultsChan <- responses
 			}
 		}()
 	}
-	return ParallelDatabaseLookup{
+	return BatchedDatabaseLookup{
 		requestChan: requestChan,
 		resultsChan: resultsChan,
 		workerCount: workerCount,
@@ -0,0 +1,411 @@
+package util
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"golang.org/x/sys/unix"
+	"os"
+	"sort"
+)
+
+// A table file stores the cubes table as a header, fixed size entries and an index, little
+// endian. Entries are sorted by (cube_id_l, cube_id_h) compared as signed integers, the same
+// order as the primary key of the sqlite table, so converting only needs to read the table in
+// order. Flipping the sign bit of cube_id_l makes that order unsigned, and the index gives the
+// first entry whose flipped cube_id_l starts with each prefix, so an entry only stores the rest
+// of cube_id_l, then cube_id_h and the solution in as few bytes as the longest one needs.
+// The header also has the checkpoint of the database it was converted from, to know which
+// depths it has finished. Version 1 files have no index or checkpoint and store each entry in
+// full as 24 bytes
+
+const tableFileMagic = "RCST"
+const tableFileVersion = 2
+const tableEntrySize = 24    // the size of a full entry, in version 1 files and frontier runs
+const tableHeaderV1Size = 24 // magic, version, metric, encoding and the number of entries
+const tableHeaderSize = 48   // then the prefix and solution sizes in bytes, and the checkpoint followed by its stack
+const tableSignBit = uint64(1) << 63
+
+var ErrNotTableFile = errors.New("not a cube table file")
+
+type TableFile struct {
+	data          []byte
+	entries       []byte
+	index         []byte // version 2 files only
+	count         int
+	metric        cube.Metric
+	encoding      cube.Encoding
+	version       uint32
+	prefixBytes   int
+	solutionBytes int
+	entrySize     int
+	checkpoint    Checkpoint
+}
+
+// tablePrefixBytes is how many bytes of cube_id_l the index covers, about one prefix for every
+// 16 entries so the index is small next to them
+func tablePrefixBytes(expected uint64) int {
+	prefixBytes := 0
+	for prefixBytes < 3 && uint64(1)<<(8*(prefixBytes+1)+4) <= expected {
+		prefixBytes += 1
+	}
+	return prefixBytes
+}
+
+// solutionBytes is how many bytes an encoded solution needs
+func solutionBytes(solution uint64) int {
+	n := 1
+	for solution >= 1<<(8*n) && n < 8 {
+		n += 1
+	}
+	return n
+}
+
+func putUintN(b []byte, v uint64, n int) {
+	for i := 0; i < n; i++ {
+		b[i] = byte(v >> (8 * i))
+	}
+}
+
+func uintN(b []byte, n int) uint64 {
+	v := uint64(0)
+	for i := 0; i < n; i++ {
+		v |= uint64(b[i]) << (8 * i)
+	}
+	return v
+}
+
+// OpenTableFile memory maps the table file so lookups only read the pages they need
+func OpenTableFile(path string) (*TableFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < tableHeaderV1Size {
+		return nil, ErrNotTableFile
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &TableFile{data: data, version: binary.LittleEndian.Uint32(data[4:8])}
+	if string(data[0:4]) != tableFileMagic || (table.version != 1 && table.version != tableFileVersion) {
+		table.Close()
+		return nil, ErrNotTableFile
+	}
+	table.metric = cube.Metric(binary.LittleEndian.Uint32(data[8:12]))
+	// files from before the encoding was recorded have zero padding here, which is rotations
+	table.encoding = cube.Encoding(binary.LittleEndian.Uint32(data[12:16]))
+	table.count = int(binary.LittleEndian.Uint64(data[16:24]))
+	headerSize, indexSize := tableHeaderV1Size, 0
+	table.solutionBytes, table.entrySize = 8, tableEntrySize
+	table.checkpoint = initialCheckpoint
+	if table.version != 1 {
+		if len(data) < tableHeaderSize || data[24] > 3 || data[25] > 8 {
+			table.Close()
+			return nil, ErrNotTableFile
+		}
+		stackSize := binary.LittleEndian.Uint64(data[40:48])
+		if stackSize > uint64(len(data)-tableHeaderSize) {
+			table.Close()
+			return nil, ErrNotTableFile
+		}
+		headerSize = tableHeaderSize + int(stackSize)
+		table.checkpoint = Checkpoint{
+			NextNum:      int(binary.LittleEndian.Uint64(data[32:40])),
+			EncodedStack: string(data[tableHeaderSize:headerSize]),
+		}
+		table.prefixBytes, table.solutionBytes = int(data[24]), int(data[25])
+		table.entrySize = 8 - table.prefixBytes + 8 + table.solutionBytes
+		indexSize = 8 * (1<<(8*table.prefixBytes) + 1)
+	}
+	if len(data) != headerSize+table.count*table.entrySize+indexSize {
+		table.Close()
+		return nil, fmt.Errorf("%w: expected %d entries but the file is %d bytes", ErrNotTableFile, table.count, len(data))
+	}
+	table.entries = data[headerSize : headerSize+table.count*table.entrySize]
+	table.index = data[headerSize+table.count*table.entrySize:]
+	return table, nil
+}
+
+func (table *TableFile) Close() {
+	if table.data == nil {
+		panic("close called on closed TableFile")
+	}
+	err := unix.Munmap(table.data)
+	if err != nil {
+		panic(err)
+	}
+	table.data, table.entries, table.index = nil, nil, nil
+}
+
+func (table *TableFile) GetMetric() cube.Metric {
+	return table.metric
+}
+
+// Count is the number of cubes in the table
+func (table *TableFile) Count() int {
+	return table.count
+}
+
+// bucket is the range of entries whose flipped cube_id_l starts with the prefix
+func (table *TableFile) bucket(prefix uint64) (int, int) {
+	if table.version == 1 {
+		return 0, table.count
+	}
+	return table.bucketStart(prefix), table.bucketStart(prefix + 1)
+}
+
+func (table *TableFile) bucketStart(prefix uint64) int {
+	return int(binary.LittleEndian.Uint64(table.index[8*prefix:]))
+}
+
+func (table *TableFile) entry(i int) (int64, int64, uint64) {
+	e := table.entries[i*table.entrySize : (i+1)*table.entrySize]
+	if table.version == 1 {
+		return int64(binary.LittleEndian.Uint64(e[0:8])), int64(binary.LittleEndian.Uint64(e[8:16])), binary.LittleEndian.Uint64(e[16:24])
+	}
+	// the entry is in the first bucket that ends after it
+	prefix := sort.Search(1<<(8*table.prefixBytes), func(p int) bool {
+		return table.bucketStart(uint64(p)+1) > i
+	})
+	return table.entryIn(uint64(prefix), e)
+}
+
+// entryIn reads an entry of a version 2 file with the prefix given
+func (table *TableFile) entryIn(prefix uint64, e []byte) (int64, int64, uint64) {
+	suffixBytes := 8 - table.prefixBytes
+	l := (prefix<<(8*suffixBytes) | uintN(e, suffixBytes)) ^ tableSignBit
+	h := binary.LittleEndian.Uint64(e[suffixBytes : suffixBytes+8])
+	return int64(l), int64(h), uintN(e[suffixBytes+8:], table.solutionBytes)
+}
+
+func (table *TableFile) Lookup(id uint128.Uint128) (string, bool) {
+	l, h := int64(id.L), int64(id.H)
+	prefix := uint64(0)
+	if table.version != 1 {
+		prefix = (id.L ^ tableSignBit) >> (64 - 8*table.prefixBytes)
+	}
+	low, high := table.bucket(prefix)
+	for low < high {
+		mid := int(uint(low+high) >> 1)
+		entryL, entryH, solution := table.entryAt(prefix, mid)
+		switch {
+		case entryL == l && entryH == h:
+			return decodeTransform(solution), true
+		case entryL < l || (entryL == l && entryH < h):
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+	return "", false
+}
+
+// entryAt reads entry i, which is known to have the prefix given
+func (table *TableFile) entryAt(prefix uint64, i int) (int64, int64, uint64) {
+	if table.version == 1 {
+		return table.entry(i)
+	}
+	return table.entryIn(prefix, table.entries[i*table.entrySize:(i+1)*table.entrySize])
+}
+
+// LookupCube is used to find the solution for a single cube if it exists in the table,
+// the same as DBConnection.LookupCube
+func (table *TableFile) LookupCube(cubeId uint128.Uint128, rotation string) (string, bool) {
+	return LookupCube(table, cubeId, rotation)
+}
+
+func (table *TableFile) BatchLookup(cubeIds []uint128.Uint128) ([]string, []bool) {
+	solutions := make([]string, len(cubeIds))
+	found := make([]bool, len(cubeIds))
+	for i, cubeId := range cubeIds {
+		solutions[i], found[i] = table.Lookup(cubeId)
+	}
+	return solutions, found
+}
+
+// table files are written in one go by ConvertToTableFile, so are read only as a SolutionStore
+
+func (table *TableFile) Save(map[uint128.Uint128]uint64, Checkpoint) bool {
+	fmt.Println("Table files are read only, generate into a sqlite database and convert it")
+	return false
+}
+
+// GetCheckpoint is the checkpoint of the database the table was converted from
+func (table *TableFile) GetCheckpoint() Checkpoint {
+	return table.checkpoint
+}
+
+func (table *TableFile) SetCheckpoint(Checkpoint) bool {
+	fmt.Println("Table files are read only")
+	return false
+}
+
+func (table *TableFile) SetMetric(metric cube.Metric) bool {
+	return metric == table.metric
+}
+
+func (table *TableFile) GetEncoding() cube.Encoding {
+	return table.encoding
+}
+
+func (table *TableFile) SetEncoding(encoding cube.Encoding) bool {
+	return encoding == table.encoding
+}
+
+func (table *TableFile) IsEmpty() bool {
+	return table.count == 0
+}
+
+// tableFileWriter writes entries to a new table file, they must be added in order
+type tableFileWriter struct {
+	f             *os.File
+	writer        *bufio.Writer
+	header        []byte
+	entry         []byte
+	count         uint64
+	prefixBytes   int
+	solutionBytes int
+	index         []uint64 // the first entry with each prefix, filled in as entries are added
+	nextPrefix    uint64
+}
+
+// createTableFile starts a table file for about expected entries, with solutions that fit in
+// solutionBytes, holding the cubes saved by checkpoint
+func createTableFile(path string, metric cube.Metric, encoding cube.Encoding, checkpoint Checkpoint, expected uint64, solutionBytes int) (*tableFileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	prefixBytes := tablePrefixBytes(expected)
+	t := &tableFileWriter{
+		f:             f,
+		writer:        bufio.NewWriterSize(f, 1<<20),
+		header:        make([]byte, tableHeaderSize),
+		entry:         make([]byte, 8-prefixBytes+8+solutionBytes),
+		prefixBytes:   prefixBytes,
+		solutionBytes: solutionBytes,
+		index:         make([]uint64, 1<<(8*prefixBytes)+1),
+	}
+	copy(t.header[0:4], tableFileMagic)
+	binary.LittleEndian.PutUint32(t.header[4:8], tableFileVersion)
+	binary.LittleEndian.PutUint32(t.header[8:12], uint32(metric))
+	binary.LittleEndian.PutUint32(t.header[12:16], uint32(encoding))
+	t.header[24], t.header[25] = byte(prefixBytes), byte(solutionBytes)
+	binary.LittleEndian.PutUint64(t.header[32:40], uint64(checkpoint.NextNum))
+	binary.LittleEndian.PutUint64(t.header[40:48], uint64(len(checkpoint.EncodedStack)))
+	if _, err := t.writer.Write(t.header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := t.writer.WriteString(checkpoint.EncodedStack); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tableFileWriter) add(l, h int64, solution uint64) error {
+	if solutionBytes(solution) > t.solutionBytes {
+		return fmt.Errorf("the solution %x doesn't fit in %d bytes", solution, t.solutionBytes)
+	}
+	suffixBytes := 8 - t.prefixBytes
+	key := uint64(l) ^ tableSignBit
+	for prefix := key >> (8 * suffixBytes); t.nextPrefix <= prefix; t.nextPrefix++ {
+		t.index[t.nextPrefix] = t.count
+	}
+	putUintN(t.entry, key, suffixBytes)
+	binary.LittleEndian.PutUint64(t.entry[suffixBytes:suffixBytes+8], uint64(h))
+	putUintN(t.entry[suffixBytes+8:], solution, t.solutionBytes)
+	t.count += 1
+	_, err := t.writer.Write(t.entry)
+	return err
+}
+
+// finish writes the index and closes the file, the number of entries is only known once
+// they've all been written
+func (t *tableFileWriter) finish() error {
+	defer t.f.Close()
+	for ; t.nextPrefix < uint64(len(t.index)); t.nextPrefix++ {
+		t.index[t.nextPrefix] = t.count
+	}
+	if err := binary.Write(t.writer, binary.LittleEndian, t.index); err != nil {
+		return err
+	}
+	if err := t.writer.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(t.header[16:24], t.count)
+	if _, err := t.f.WriteAt(t.header, 0); err != nil {
+		return err
+	}
+	return t.f.Sync()
+}
+
+// ConvertToTableFile writes every cube in the database to a new table file at path
+func ConvertToTableFile(dbConnection *DBConnection, path string) error {
+	// the table is only moved to path once every cube is written, so a failed conversion can't be
+	// mistaken for a complete table
+	tempPath := path + ".tmp"
+	if err := writeTableFile(dbConnection, tempPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+func writeTableFile(dbConnection *DBConnection, path string) error {
+	// solutions with their top bit set are stored as negative numbers
+	var count uint64
+	var minSolution, maxSolution sql.NullInt64
+	err := dbConnection.db.QueryRow("SELECT COUNT(*), MIN(solution), MAX(solution) FROM cubes;").Scan(&count, &minSolution, &maxSolution)
+	if err != nil {
+		return err
+	}
+	longest := solutionBytes(uint64(maxSolution.Int64))
+	if minSolution.Int64 < 0 {
+		longest = 8
+	}
+
+	rows, err := dbConnection.db.Query("SELECT cube_id_l, cube_id_h, solution FROM cubes ORDER BY cube_id_l, cube_id_h;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	table, err := createTableFile(path, dbConnection.GetMetric(), dbConnection.GetEncoding(), dbConnection.GetCheckpoint(), count, longest)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l, h, solution int64
+		if err := rows.Scan(&l, &h, &solution); err != nil {
+			table.finish()
+			return err
+		}
+		if err := table.add(l, h, uint64(solution)); err != nil {
+			table.finish()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		table.finish()
+		return err
+	}
+	return table.finish()
+}
@@ -0,0 +1,147 @@
+package util
+
+import (
+	"encoding/binary"
+	"github.com/davidminor/uint128"
+	"github.com/matthewjackswann/rubiks/cube"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestConvertToTableFile(t *testing.T) {
+	dir := t.TempDir()
+	db := CreateDBConnection(filepath.Join(dir, "cubes.db"))
+	defer db.Close()
+	db.SetMetric(cube.HalfTurnMetric)
+
+	// real cubes as well as random ids, so both halves of the id have their top bit set in places
+	results := make(map[uint128.Uint128]uint64)
+	for _, transform := range []string{"F", "r", "UU", "FRB", "lDDb"} {
+		c := cube.NewSolvedCube()
+		c.Transform(transform)
+		id, rotation := c.EncodeCube()
+		results[id] = encodeTransform(cube.RotateTransform(cube.ReverseTransform(rotation), cube.ReverseTransform(transform)))
+	}
+	r := rand.New(rand.NewSource(0))
+	// enough cubes for the index to cover a byte of each id
+	for i := 0; i < 5000; i++ {
+		results[uint128.Uint128{H: r.Uint64(), L: r.Uint64()}] = encodeTransform("FRU")
+	}
+	if !db.Save(results, initialCheckpoint) {
+		t.Fatal("Couldn't save the cubes")
+	}
+
+	path := filepath.Join(dir, "cubes.table")
+	if err := ConvertToTableFile(db, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("The table should be moved from its temporary file once it's written")
+	}
+	table, err := OpenTableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if table.Count() != len(results) {
+		t.Errorf("The table has %d cubes rather than %d", table.Count(), len(results))
+	}
+	if table.prefixBytes != 1 || table.solutionBytes != 2 {
+		t.Errorf("Entries should leave out a byte of the id and store solutions in 2 bytes, got %d and %d", table.prefixBytes, table.solutionBytes)
+	}
+	for i := 0; i < table.Count(); i++ {
+		l, h, solution := table.entry(i)
+		if found, exists := results[uint128.Uint128{H: uint64(h), L: uint64(l)}]; !exists || found != solution {
+			t.Fatalf("Entry %d is %d %d %x which isn't a saved cube", i, l, h, solution)
+		}
+	}
+	if table.GetMetric() != cube.HalfTurnMetric {
+		t.Errorf("The table's metric is %s rather than htm", table.GetMetric())
+	}
+	for id, solution := range results {
+		tableSolution, found := table.LookupCube(id, "")
+		if !found || tableSolution != decodeTransform(solution) {
+			t.Errorf("Cube %v should have solution %s but got %s", id, decodeTransform(solution), tableSolution)
+		}
+	}
+
+	// lookups are the same as the database, including rotating the stored solution
+	for _, transform := range []string{"XF", "zzr", "yUU", "FRBx", "SlDDb", "RL"} {
+		c := cube.NewSolvedCube()
+		c.Transform(transform)
+		dbSolution, dbFound := db.LookupCube(c.EncodeCube())
+		tableSolution, tableFound := table.LookupCube(c.EncodeCube())
+		if dbFound != tableFound || dbSolution != tableSolution {
+			t.Errorf("Looking up %s gave %s %t from the database but %s %t from the table", transform, dbSolution, dbFound, tableSolution, tableFound)
+		}
+		if tableFound {
+			c.Transform(tableSolution)
+			if !c.IsSolved() {
+				t.Errorf("Solution %s doesn't solve %s", tableSolution, transform)
+			}
+		}
+	}
+
+	if solution, found := table.LookupCube(cube.SolvedCubeId, ""); !found || solution != "" {
+		t.Errorf("The solved cube should be found with an empty solution")
+	}
+}
+
+func TestOpenTableFile_NotATable(t *testing.T) {
+	dir := t.TempDir()
+	db := CreateDBConnection(filepath.Join(dir, "cubes.db"))
+	db.Close()
+	if _, err := OpenTableFile(filepath.Join(dir, "cubes.db")); err == nil {
+		t.Errorf("A sqlite database shouldn't open as a table file")
+	}
+}
+
+func TestOpenTableFile_Version1(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	results := make(map[uint128.Uint128]uint64)
+	var ids []uint128.Uint128
+	for i := 0; i < 100; i++ {
+		id := uint128.Uint128{H: r.Uint64(), L: r.Uint64()}
+		results[id] = encodeTransform("FRUBLD")
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return int64(ids[i].L) < int64(ids[j].L) || (ids[i].L == ids[j].L && int64(ids[i].H) < int64(ids[j].H))
+	})
+	data := make([]byte, tableHeaderV1Size+len(ids)*tableEntrySize)
+	copy(data[0:4], tableFileMagic)
+	binary.LittleEndian.PutUint32(data[4:8], 1)
+	binary.LittleEndian.PutUint32(data[8:12], uint32(cube.QuarterTurnMetric))
+	binary.LittleEndian.PutUint64(data[16:24], uint64(len(ids)))
+	for i, id := range ids {
+		e := data[tableHeaderV1Size+i*tableEntrySize:]
+		binary.LittleEndian.PutUint64(e[0:8], id.L)
+		binary.LittleEndian.PutUint64(e[8:16], id.H)
+		binary.LittleEndian.PutUint64(e[16:24], results[id])
+	}
+	path := filepath.Join(t.TempDir(), "cubes.table")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := OpenTableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	if table.Count() != len(ids) {
+		t.Errorf("The table has %d cubes rather than %d", table.Count(), len(ids))
+	}
+	for id, solution := range results {
+		if tableSolution, found := table.Lookup(id); !found || tableSolution != decodeTransform(solution) {
+			t.Errorf("Cube %v should have solution %s but got %s", id, decodeTransform(solution), tableSolution)
+		}
+	}
+	if _, found := table.Lookup(uint128.Uint128{H: 1, L: 1}); found {
+		t.Errorf("A cube that isn't in the table shouldn't be found")
+	}
+}
@@ -0,0 +1,384 @@
+package util
+
+import (
+	"errors"
+	"github.com/matthewjackswann/rubiks/cube"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The two phase solver first moves the cube into the subgroup reachable with
+// U, D, R2, L2, F2, B2 (all edges and corners oriented and the E slice edges in the
+// E slice), then solves it using only those moves. Each phase is an IDA* search over
+// small coordinates of the cube with precomputed move and pruning tables, so it finds
+// short solutions quickly but they aren't guaranteed to be optimal
+
+const (
+	twistCount      = 2187 // 3^7 corner orientations
+	flipCount       = 2048 // 2^11 edge orientations
+	sliceCount      = 495  // 12 choose 4 positions for the E slice edges
+	cornerPermCount = 40320
+	edgePermCount   = 40320 // permutations of the U and D edges in phase 2
+	slicePermCount  = 24
+)
+
+// the 18 moves are numbered face*3 + turns-1, using this order of faces
+var twoPhaseFaces = []rune{'F', 'L', 'U', 'B', 'R', 'D'}
+
+const moveCount = 18
+
+// phase two only uses quarter turns of U and D and half turns of the other faces
+var phaseTwoMoves = []int{1, 4, 6, 7, 8, 10, 13, 15, 16, 17}
+
+func twoPhaseMoveTransform(move int) string {
+	face := string(twoPhaseFaces[move/3])
+	switch move % 3 {
+	case 0:
+		return face
+	case 1:
+		return face + face
+	}
+	return strings.ToLower(face)
+}
+
+// bannedAfter stops the search turning the same face twice in a row or turning
+// opposite faces in both orders, matching the generator graphs
+func bannedAfter(lastFace, face int) bool {
+	if lastFace == -1 {
+		return false
+	}
+	if face == lastFace {
+		return true
+	}
+	last, next := twoPhaseFaces[lastFace], twoPhaseFaces[face]
+	return (last == 'B' && next == 'F') || (last == 'R' && next == 'L') || (last == 'D' && next == 'U')
+}
+
+func twistCoordinate(c *cube.CubieCube) int {
+	twist := 0
+	for i := 0; i < 7; i++ {
+		twist = twist*3 + c.CO[i]
+	}
+	return twist
+}
+
+func flipCoordinate(c *cube.CubieCube) int {
+	flip := 0
+	for i := 0; i < 11; i++ {
+		flip = flip*2 + c.EO[i]
+	}
+	return flip
+}
+
+func binomial(n, k int) int {
+	if k < 0 || k > n {
+		return 0
+	}
+	result := 1
+	for i := 0; i < k; i++ {
+		result = result * (n - i) / (i + 1)
+	}
+	return result
+}
+
+// sliceCoordinate ranks the positions of the four E slice edges, it's 0 when they're in the E slice
+func sliceCoordinate(c *cube.CubieCube) int {
+	slice, found := 0, 0
+	for i := 11; i >= 0; i-- {
+		if c.EP[i] >= 8 {
+			found += 1
+			slice += binomial(11-i, found)
+		}
+	}
+	return slice
+}
+
+// permutationRank gives each permutation of 0..n-1 a unique number, the identity is 0
+func permutationRank(permutation []int) int {
+	rank := 0
+	for i := 0; i < len(permutation); i++ {
+		smaller := 0
+		for j := i + 1; j < len(permutation); j++ {
+			if permutation[j] < permutation[i] {
+				smaller += 1
+			}
+		}
+		rank = rank*(len(permutation)-i) + smaller
+	}
+	return rank
+}
+
+func cornerPermCoordinate(c *cube.CubieCube) int {
+	return permutationRank(c.CP[:])
+}
+
+func edgePermCoordinate(c *cube.CubieCube) int {
+	return permutationRank(c.EP[:8])
+}
+
+func slicePermCoordinate(c *cube.CubieCube) int {
+	var slice [4]int
+	for i := range slice {
+		slice[i] = c.EP[8+i] - 8
+	}
+	return permutationRank(slice[:])
+}
+
+// buildMoveTable finds how each move changes a coordinate, by searching outwards from
+// the solved cube and keeping one cube for each value of the coordinate
+func buildMoveTable(size int, coordinate func(*cube.CubieCube) int, moves []*cube.CubieCube) [][]uint16 {
+	table := make([][]uint16, size)
+	solved := cube.NewSolvedCubieCube()
+	representatives := []*cube.CubieCube{solved}
+	seen := make([]bool, size)
+	seen[coordinate(solved)] = true
+	for len(representatives) > 0 {
+		c := representatives[0]
+		representatives = representatives[1:]
+		from := coordinate(c)
+		table[from] = make([]uint16, len(moves))
+		for m, move := range moves {
+			next := c.Multiply(move)
+			to := coordinate(next)
+			table[from][m] = uint16(to)
+			if !seen[to] {
+				seen[to] = true
+				representatives = append(representatives, next)
+			}
+		}
+	}
+	return table
+}
+
+// buildPruningTable stores the number of moves needed to solve two coordinates at once,
+// a lower bound on the moves needed to solve the cube
+func buildPruningTable(tableA, tableB [][]uint16) []int8 {
+	sizeB := len(tableB)
+	pruning := make([]int8, len(tableA)*sizeB)
+	for i := range pruning {
+		pruning[i] = -1
+	}
+	pruning[0] = 0
+	frontier := []int{0}
+	for depth := int8(0); len(frontier) > 0; depth++ {
+		var next []int
+		for _, index := range frontier {
+			a, b := index/sizeB, index%sizeB
+			for m := range tableA[a] {
+				child := int(tableA[a][m])*sizeB + int(tableB[b][m])
+				if pruning[child] == -1 {
+					pruning[child] = depth + 1
+					next = append(next, child)
+				}
+			}
+		}
+		frontier = next
+	}
+	return pruning
+}
+
+type twoPhaseTables struct {
+	moves           []*cube.CubieCube
+	twistMove       [][]uint16
+	flipMove        [][]uint16
+	sliceMove       [][]uint16
+	cornerPermMove  [][]uint16 // phase two moves only
+	edgePermMove    [][]uint16
+	slicePermMove   [][]uint16
+	twistSlicePrune []int8
+	flipSlicePrune  []int8
+	cornerPermPrune []int8
+	edgePermPrune   []int8
+}
+
+var twoPhaseTablesOnce sync.Once
+var twoPhase *twoPhaseTables
+
+// loadTwoPhaseTables builds the tables the first time they're needed, which takes around a second
+func loadTwoPhaseTables() *twoPhaseTables {
+	twoPhaseTablesOnce.Do(func() {
+		t := new(twoPhaseTables)
+		t.moves = make([]*cube.CubieCube, moveCount)
+		for m := range t.moves {
+			t.moves[m] = cube.NewSolvedCubieCube()
+			t.moves[m].Transform(twoPhaseMoveTransform(m))
+		}
+		phaseTwo := make([]*cube.CubieCube, len(phaseTwoMoves))
+		for i, m := range phaseTwoMoves {
+			phaseTwo[i] = t.moves[m]
+		}
+
+		t.twistMove = buildMoveTable(twistCount, twistCoordinate, t.moves)
+		t.flipMove = buildMoveTable(flipCount, flipCoordinate, t.moves)
+		t.sliceMove = buildMoveTable(sliceCount, sliceCoordinate, t.moves)
+		t.cornerPermMove = buildMoveTable(cornerPermCount, cornerPermCoordinate, phaseTwo)
+		t.edgePermMove = buildMoveTable(edgePermCount, edgePermCoordinate, phaseTwo)
+		t.slicePermMove = buildMoveTable(slicePermCount, slicePermCoordinate, phaseTwo)
+
+		t.twistSlicePrune = buildPruningTable(t.twistMove, t.sliceMove)
+		t.flipSlicePrune = buildPruningTable(t.flipMove, t.sliceMove)
+		t.cornerPermPrune = buildPruningTable(t.cornerPermMove, t.slicePermMove)
+		t.edgePermPrune = buildPruningTable(t.edgePermMove, t.slicePermMove)
+		twoPhase = t
+	})
+	return twoPhase
+}
+
+func maxDistance(a, b int8) int8 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// WarmUpTwoPhase builds the tables ahead of time so the first solve is quick
+func WarmUpTwoPhase() {
+	loadTwoPhaseTables()
+}
+
+var errTwoPhaseTimeout = errors.New("two phase search timed out")
+
+type twoPhaseSearch struct {
+	tables    *twoPhaseTables
+	start     *cube.CubieCube
+	maxLength int
+	deadline  time.Time
+	nodes     int
+	moves     []int
+}
+
+func (s *twoPhaseSearch) timedOut() bool {
+	s.nodes += 1
+	return s.nodes%4096 == 0 && time.Now().After(s.deadline)
+}
+
+func (s *twoPhaseSearch) phaseOne(twist, flip, slice, togo, lastFace int) (bool, error) {
+	if togo == 0 {
+		if twist != 0 || flip != 0 || slice != 0 {
+			return false, nil
+		}
+		// a phase one solution ending in a phase two move was already tried with fewer moves
+		if len(s.moves) > 0 {
+			last := s.moves[len(s.moves)-1]
+			for _, m := range phaseTwoMoves {
+				if m == last {
+					return false, nil
+				}
+			}
+		}
+		return s.startPhaseTwo()
+	}
+	if s.timedOut() {
+		return false, errTwoPhaseTimeout
+	}
+	t := s.tables
+	for m := 0; m < moveCount; m++ {
+		if bannedAfter(lastFace, m/3) {
+			continue
+		}
+		nextTwist, nextFlip, nextSlice := int(t.twistMove[twist][m]), int(t.flipMove[flip][m]), int(t.sliceMove[slice][m])
+		distance := maxDistance(t.twistSlicePrune[nextTwist*sliceCount+nextSlice], t.flipSlicePrune[nextFlip*sliceCount+nextSlice])
+		if int(distance) > togo-1 {
+			continue
+		}
+		s.moves = append(s.moves, m)
+		found, err := s.phaseOne(nextTwist, nextFlip, nextSlice, togo-1, m/3)
+		if found || err != nil {
+			return found, err
+		}
+		s.moves = s.moves[:len(s.moves)-1]
+	}
+	return false, nil
+}
+
+func (s *twoPhaseSearch) startPhaseTwo() (bool, error) {
+	c := s.start
+	for _, m := range s.moves {
+		c = c.Multiply(s.tables.moves[m])
+	}
+	cornerPerm, edgePerm, slicePerm := cornerPermCoordinate(c), edgePermCoordinate(c), slicePermCoordinate(c)
+	lastFace := -1
+	if len(s.moves) > 0 {
+		lastFace = s.moves[len(s.moves)-1] / 3
+	}
+	phaseOneLength := len(s.moves)
+	for depth := 0; phaseOneLength+depth <= s.maxLength; depth++ {
+		found, err := s.phaseTwo(cornerPerm, edgePerm, slicePerm, depth, lastFace)
+		if found || err != nil {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (s *twoPhaseSearch) phaseTwo(cornerPerm, edgePerm, slicePerm, togo, lastFace int) (bool, error) {
+	if togo == 0 {
+		return cornerPerm == 0 && edgePerm == 0 && slicePerm == 0, nil
+	}
+	if s.timedOut() {
+		return false, errTwoPhaseTimeout
+	}
+	t := s.tables
+	for i, m := range phaseTwoMoves {
+		if bannedAfter(lastFace, m/3) {
+			continue
+		}
+		nextCornerPerm, nextEdgePerm, nextSlicePerm := int(t.cornerPermMove[cornerPerm][i]), int(t.edgePermMove[edgePerm][i]), int(t.slicePermMove[slicePerm][i])
+		distance := maxDistance(t.cornerPermPrune[nextCornerPerm*slicePermCount+nextSlicePerm], t.edgePermPrune[nextEdgePerm*slicePermCount+nextSlicePerm])
+		if int(distance) > togo-1 {
+			continue
+		}
+		s.moves = append(s.moves, m)
+		found, err := s.phaseTwo(nextCornerPerm, nextEdgePerm, nextSlicePerm, togo-1, m/3)
+		if found || err != nil {
+			return found, err
+		}
+		s.moves = s.moves[:len(s.moves)-1]
+	}
+	return false, nil
+}
+
+// toCubieCube gets the cubies of either representation, failing for layouts that aren't valid cubes
+func toCubieCube(state cube.State) (*cube.CubieCube, bool) {
+	switch c := state.(type) {
+	case *cube.CubieCube:
+		return c, true
+	case *cube.Cube:
+		cubieCube, err := c.ToCubieCube()
+		return cubieCube, err == nil
+	}
+	return nil, false
+}
+
+// SolveCubeByTwoPhase finds a solution of at most maxLength half turn metric moves
+// without needing a database. Solutions are usually found in a few milliseconds for
+// a maxLength of 24 but aren't optimal. It gives up when the timeout is reached
+func SolveCubeByTwoPhase(state cube.State, maxLength int, timeout time.Duration) (string, bool) {
+	start, ok := toCubieCube(state)
+	if !ok {
+		return "", false
+	}
+
+	s := &twoPhaseSearch{
+		tables:    loadTwoPhaseTables(),
+		start:     start,
+		maxLength: maxLength,
+		deadline:  time.Now().Add(timeout),
+	}
+	twist, flip, slice := twistCoordinate(start), flipCoordinate(start), sliceCoordinate(start)
+	for depth := 0; depth <= maxLength; depth++ {
+		found, err := s.phaseOne(twist, flip, slice, depth, -1)
+		if err != nil {
+			return "", false
+		}
+		if found {
+			solution := strings.Builder{}
+			for _, m := range s.moves {
+				solution.WriteString(twoPhaseMoveTransform(m))
+			}
+			return solution.String(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"github.com/matthewjackswann/rubiks/cube"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTwoPhaseCoordinatesSolved(t *testing.T) {
+	solved := cube.NewSolvedCubieCube()
+	coordinates := []int{twistCoordinate(solved), flipCoordinate(solved), sliceCoordinate(solved),
+		cornerPermCoordinate(solved), edgePermCoordinate(solved), slicePermCoordinate(solved)}
+	for i, coordinate := range coordinates {
+		if coordinate != 0 {
+			t.Errorf("Coordinate %d of the solved cube is %d rather than 0", i, coordinate)
+		}
+	}
+}
+
+func TestTwoPhaseMoveTablesComplete(t *testing.T) {
+	tables := loadTwoPhaseTables()
+	for name, pruning := range map[string][]int8{
+		"twist slice": tables.twistSlicePrune,
+		"flip slice":  tables.flipSlicePrune,
+		"corner perm": tables.cornerPermPrune,
+		"edge perm":   tables.edgePermPrune,
+	} {
+		for i, distance := range pruning {
+			if distance < 0 {
+				t.Errorf("Entry %d of the %s pruning table wasn't reached", i, name)
+				break
+			}
+		}
+	}
+}
+
+func TestSolveCubeByTwoPhase(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	moves := []rune("FfLlRrBbUuDd")
+	for i := 0; i < 50; i++ {
+		scramble := make([]rune, 30)
+		for j := range scramble {
+			scramble[j] = moves[r.Intn(len(moves))]
+		}
+		c := cube.NewSolvedCube()
+		c.Transform(string(scramble))
+
+		solution, found := SolveCubeByTwoPhase(c, 24, 5*time.Second)
+		if !found {
+			t.Errorf("No solution found for scramble %s", string(scramble))
+			continue
+		}
+		if length := cube.HalfTurnMetric.Length(solution); length > 24 {
+			t.Errorf("Solution %s for scramble %s is %d moves long", solution, string(scramble), length)
+		}
+		c.Transform(solution)
+		if !c.IsSolved() {
+			t.Errorf("Solution %s doesn't solve scramble %s", solution, string(scramble))
+		}
+	}
+}
+
+func TestSolveCubeByTwoPhase_Solved(t *testing.T) {
+	solution, found := SolveCubeByTwoPhase(cube.NewSolvedCubieCube(), 24, time.Second)
+	if !found || solution != "" {
+		t.Errorf("The solved cube should have an empty solution but got %q", solution)
+	}
+}
+
+func BenchmarkSolveCubeByTwoPhase(b *testing.B) {
+	loadTwoPhaseTables()
+	r := rand.New(rand.NewSource(0))
+	moves := []rune("FfLlRrBbUuDd")
+	cubes := make([]*cube.Cube, 16)
+	for i := range cubes {
+		scramble := make([]rune, 30)
+		for j := range scramble {
+			scramble[j] = moves[r.Intn(len(moves))]
+		}
+		cubes[i] = cube.NewSolvedCube()
+		cubes[i].Transform(string(scramble))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := cubes[i%len(cubes)]
+		if _, found := SolveCubeByTwoPhase(cube.NewCube(c.Layout), 24, 5*time.Second); !found {
+			b.Fatal("Every scramble should be solved within 24 moves")
+		}
+	}
+}